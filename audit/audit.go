@@ -0,0 +1,310 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffixLayout is appended to the audit file name when it is rotated. The layout sorts
+// lexicographically in time order, which is what pruning and verification rely on.
+const rotatedSuffixLayout = "20060102T150405.000000000Z"
+
+// Config controls where and how the audit log is written.
+type Config struct {
+	// Path of the active JSONL audit file. Rotated files are written next to it.
+	Path string
+	// MaxBytes is the size at which the active file is rotated. Zero disables rotation.
+	MaxBytes int64
+	// MaxBackups is the number of rotated files to keep. Zero keeps all of them.
+	MaxBackups int
+	// ParseableURL and ParseableUser identify the upstream instance and the credentials used for every call.
+	ParseableURL  string
+	ParseableUser string
+}
+
+// Client is the MCP client implementation reported by the session during initialize.
+type Client struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Entry is a single audit record. Each entry carries the hash of the previous entry so that
+// removing, reordering or editing a line breaks the chain.
+type Entry struct {
+	Seq           uint64          `json:"seq"`
+	Time          time.Time       `json:"time"`
+	Principal     string          `json:"principal,omitempty"`
+	SessionID     string          `json:"session_id,omitempty"`
+	Client        *Client         `json:"client,omitempty"`
+	Tool          string          `json:"tool,omitempty"`
+	Prompt        string          `json:"prompt,omitempty"`
+	Arguments     json.RawMessage `json:"arguments,omitempty"`
+	ParseableURL  string          `json:"parseable_url,omitempty"`
+	ParseableUser string          `json:"parseable_user,omitempty"`
+	Endpoints     []string        `json:"endpoints,omitempty"`
+	Outcome       string          `json:"outcome"`
+	Error         string          `json:"error,omitempty"`
+	DurationMs    int64           `json:"duration_ms"`
+	PrevHash      string          `json:"prev_hash"`
+	Hash          string          `json:"hash,omitempty"`
+}
+
+// Logger appends hash chained entries to a JSONL file.
+type Logger struct {
+	cfg      Config
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	seq      uint64
+	lastHash string
+}
+
+// Open opens (or creates) the audit file and restores the hash chain from the last entry
+// already written, so restarts and rotations do not break the chain.
+func Open(cfg Config) (*Logger, error) {
+	l := &Logger{cfg: cfg}
+	last, err := lastEntry(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		rotated, err := rotatedFiles(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(rotated) > 0 {
+			if last, err = lastEntry(rotated[len(rotated)-1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if last != nil {
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record completes the entry with sequence number and hashes and appends it to the file.
+func (l *Logger) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.ParseableURL = l.cfg.ParseableURL
+	e.ParseableUser = l.cfg.ParseableUser
+	e.PrevHash = l.lastHash
+	hash, err := hashEntry(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.cfg.MaxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.cfg.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq = e.Seq
+	l.lastHash = e.Hash
+	return nil
+}
+
+// Close closes the active audit file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *Logger) openFile() error {
+	f, err := os.OpenFile(l.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := l.cfg.Path + "." + time.Now().UTC().Format(rotatedSuffixLayout)
+	if err := os.Rename(l.cfg.Path, rotated); err != nil {
+		return err
+	}
+	if l.cfg.MaxBackups > 0 {
+		files, err := rotatedFiles(l.cfg.Path)
+		if err != nil {
+			return err
+		}
+		for len(files) > l.cfg.MaxBackups {
+			if err := os.Remove(files[0]); err != nil {
+				return err
+			}
+			files = files[1:]
+		}
+	}
+	return l.openFile()
+}
+
+// Verify checks the hash chain of every rotated file and the active file, oldest first.
+// It returns the number of entries verified. The chain must start at seq 1 with an empty
+// prev_hash, unless cfg.MaxBackups is set and that many rotated files remain: pruning then
+// removed the start of the chain, so the first prev_hash of the oldest file is taken on trust.
+func Verify(cfg Config) (int, error) {
+	files, err := rotatedFiles(cfg.Path)
+	if err != nil {
+		return 0, err
+	}
+	pruned := cfg.MaxBackups > 0 && len(files) >= cfg.MaxBackups
+	if _, err := os.Stat(cfg.Path); err == nil {
+		files = append(files, cfg.Path)
+	}
+
+	total := 0
+	prevHash := ""
+	for i, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return total, err
+		}
+		n, last, err := verifyReader(f, prevHash, i == 0, i == 0 && pruned)
+		_ = f.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", name, err)
+		}
+		prevHash = last
+	}
+	return total, nil
+}
+
+// verifyReader checks the entries of one file against the hash of the entry before them.
+// In the first file the chain must start at seq 1, unless its first prev_hash is trusted.
+func verifyReader(r io.Reader, prevHash string, first bool, trustFirst bool) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n, prevHash, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		if first && n == 0 && !trustFirst && e.Seq != 1 {
+			return n, prevHash, fmt.Errorf("line %d (seq %d): chain broken, the log does not start at seq 1", n+1, e.Seq)
+		}
+		if !(trustFirst && n == 0) && e.PrevHash != prevHash {
+			return n, prevHash, fmt.Errorf("line %d (seq %d): chain broken, prev_hash does not match previous entry", n+1, e.Seq)
+		}
+		want := e.Hash
+		got, err := hashEntry(e)
+		if err != nil {
+			return n, prevHash, err
+		}
+		if got != want {
+			return n, prevHash, fmt.Errorf("line %d (seq %d): hash mismatch, entry was modified", n+1, e.Seq)
+		}
+		prevHash = want
+		n++
+	}
+	return n, prevHash, scanner.Err()
+}
+
+// hashEntry returns the hex encoded SHA-256 of the entry serialized without its own hash.
+// The entry includes prev_hash, which is what chains the records together.
+func hashEntry(e Entry) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func lastEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return nil, fmt.Errorf("audit log %s: last entry is not valid JSON: %w", path, err)
+	}
+	return &e, nil
+}
+
+// rotatedFiles returns the files rotated from path, oldest first. Only names with the suffix
+// written by rotate match, so other files next to the log, such as backups, are left alone.
+func rotatedFiles(path string) ([]string, error) {
+	candidates, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range candidates {
+		if _, err := time.Parse(rotatedSuffixLayout, strings.TrimPrefix(name, path+".")); err == nil {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
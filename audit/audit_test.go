@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeEntries records n entries with a logger for path and closes it.
+func writeEntries(t *testing.T, cfg Config, n int) {
+	t.Helper()
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		args, _ := json.Marshal(map[string]interface{}{"query": "SELECT * FROM logs", "i": i})
+		if err := l.Record(Entry{Tool: "query_data_stream", Arguments: args, Outcome: "success"}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(lines [][]byte) [][]byte
+		wantErr string
+	}{
+		{
+			name:   "untouched",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+		},
+		{
+			name: "edited field",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"outcome":"success"`), []byte(`"outcome":"error"`), 1)
+				return lines
+			},
+			wantErr: "hash mismatch",
+		},
+		{
+			name: "edited field with recomputed hash",
+			tamper: func(lines [][]byte) [][]byte {
+				var e Entry
+				_ = json.Unmarshal(lines[1], &e)
+				e.Tool = "delete_data_stream"
+				e.Hash, _ = hashEntry(e)
+				lines[1], _ = json.Marshal(e)
+				return lines
+			},
+			wantErr: "chain broken",
+		},
+		{
+			name:    "removed line",
+			tamper:  func(lines [][]byte) [][]byte { return append(lines[:1], lines[2:]...) },
+			wantErr: "chain broken",
+		},
+		{
+			name:    "removed head line",
+			tamper:  func(lines [][]byte) [][]byte { return lines[1:] },
+			wantErr: "does not start at seq 1",
+		},
+		{
+			name: "reordered lines",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantErr: "chain broken",
+		},
+		{
+			name: "invalid JSON",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[2] = []byte("{")
+				return lines
+			},
+			wantErr: "line 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			writeEntries(t, Config{Path: path}, 4)
+			writeLines(t, path, tt.tamper(readLines(t, path)))
+
+			n, err := Verify(Config{Path: path})
+			if tt.wantErr == "" {
+				if err != nil || n != 4 {
+					t.Fatalf("Verify = %d, %v; want 4 entries and no error", n, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Verify error = %v; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChainContinuesAcrossRestartsAndRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		sessions   []int
+		wantFiles  int
+		wantTotal  int
+		wantSeqEnd uint64
+	}{
+		{name: "restarts without rotation", sessions: []int{3, 2}, wantFiles: 1, wantTotal: 5, wantSeqEnd: 5},
+		{name: "rotation on every entry", cfg: Config{MaxBytes: 1}, sessions: []int{3, 2}, wantFiles: 5, wantTotal: 5, wantSeqEnd: 5},
+		// The oldest remaining file starts mid chain; its first prev_hash is trusted
+		{name: "pruned backups", cfg: Config{MaxBytes: 1, MaxBackups: 2}, sessions: []int{4, 2}, wantFiles: 3, wantTotal: 3, wantSeqEnd: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Path = filepath.Join(t.TempDir(), "audit.jsonl")
+			for _, n := range tt.sessions {
+				writeEntries(t, cfg, n)
+			}
+			rotated, err := rotatedFiles(cfg.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(rotated) + 1; got != tt.wantFiles {
+				t.Errorf("files = %d, want %d", got, tt.wantFiles)
+			}
+			n, err := Verify(cfg)
+			if err != nil || n != tt.wantTotal {
+				t.Fatalf("Verify = %d, %v; want %d entries and no error", n, err, tt.wantTotal)
+			}
+			last, err := lastEntry(cfg.Path)
+			if err != nil || last == nil {
+				t.Fatalf("lastEntry = %v, %v", last, err)
+			}
+			if last.Seq != tt.wantSeqEnd {
+				t.Errorf("last seq = %d, want %d", last.Seq, tt.wantSeqEnd)
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsRemovedRotatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, Config{Path: path, MaxBytes: 1}, 4)
+	rotated, err := rotatedFiles(path)
+	if err != nil || len(rotated) != 3 {
+		t.Fatalf("rotated files = %v, %v; want 3", rotated, err)
+	}
+	if err := os.Remove(rotated[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(Config{Path: path}); err == nil || !strings.Contains(err.Error(), "chain broken") {
+		t.Fatalf("Verify error = %v; want a broken chain", err)
+	}
+}
+
+func TestRotatedFilesIgnoresOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, Config{Path: path, MaxBytes: 1}, 3)
+	for _, name := range []string{".bak", ".swp", ".20260101"} {
+		if err := os.WriteFile(path+name, []byte("not an audit log\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := rotatedFiles(path)
+	if err != nil || len(rotated) != 2 {
+		t.Fatalf("rotated files = %v, %v; want 2", rotated, err)
+	}
+	if n, err := Verify(Config{Path: path}); err != nil || n != 3 {
+		t.Fatalf("Verify = %d, %v; want 3 entries and no error", n, err)
+	}
+}
+
+func TestTrustedProxiesPrincipal(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		proxies TrustedProxies
+		remote  string
+		want    string
+	}{
+		{"trusted network", proxies, "10.1.2.3:5000", "alice"},
+		{"trusted address", proxies, "127.0.0.1:5000", "alice"},
+		{"trusted IPv6 address", proxies, "[::1]:5000", "alice"},
+		{"IPv4-mapped address", proxies, "[::ffff:10.0.0.1]:5000", "alice"},
+		{"untrusted address", proxies, "192.168.1.1:5000", ""},
+		{"no trusted proxies", nil, "127.0.0.1:5000", ""},
+		{"invalid remote address", proxies, "pipe", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/mcp", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("X-Forwarded-User", "alice")
+			if got := tt.proxies.Principal(r, "X-Forwarded-User"); got != tt.want {
+				t.Errorf("Principal = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid network")
+	}
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type principalKey struct{}
+
+type recorderKey struct{}
+
+// recorder collects the Parseable endpoints hit while a single tool call runs.
+type recorder struct {
+	mu        sync.Mutex
+	endpoints []string
+}
+
+// WithPrincipal returns a context carrying the identity of the caller, as established by the
+// transport (e.g. a header set by an authenticating reverse proxy).
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// NoteEndpoint records a Parseable API call made on behalf of the current tool call.
+// It is a no-op when auditing is disabled.
+func NoteEndpoint(ctx context.Context, method string, path string) {
+	rec, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	rec.endpoints = append(rec.endpoints, method+" "+path)
+	rec.mu.Unlock()
+}
+
+// Middleware returns a tool handler middleware that writes one audit entry per tool call.
+func Middleware(l *Logger) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			ctx, finish := l.begin(ctx, Entry{Tool: req.Params.Name}, req.GetRawArguments())
+			result, err := next(ctx, req)
+			errText := ""
+			if err == nil && result != nil && result.IsError {
+				errText = resultText(result)
+			}
+			finish(err, errText)
+			return result, err
+		}
+	}
+}
+
+// PromptMiddleware returns a prompt handler middleware that writes one audit entry per prompt
+// request. Only prompts that call Parseable while building the prompt need it, but wrapping
+// all of them keeps the record complete.
+func PromptMiddleware(l *Logger) func(server.PromptHandlerFunc) server.PromptHandlerFunc {
+	return func(next server.PromptHandlerFunc) server.PromptHandlerFunc {
+		return func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			var args interface{}
+			if req.Params.Arguments != nil {
+				args = req.Params.Arguments
+			}
+			ctx, finish := l.begin(ctx, Entry{Prompt: req.Params.Name}, args)
+			result, err := next(ctx, req)
+			finish(err, "")
+			return result, err
+		}
+	}
+}
+
+// begin starts an audit entry for a call and returns the context to run the call with and
+// a function that completes and writes the entry.
+func (l *Logger) begin(ctx context.Context, entry Entry, args interface{}) (context.Context, func(err error, errText string)) {
+	rec := &recorder{}
+	ctx = context.WithValue(ctx, recorderKey{}, rec)
+	start := time.Now()
+
+	entry.Time = start.UTC()
+	entry.Principal, _ = ctx.Value(principalKey{}).(string)
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		entry.SessionID = session.SessionID()
+		if withInfo, ok := session.(server.SessionWithClientInfo); ok {
+			info := withInfo.GetClientInfo()
+			if info.Name != "" || info.Version != "" {
+				entry.Client = &Client{Name: info.Name, Version: info.Version}
+			}
+		}
+	}
+	if args != nil {
+		entry.Arguments = recordedArguments(entry.Tool, args)
+	}
+
+	return ctx, func(err error, errText string) {
+		entry.DurationMs = time.Since(start).Milliseconds()
+		rec.mu.Lock()
+		entry.Endpoints = append([]string(nil), rec.endpoints...)
+		rec.mu.Unlock()
+
+		entry.Outcome = "success"
+		switch {
+		case err != nil:
+			entry.Outcome = "error"
+			entry.Error = err.Error()
+		case errText != "":
+			entry.Outcome = "error"
+			entry.Error = errText
+		}
+
+		if recordErr := l.Record(entry); recordErr != nil {
+			slog.Error("failed to write audit entry", "tool", entry.Tool, "prompt", entry.Prompt, "error", recordErr)
+		}
+	}
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	for _, content := range result.Content {
+		if text, ok := mcp.AsTextContent(content); ok {
+			return text.Text
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the addresses of the reverse proxies allowed to name the caller in a
+// request header. Any client can send the header, so it is only read from these.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR networks,
+// e.g. "10.0.0.0/8, 127.0.0.1".
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Principal returns the caller named in header if the request comes from a trusted proxy,
+// and an empty string otherwise.
+func (p TrustedProxies) Principal(r *http.Request, header string) string {
+	if len(p) == 0 || header == "" {
+		return ""
+	}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	addr := remote.Addr().Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return r.Header.Get(header)
+		}
+	}
+	return ""
+}
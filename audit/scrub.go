@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactedValue replaces the secrets in recorded arguments.
+	RedactedValue = "[REDACTED]"
+	// maxArgumentBytes caps the size of the arguments of an entry. Larger arguments are
+	// recorded by their size and hash, which still ties the entry to the data sent.
+	maxArgumentBytes = 16 << 10
+)
+
+// sensitiveKeys are argument names whose values are redacted at any depth, for every tool.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"apikey":        true,
+	"api_key":       true,
+	"authorization": true,
+	"cookie":        true,
+}
+
+// Scrubber rewrites the arguments of a call before they are recorded, e.g. to redact the
+// secrets a tool takes. The arguments are a copy; the call itself sees the originals.
+type Scrubber func(args map[string]interface{})
+
+var (
+	scrubbersMu sync.RWMutex
+	scrubbers   = map[string]Scrubber{}
+)
+
+// RegisterScrubber sets the scrubber for the arguments of a tool. Since the audit file is
+// append only and hash chained, secrets that reach it can never be removed.
+func RegisterScrubber(tool string, s Scrubber) {
+	scrubbersMu.Lock()
+	scrubbers[tool] = s
+	scrubbersMu.Unlock()
+}
+
+// recordedArguments returns the arguments of a call as written to the audit file: with
+// secrets redacted and, if they are too large, summarized.
+func recordedArguments(tool string, args interface{}) json.RawMessage {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	// Work on a copy, so that scrubbing does not change the arguments of the call
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil
+	}
+	redactSensitiveKeys(tree)
+	if m, ok := tree.(map[string]interface{}); ok {
+		scrubbersMu.RLock()
+		scrub := scrubbers[tool]
+		scrubbersMu.RUnlock()
+		if scrub != nil {
+			scrub(m)
+		}
+	}
+	if raw, err = json.Marshal(tree); err != nil {
+		return nil
+	}
+	if len(raw) <= maxArgumentBytes {
+		return raw
+	}
+
+	// Summarize the largest arguments first, keeping the small ones readable
+	if m, ok := tree.(map[string]interface{}); ok {
+		for len(raw) > maxArgumentBytes {
+			largest, largestSize := "", 0
+			for key, value := range m {
+				valueRaw, _ := json.Marshal(value)
+				if _, summarized := value.(omittedArgument); !summarized && len(valueRaw) > largestSize {
+					largest, largestSize = key, len(valueRaw)
+				}
+			}
+			if largest == "" {
+				break
+			}
+			m[largest] = omitArgument(m[largest])
+			raw, _ = json.Marshal(m)
+		}
+		if len(raw) <= maxArgumentBytes {
+			return raw
+		}
+	}
+	raw, _ = json.Marshal(omitArgument(tree))
+	return raw
+}
+
+// omittedArgument stands for an argument too large to record.
+type omittedArgument struct {
+	Omitted bool   `json:"omitted"`
+	Bytes   int    `json:"bytes"`
+	Items   *int   `json:"items,omitempty"`
+	SHA256  string `json:"sha256"`
+}
+
+func omitArgument(value interface{}) omittedArgument {
+	raw, _ := json.Marshal(value)
+	sum := sha256.Sum256(raw)
+	o := omittedArgument{Omitted: true, Bytes: len(raw), SHA256: hex.EncodeToString(sum[:])}
+	if items, ok := value.([]interface{}); ok {
+		n := len(items)
+		o.Items = &n
+	}
+	return o
+}
+
+func redactSensitiveKeys(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if sensitiveKeys[strings.ToLower(key)] {
+				v[key] = RedactedValue
+				continue
+			}
+			redactSensitiveKeys(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactSensitiveKeys(child)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/user"
+	"strconv"
 
 	"github.com/mark3labs/mcp-go/server"
 
+	"mcp-pb/audit"
 	"mcp-pb/prompts"
+	"mcp-pb/redact"
 	"mcp-pb/tools"
 )
 
@@ -20,6 +26,15 @@ func main() {
 	parseablePassFlag := flag.String("parseable-password", "", "Parseable basic auth password (or set PARSEABLE_PASS env var)")
 	listenAddr := flag.String("listen", ":9034", "address to listen on")
 	logLevel := flag.String("log-level", "info", "log level: debug, info, warn, error (or set LOG_LEVEL env var)")
+	auditLogFlag := flag.String("audit-log", "", "path of the JSONL audit log of tool calls, disabled if empty (or set AUDIT_LOG_FILE env var)")
+	auditMaxSizeFlag := flag.Int64("audit-max-size-mb", 100, "rotate the audit log when it reaches this size in MB, 0 disables rotation (or set AUDIT_LOG_MAX_SIZE_MB env var)")
+	auditMaxBackupsFlag := flag.Int("audit-max-backups", 0, "number of rotated audit logs to keep, 0 keeps all (or set AUDIT_LOG_MAX_BACKUPS env var)")
+	auditPrincipalHeaderFlag := flag.String("audit-principal-header", "X-Forwarded-User", "HTTP header holding the authenticated caller, set by a reverse proxy (or set AUDIT_PRINCIPAL_HEADER env var)")
+	auditTrustedProxiesFlag := flag.String("audit-trusted-proxies", "", "comma separated addresses or CIDR networks of the reverse proxies allowed to set the principal header, the header is ignored if empty (or set AUDIT_TRUSTED_PROXIES env var)")
+	redactionConfigFlag := flag.String("redaction-config", "", "path of a JSON file with PII redaction rules for query results, disabled if empty (or set REDACTION_CONFIG env var)")
+	enableWritesFlag := flag.Bool("enable-writes", false, "allow tools that change Parseable, e.g. creating or deleting alerts (or set PARSEABLE_ENABLE_WRITES env var)")
+	tokenBudgetFlag := flag.Int("result-token-budget", 0, "estimated token size above which query results are summarized, 0 disables (or set RESULT_TOKEN_BUDGET env var)")
+	auditVerify := flag.String("audit-verify", "", "verify the hash chain of the given audit log and its rotated files, then exit")
 	versionFlag := flag.Bool("version", false, "print version and exit")
 	flag.Parse()
 
@@ -55,6 +70,21 @@ func main() {
 		os.Exit(0)
 	}
 
+	auditMaxBackups := *auditMaxBackupsFlag
+	if v, err := strconv.Atoi(os.Getenv("AUDIT_LOG_MAX_BACKUPS")); err == nil {
+		auditMaxBackups = v
+	}
+	if *auditVerify != "" {
+		// With pruned backups the oldest remaining file may start mid chain
+		n, err := audit.Verify(audit.Config{Path: *auditVerify, MaxBackups: auditMaxBackups})
+		if err != nil {
+			slog.Error("audit log verification failed", "path", *auditVerify, "verified_entries", n, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("audit log verified", "path", *auditVerify, "verified_entries", n)
+		os.Exit(0)
+	}
+
 	// Prefer environment variables if set, otherwise use flags or defaults
 	tools.ParseableBaseURL = os.Getenv("PARSEABLE_URL")
 	if tools.ParseableBaseURL == "" {
@@ -86,16 +116,94 @@ func main() {
 		*listenAddr = listenAddrEnv
 	}
 
-	mcpServer := server.NewMCPServer("parseable-mcp", version,
+	tools.WritesEnabled = *enableWritesFlag
+	if v, err := strconv.ParseBool(os.Getenv("PARSEABLE_ENABLE_WRITES")); err == nil {
+		tools.WritesEnabled = v
+	}
+	if tools.WritesEnabled {
+		slog.Warn("write operations are enabled, tools can change Parseable")
+	}
+
+	tools.ResultTokenBudget = *tokenBudgetFlag
+	if v, err := strconv.Atoi(os.Getenv("RESULT_TOKEN_BUDGET")); err == nil {
+		tools.ResultTokenBudget = v
+	}
+
+	redactionConfig := os.Getenv("REDACTION_CONFIG")
+	if redactionConfig == "" {
+		redactionConfig = *redactionConfigFlag
+	}
+	if redactionConfig != "" {
+		redactor, err := redact.Load(redactionConfig)
+		if err != nil {
+			slog.Error("failed to load redaction config", "path", redactionConfig, "error", err)
+			os.Exit(1)
+		}
+		tools.ResultRedactor = redactor
+		slog.Info("result redaction enabled", "path", redactionConfig)
+	}
+
+	auditLog := os.Getenv("AUDIT_LOG_FILE")
+	if auditLog == "" {
+		auditLog = *auditLogFlag
+	}
+	auditMaxSize := *auditMaxSizeFlag
+	if v, err := strconv.ParseInt(os.Getenv("AUDIT_LOG_MAX_SIZE_MB"), 10, 64); err == nil {
+		auditMaxSize = v
+	}
+	auditPrincipalHeader := os.Getenv("AUDIT_PRINCIPAL_HEADER")
+	if auditPrincipalHeader == "" {
+		auditPrincipalHeader = *auditPrincipalHeaderFlag
+	}
+	auditTrustedProxiesValue := os.Getenv("AUDIT_TRUSTED_PROXIES")
+	if auditTrustedProxiesValue == "" {
+		auditTrustedProxiesValue = *auditTrustedProxiesFlag
+	}
+	auditTrustedProxies, err := audit.ParseTrustedProxies(auditTrustedProxiesValue)
+	if err != nil {
+		slog.Error("invalid audit trusted proxies", "error", err)
+		os.Exit(1)
+	}
+
+	serverOptions := []server.ServerOption{
 		server.WithRecovery(),
 		server.WithLogging(),
-		server.WithInstructions(`
+	}
+	if auditLog != "" {
+		auditLogger, err := audit.Open(audit.Config{
+			Path:          auditLog,
+			MaxBytes:      auditMaxSize * 1024 * 1024,
+			MaxBackups:    auditMaxBackups,
+			ParseableURL:  tools.ParseableBaseURL,
+			ParseableUser: tools.ParseableUser,
+		})
+		if err != nil {
+			slog.Error("failed to open audit log", "path", auditLog, "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := auditLogger.Close(); err != nil {
+				slog.Error("failed to close audit log", "error", err)
+			}
+		}()
+		serverOptions = append(serverOptions, server.WithToolHandlerMiddleware(audit.Middleware(auditLogger)))
+		prompts.PromptMiddleware = audit.PromptMiddleware(auditLogger)
+		slog.Info("audit log enabled", "path", auditLog)
+		if *mode != "stdio" && len(auditTrustedProxies) == 0 {
+			slog.Warn("no audit trusted proxies set, the principal header is ignored", "header", auditPrincipalHeader)
+		}
+	}
+
+	mcpServer := server.NewMCPServer("parseable-mcp", version,
+		append(serverOptions,
+			server.WithInstructions(`
 You are Virtual Assistant, a tool for interacting with Parseable API and documentation in different tasks related to monitoring and observability.
 
 You have many tools to get data from Parseable, but try to specify the query as accurately as possible.
 
 Try not to second guess information - if you don't know something or lack information, it's better to ask.
 	`),
+		)...,
 	)
 
 	tools.RegisterParseableTools(mcpServer)
@@ -103,14 +211,25 @@ Try not to second guess information - if you don't know something or lack inform
 
 	if *mode == "stdio" {
 		slog.Info("MCP server running in stdio mode", "parseable_url", tools.ParseableBaseURL)
-		if err := server.ServeStdio(mcpServer); err != nil {
+		// In stdio mode the caller is the local OS user running the server
+		stdioPrincipal := ""
+		if u, err := user.Current(); err == nil {
+			stdioPrincipal = u.Username
+		}
+		if err := server.ServeStdio(mcpServer, server.WithStdioContextFunc(func(ctx context.Context) context.Context {
+			return audit.WithPrincipal(ctx, stdioPrincipal)
+		})); err != nil {
 			slog.Error("MCP stdio server failed", "error", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	httpServer := server.NewStreamableHTTPServer(mcpServer,
+		server.WithHTTPContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+			return audit.WithPrincipal(ctx, auditTrustedProxies.Principal(r, auditPrincipalHeader))
+		}),
+	)
 	slog.Info("MCP server running", "address", *listenAddr, "parseable_url", tools.ParseableBaseURL)
 	if err := httpServer.Start(*listenAddr); err != nil {
 		slog.Error("MCP server failed", "error", err)
@@ -2,11 +2,18 @@ package prompts
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/tools"
 )
 
+// PromptMiddleware, if set by main.go before calling RegisterParseablePrompts, wraps every
+// prompt handler (e.g. for auditing).
+var PromptMiddleware func(server.PromptHandlerFunc) server.PromptHandlerFunc
+
 // RegisterParseablePrompts registers all prompts with the MCP server
 func RegisterParseablePrompts(mcpServer *server.MCPServer) {
 	registerAnalyzeErrorsPrompt(mcpServer)
@@ -14,13 +21,21 @@ func RegisterParseablePrompts(mcpServer *server.MCPServer) {
 	registerInvestigateFieldPrompt(mcpServer)
 	registerCompareStreamsPrompt(mcpServer)
 	registerFindAnomaliesPrompt(mcpServer)
+	registerExplainAlertPrompt(mcpServer)
+}
+
+func addPrompt(mcpServer *server.MCPServer, prompt mcp.Prompt, handler server.PromptHandlerFunc) {
+	if PromptMiddleware != nil {
+		handler = PromptMiddleware(handler)
+	}
+	mcpServer.AddPrompt(prompt, handler)
 }
 
 func registerAnalyzeErrorsPrompt(mcpServer *server.MCPServer) {
-	mcpServer.AddPrompt(mcp.NewPrompt(
+	addPrompt(mcpServer, mcp.NewPrompt(
 		"analyze-errors",
 		mcp.WithPromptDescription("Analyze error logs in a data stream over a time range. "+
-			"Gets schema, queries for errors, and provides a summary with patterns and recommendations."),
+			"Gets schema, clusters error messages into patterns, and provides a summary with recommendations."),
 		mcp.WithArgument("streamName", mcp.RequiredArgument(), mcp.ArgumentDescription("Name of the data stream to analyze")),
 		mcp.WithArgument("startTime", mcp.RequiredArgument(), mcp.ArgumentDescription("Start time in ISO 8601 format (e.g., '2026-02-01T00:00:00Z')")),
 		mcp.WithArgument("endTime", mcp.RequiredArgument(), mcp.ArgumentDescription("End time in ISO 8601 format (e.g., '2026-02-13T23:59:59Z')")),
@@ -40,17 +55,23 @@ func registerAnalyzeErrorsPrompt(mcpServer *server.MCPServer) {
 Follow these steps:
 
 1. First, call get_data_stream_schema with streamName="` + streamName + `" to understand available fields.
+   If there is a severity field such as severity_text or level, prefer it for selecting errors below,
+   e.g. severity_text IN ('ERROR', 'FATAL').
 
-2. Query for errors using query_data_stream with:
-   - query: "SELECT * FROM ` + streamName + ` WHERE ` + errorField + ` ILIKE '%error%' OR ` + errorField + ` ILIKE '%exception%' OR ` + errorField + ` ILIKE '%failed%' ORDER BY p_timestamp DESC LIMIT 100"
+2. Find the error patterns using cluster_log_patterns with:
    - streamName: "` + streamName + `"
+   - field: "` + errorField + `"
+   - filter: "` + errorField + ` ILIKE '%error%' OR ` + errorField + ` ILIKE '%exception%' OR ` + errorField + ` ILIKE '%failed%'"
    - startTime: "` + startTime + `"
    - endTime: "` + endTime + `"
 
-3. Analyze the results and provide:
+3. For the top patterns, look at the time distribution and affected components using query_data_stream,
+   e.g. counts per hour with date_trunc('hour', p_timestamp) of rows matching a pattern's fixed words.
+
+4. Analyze the results and provide:
    - Total number of errors found
-   - Common error patterns or messages
-   - Time distribution of errors (any spikes?)
+   - The most frequent error patterns with their counts and an example each
+   - Time distribution of errors (any spikes? patterns first seen recently?)
    - Affected components or services (if identifiable)
    - Recommended next steps for investigation
 
@@ -69,7 +90,7 @@ If no errors are found, confirm this and suggest checking different time ranges
 }
 
 func registerStreamHealthCheckPrompt(mcpServer *server.MCPServer) {
-	mcpServer.AddPrompt(mcp.NewPrompt(
+	addPrompt(mcpServer, mcp.NewPrompt(
 		"stream-health-check",
 		mcp.WithPromptDescription("Perform a health check on a data stream. "+
 			"Analyzes ingestion rates, storage usage, and data freshness to identify potential issues."),
@@ -118,7 +139,7 @@ Present the findings in a clear, actionable format.`
 }
 
 func registerInvestigateFieldPrompt(mcpServer *server.MCPServer) {
-	mcpServer.AddPrompt(mcp.NewPrompt(
+	addPrompt(mcpServer, mcp.NewPrompt(
 		"investigate-field",
 		mcp.WithPromptDescription("Investigate a specific field in a data stream. "+
 			"Analyzes field values, distributions, and patterns over a time range."),
@@ -175,7 +196,7 @@ Present findings in a structured, easy-to-read format.`
 }
 
 func registerCompareStreamsPrompt(mcpServer *server.MCPServer) {
-	mcpServer.AddPrompt(mcp.NewPrompt(
+	addPrompt(mcpServer, mcp.NewPrompt(
 		"compare-streams",
 		mcp.WithPromptDescription("Compare metrics across multiple data streams. "+
 			"Useful for understanding relative activity, storage usage, and data patterns."),
@@ -242,7 +263,7 @@ Format the comparison clearly for easy decision-making.`
 }
 
 func registerFindAnomaliesPrompt(mcpServer *server.MCPServer) {
-	mcpServer.AddPrompt(mcp.NewPrompt(
+	addPrompt(mcpServer, mcp.NewPrompt(
 		"find-anomalies",
 		mcp.WithPromptDescription("Find anomalies and unusual patterns in a data stream over time. "+
 			"Looks for spikes, drops, and irregular patterns in event volumes."),
@@ -260,27 +281,28 @@ func registerFindAnomaliesPrompt(mcpServer *server.MCPServer) {
 			groupBy = "hour"
 		}
 
-		// Determine the date_trunc interval
-		interval := "hour"
+		// Determine the bucket size
+		interval, step := "hour", "1h"
 		if groupBy == "day" {
-			interval = "day"
+			interval, step = "day", "1d"
 		}
 
 		promptText := `You are analyzing the Parseable data stream "` + streamName + `" for anomalies from ` + startTime + ` to ` + endTime + `.
 
 Follow these steps:
 
-1. Get event counts over time using query_data_stream:
-   - query: "SELECT date_trunc('` + interval + `', p_timestamp) as time_bucket, COUNT(*) as event_count FROM ` + streamName + ` GROUP BY time_bucket ORDER BY time_bucket"
+1. Detect anomalies in the event counts using detect_anomalies with:
    - streamName: "` + streamName + `"
+   - step: "` + step + `"
    - startTime: "` + startTime + `"
    - endTime: "` + endTime + `"
+   It buckets the events per ` + interval + ` and scores every bucket against a rolling mean, the median of the range
+   and the same time on previous days, so the statistics do not need to be computed by hand.
 
-2. Analyze the time series data:
-   - Calculate average event count per ` + interval + `
-   - Identify spikes (periods with significantly higher counts)
-   - Identify drops (periods with significantly lower counts or zero events)
-   - Look for irregular patterns or gaps
+2. Review the results:
+   - Use the baseline (mean, median, spread) as normal activity
+   - Check the flagged spikes and drops, and which detectors flagged them
+   - Note buckets flagged by several detectors or with very high scores as the most significant
 
 3. For any anomalies found, investigate further:
    - Query sample events from anomalous periods
@@ -305,3 +327,73 @@ Present a clear timeline of activity with highlighted anomalies.`
 		), nil
 	})
 }
+
+func registerExplainAlertPrompt(mcpServer *server.MCPServer) {
+	addPrompt(mcpServer, mcp.NewPrompt(
+		"explain-alert",
+		mcp.WithPromptDescription("Explain why an alert fired. "+
+			"Replays the alert query over the evaluation window that ended when the alert fired and asks for an explanation of the result."),
+		mcp.WithArgument("alertId", mcp.RequiredArgument(), mcp.ArgumentDescription("Id of the alert, as returned by list_alerts")),
+		mcp.WithArgument("triggeredAt", mcp.ArgumentDescription("Optional: ISO 8601 time the alert fired (default: last trigger from the alert state history)")),
+	), func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		args := request.Params.Arguments
+		alertID := args["alertId"]
+		triggeredAt := args["triggeredAt"]
+
+		var promptText string
+		replay, err := tools.ReplayAlert(ctx, alertID, triggeredAt)
+		if err == nil {
+			replayJSON, _ := json.MarshalIndent(replay, "", "  ")
+			promptText = `You are explaining why the Parseable alert "` + replay.Alert.Title + `" (id ` + alertID + `) fired.
+
+The alert query was replayed over the evaluation window from ` + replay.WindowStart + ` to ` + replay.WindowEnd + ` on the data stream "` + replay.Stream + `".
+The replay, including the alert definition, is:
+
+` + string(replayJSON) + `
+
+Follow these steps:
+
+1. Explain the alert condition in plain words: the query, the threshold operator and value, and the evaluation window.
+
+2. Compare the replayed value with the threshold. If conditionMet is false or missing, say so and consider that the data
+   may have changed since the alert fired (late arriving events, retention) or that triggerSource is "now" because
+   the trigger time is unknown.
+
+3. Find what drove the value using query_data_stream on "` + replay.Stream + `" over the same window:
+   - Break the alert query down by the most relevant fields (e.g., service, host, status, error message) with GROUP BY
+   - Query a few sample events that contribute to the value
+   - Run the alert query over the window before the trigger for comparison
+
+4. Summarize:
+   - Why the alert fired, with the numbers
+   - The main contributors
+   - Whether it looks like a real incident or noise, and if the threshold may need tuning
+   - Recommended next steps`
+		} else {
+			promptText = `You are explaining why the Parseable alert with id ` + alertID + ` fired.
+The server could not replay the alert query automatically: ` + err.Error() + `
+
+Follow these steps:
+
+1. Call get_alert with alertId="` + alertID + `" to get the query, datasets, threshold and evaluation window.
+
+2. Call get_alert_state_history with alertId="` + alertID + `" to find when the alert last changed to "triggered".
+
+3. Replay the alert query with query_data_stream on the first dataset, with endTime set to the trigger time and
+   startTime set to the trigger time minus the evaluation window (evalConfig.rollingWindow.evalStart).
+
+4. Compare the result with the threshold, break the query down by relevant fields to find the main contributors,
+   and summarize why the alert fired and the recommended next steps.`
+		}
+
+		return mcp.NewGetPromptResult(
+			"Explain alert "+alertID,
+			[]mcp.PromptMessage{
+				mcp.NewPromptMessage(mcp.RoleUser, mcp.TextContent{
+					Type: "text",
+					Text: promptText,
+				}),
+			},
+		), nil
+	})
+}
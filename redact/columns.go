@@ -0,0 +1,221 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// ColumnRule masks whole columns selected by field name and/or schema data type. When both
+// Name and Type are set a column must match both.
+type ColumnRule struct {
+	// Name is a case-insensitive glob on the field name, e.g. "*password*".
+	Name string `json:"name,omitempty"`
+	// Type is a case-insensitive glob on the schema data type, e.g. "Utf8" or "Timestamp*".
+	Type string `json:"type,omitempty"`
+	// Action is one of mask, hash, drop or bucket.
+	Action Action `json:"action"`
+	// Bucket is the prefix length for IP addresses (default /24 for IPv4, /64 for IPv6)
+	// or the bucket width for numbers (default 10).
+	Bucket int `json:"bucket,omitempty"`
+}
+
+func (c ColumnRule) check() error {
+	if c.Name == "" && c.Type == "" {
+		return fmt.Errorf("column rule needs a name or a type")
+	}
+	for _, glob := range []string{c.Name, c.Type} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("column rule %s: invalid glob %q: %w", c.label(), glob, err)
+		}
+	}
+	switch c.Action {
+	case ActionMask, ActionHash, ActionDrop, ActionBucket:
+		return nil
+	}
+	return fmt.Errorf("column rule %s: unknown action %q (supported: mask, hash, drop, bucket)", c.label(), c.Action)
+}
+
+func (c ColumnRule) label() string {
+	if c.Name == "" {
+		return "type=" + c.Type
+	}
+	if c.Type == "" {
+		return c.Name
+	}
+	return c.Name + ",type=" + c.Type
+}
+
+func (c ColumnRule) matches(field string, dataType string) bool {
+	if c.Name != "" {
+		if ok, _ := path.Match(strings.ToLower(c.Name), strings.ToLower(field)); !ok {
+			return false
+		}
+	}
+	if c.Type != "" {
+		if dataType == "" {
+			return false
+		}
+		if ok, _ := path.Match(strings.ToLower(c.Type), strings.ToLower(dataType)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsSchema reports whether column rules for the stream match on data type, in which case
+// the caller should pass the schema field types to Rows.
+func (r *Redactor) NeedsSchema(stream string) bool {
+	if r == nil {
+		return false
+	}
+	for _, c := range r.rules(stream).columns {
+		if c.Type != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemaFieldTypes maps field names to data types from a Parseable schema response.
+// Complex Arrow types such as {"Timestamp": ["Millisecond", null]} are reduced to their name.
+func SchemaFieldTypes(schema map[string]interface{}) map[string]string {
+	types := map[string]string{}
+	fields, _ := schema["fields"].([]interface{})
+	for _, f := range fields {
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := field["name"].(string)
+		types[name] = dataTypeName(field["data_type"])
+	}
+	return types
+}
+
+func dataTypeName(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		for name := range t {
+			return name
+		}
+	}
+	return ""
+}
+
+// Schema marks the fields of a Parseable schema response that are masked for the stream by
+// adding a "masked" key with the action applied to the field.
+func (r *Redactor) Schema(stream string, schema map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	set := r.rules(stream)
+	fields, _ := schema["fields"].([]interface{})
+	for _, f := range fields {
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := field["name"].(string)
+		if action, ok := set.fields[strings.ToLower(name)]; ok {
+			field["masked"] = action
+			continue
+		}
+		if c, ok := set.column(name, dataTypeName(field["data_type"])); ok {
+			field["masked"] = c.Action
+		}
+	}
+}
+
+// FieldAction returns the action the rules for the stream apply to a field with the given
+// schema data type ("" if unknown), and whether any rule applies.
+func (r *Redactor) FieldAction(stream string, field string, dataType string) (Action, bool) {
+	if r == nil {
+		return "", false
+	}
+	set := r.rules(stream)
+	if action, ok := set.fields[strings.ToLower(field)]; ok {
+		return action, true
+	}
+	if c, ok := set.column(field, dataType); ok {
+		return c.Action, true
+	}
+	return "", false
+}
+
+func (set ruleSet) column(field string, dataType string) (ColumnRule, bool) {
+	for _, c := range set.columns {
+		if c.matches(field, dataType) {
+			return c, true
+		}
+	}
+	return ColumnRule{}, false
+}
+
+// redactColumns applies column rules to a row and returns the keys it handled, so value
+// patterns do not run over already masked or bucketed values. Exact field rules win over
+// column rules and are left to redactMap.
+func (r *Redactor) redactColumns(set ruleSet, row map[string]interface{}, types map[string]string, report *Report) map[string]bool {
+	if len(set.columns) == 0 {
+		return nil
+	}
+	handled := map[string]bool{}
+	for key, value := range row {
+		if _, ok := set.fields[strings.ToLower(key)]; ok {
+			continue
+		}
+		c, ok := set.column(key, types[key])
+		if !ok {
+			continue
+		}
+		handled[key] = true
+		if value == nil {
+			continue
+		}
+		report.add("column:"+c.label(), 1)
+		switch c.Action {
+		case ActionDrop:
+			delete(row, key)
+		case ActionBucket:
+			row[key] = bucketValue(c, value)
+		default:
+			row[key] = r.apply(c.Action, key, fmt.Sprint(value))
+		}
+	}
+	return handled
+}
+
+func bucketValue(c ColumnRule, value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		width := float64(c.Bucket)
+		if width <= 0 {
+			width = 10
+		}
+		return math.Floor(v/width) * width
+	case string:
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		bits := c.Bucket
+		if bits <= 0 {
+			bits = 24
+			if addr.Is6() {
+				bits = 64
+			}
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			break
+		}
+		return prefix.String()
+	}
+	// Values that cannot be bucketed are masked rather than leaked
+	return "[REDACTED:" + c.label() + "]"
+}
@@ -0,0 +1,371 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Action is what happens to a value that matched a rule.
+type Action string
+
+const (
+	// ActionMask replaces the matched text with a placeholder naming the rule.
+	ActionMask Action = "mask"
+	// ActionHash replaces the matched text with a salted SHA-256 prefix, so equal values stay correlatable.
+	ActionHash Action = "hash"
+	// ActionDrop removes the whole field from the row.
+	ActionDrop Action = "drop"
+	// ActionBucket coarsens a column value: IP addresses to their network prefix and numbers to
+	// the lower bound of a fixed-width bucket. It is only valid for column rules.
+	ActionBucket Action = "bucket"
+)
+
+// Pattern is a named regular expression rule applied to string values.
+type Pattern struct {
+	Name   string `json:"name"`
+	Regex  string `json:"regex"`
+	Action Action `json:"action,omitempty"`
+}
+
+// Rules is a set of redaction rules. The top level of Config holds the global rules and
+// Streams holds additional rules for individual data streams.
+type Rules struct {
+	// Builtins lists the built-in detectors to run: email, ip, token and credit_card.
+	Builtins []string `json:"builtins,omitempty"`
+	// Patterns are custom regular expressions.
+	Patterns []Pattern `json:"patterns,omitempty"`
+	// Fields maps a field name (case-insensitive) to the action applied to its whole value.
+	Fields map[string]Action `json:"fields,omitempty"`
+	// Columns are rules matching query result columns by name glob and schema data type.
+	Columns []ColumnRule `json:"columns,omitempty"`
+}
+
+// Config is the redaction configuration file format.
+type Config struct {
+	Rules
+	// Action is the default action for builtins and patterns that do not set one (default: mask).
+	Action Action `json:"action,omitempty"`
+	// HashSalt is mixed into hashed values so they cannot be reversed by hashing guesses.
+	HashSalt string `json:"hashSalt,omitempty"`
+	// Streams holds per-stream rules. Builtins set for a stream replace the global list,
+	// patterns are added to the global ones and field rules override global field rules.
+	Streams map[string]Rules `json:"streams,omitempty"`
+}
+
+// Report summarizes the redactions applied to a result.
+type Report struct {
+	Total  int            `json:"total"`
+	ByRule map[string]int `json:"byRule,omitempty"`
+}
+
+func (r *Report) add(rule string, n int) {
+	if n == 0 {
+		return
+	}
+	if r.ByRule == nil {
+		r.ByRule = map[string]int{}
+	}
+	r.Total += n
+	r.ByRule[rule] += n
+}
+
+// Merge adds the counts of another report, e.g. of a second query of the same result.
+func (r *Report) Merge(other *Report) {
+	if r == nil || other == nil {
+		return
+	}
+	for rule, n := range other.ByRule {
+		r.add(rule, n)
+	}
+}
+
+type compiledPattern struct {
+	name   string
+	re     *regexp.Regexp
+	action Action
+	// valid optionally rejects false positives, e.g. the Luhn check for card numbers.
+	valid func(string) bool
+}
+
+type ruleSet struct {
+	patterns []compiledPattern
+	fields   map[string]Action
+	columns  []ColumnRule
+}
+
+// Redactor applies the configured rules to query rows and other results.
+type Redactor struct {
+	salt    string
+	global  ruleSet
+	streams map[string]ruleSet
+}
+
+var builtinPatterns = map[string][]compiledPattern{
+	"email": {
+		{name: "email", re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	},
+	"ip": {
+		{name: "ip", re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+		{name: "ip", re: regexp.MustCompile(`\b(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}\b|\b(?:[0-9A-Fa-f]{1,4}:){1,7}:(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6})?`)},
+	},
+	"token": {
+		{name: "token", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`)},
+		{name: "token", re: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]+=*`)},
+		{name: "token", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{name: "token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9\-]{10,}|sk-[A-Za-z0-9_\-]{20,})\b`)},
+		{name: "token", re: regexp.MustCompile(`(?i)\b(?:api[_-]?key|(?:access|auth|refresh)?[_-]?token|secret|password|passwd)\s*[=:]\s*[^\s,;&"']+`)},
+	},
+	"credit_card": {
+		{name: "credit_card", re: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`), valid: luhnValid},
+	},
+}
+
+// Load reads a JSON redaction configuration file and compiles it.
+func Load(path string) (*Redactor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("redaction config %s: %w", path, err)
+	}
+	return New(cfg)
+}
+
+// New compiles a redaction configuration.
+func New(cfg Config) (*Redactor, error) {
+	defaultAction := cfg.Action
+	if defaultAction == "" {
+		defaultAction = ActionMask
+	}
+	if err := checkAction(defaultAction); err != nil {
+		return nil, err
+	}
+
+	global, err := compileRules(cfg.Rules, defaultAction)
+	if err != nil {
+		return nil, err
+	}
+	r := &Redactor{
+		salt:    cfg.HashSalt,
+		global:  global,
+		streams: map[string]ruleSet{},
+	}
+	for stream, rules := range cfg.Streams {
+		merged := Rules{
+			Builtins: cfg.Builtins,
+			Patterns: append(append([]Pattern(nil), cfg.Patterns...), rules.Patterns...),
+			Fields:   map[string]Action{},
+		}
+		if rules.Builtins != nil {
+			merged.Builtins = rules.Builtins
+		}
+		for field, action := range cfg.Fields {
+			merged.Fields[field] = action
+		}
+		for field, action := range rules.Fields {
+			merged.Fields[field] = action
+		}
+		// Stream column rules come first so they take precedence over global ones
+		merged.Columns = append(append([]ColumnRule(nil), rules.Columns...), cfg.Columns...)
+		set, err := compileRules(merged, defaultAction)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", stream, err)
+		}
+		r.streams[stream] = set
+	}
+	return r, nil
+}
+
+func compileRules(rules Rules, defaultAction Action) (ruleSet, error) {
+	set := ruleSet{fields: map[string]Action{}}
+	for _, name := range rules.Builtins {
+		patterns, ok := builtinPatterns[name]
+		if !ok {
+			return set, fmt.Errorf("unknown builtin redaction %q (supported: email, ip, token, credit_card)", name)
+		}
+		for _, p := range patterns {
+			p.action = defaultAction
+			set.patterns = append(set.patterns, p)
+		}
+	}
+	for _, p := range rules.Patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return set, fmt.Errorf("pattern %q: %w", p.Name, err)
+		}
+		action := p.Action
+		if action == "" {
+			action = defaultAction
+		}
+		if err := checkAction(action); err != nil {
+			return set, fmt.Errorf("pattern %q: %w", p.Name, err)
+		}
+		name := p.Name
+		if name == "" {
+			name = p.Regex
+		}
+		set.patterns = append(set.patterns, compiledPattern{name: name, re: re, action: action})
+	}
+	for field, action := range rules.Fields {
+		if err := checkAction(action); err != nil {
+			return set, fmt.Errorf("field %q: %w", field, err)
+		}
+		set.fields[strings.ToLower(field)] = action
+	}
+	for _, c := range rules.Columns {
+		if err := c.check(); err != nil {
+			return set, err
+		}
+		set.columns = append(set.columns, c)
+	}
+	return set, nil
+}
+
+func checkAction(action Action) error {
+	switch action {
+	case ActionMask, ActionHash, ActionDrop:
+		return nil
+	}
+	return fmt.Errorf("unknown redaction action %q (supported: mask, hash, drop)", action)
+}
+
+// Rows redacts query rows from the given stream in place. types maps schema field names to
+// their data types and is only needed when column rules match on type; it may be nil.
+func (r *Redactor) Rows(stream string, rows []map[string]interface{}, types map[string]string) Report {
+	var report Report
+	if r == nil {
+		return report
+	}
+	set := r.rules(stream)
+	for _, row := range rows {
+		handled := r.redactColumns(set, row, types, &report)
+		r.redactMap(set, row, &report, handled)
+	}
+	return report
+}
+
+func (r *Redactor) rules(stream string) ruleSet {
+	if set, ok := r.streams[stream]; ok {
+		return set
+	}
+	return r.global
+}
+
+// Value redacts an arbitrary decoded JSON value, such as API results, using the global rules.
+// Maps are redacted in place; the (possibly replaced) value is returned.
+func (r *Redactor) Value(v interface{}) (interface{}, Report) {
+	var report Report
+	if r == nil {
+		return v, report
+	}
+	v, _ = r.redactValue(r.global, v, &report)
+	return v, report
+}
+
+// redactMap redacts the values of m in place, skipping the keys in handled.
+func (r *Redactor) redactMap(set ruleSet, m map[string]interface{}, report *Report, handled map[string]bool) {
+	for key, value := range m {
+		if handled[key] {
+			continue
+		}
+		if action, ok := set.fields[strings.ToLower(key)]; ok && value != nil {
+			report.add("field:"+key, 1)
+			if action == ActionDrop {
+				delete(m, key)
+				continue
+			}
+			m[key] = r.apply(action, "field:"+key, fmt.Sprint(value))
+			continue
+		}
+		redacted, drop := r.redactValue(set, value, report)
+		if drop {
+			delete(m, key)
+			continue
+		}
+		m[key] = redacted
+	}
+}
+
+// redactValue returns the redacted value and whether the enclosing field must be dropped.
+func (r *Redactor) redactValue(set ruleSet, v interface{}, report *Report) (interface{}, bool) {
+	switch value := v.(type) {
+	case string:
+		return r.redactString(set, value, report)
+	case map[string]interface{}:
+		r.redactMap(set, value, report, nil)
+		return value, false
+	case []map[string]interface{}:
+		for _, m := range value {
+			r.redactMap(set, m, report, nil)
+		}
+		return value, false
+	case []interface{}:
+		kept := value[:0]
+		for _, item := range value {
+			redacted, drop := r.redactValue(set, item, report)
+			if !drop {
+				kept = append(kept, redacted)
+			}
+		}
+		return kept, false
+	}
+	return v, false
+}
+
+func (r *Redactor) redactString(set ruleSet, s string, report *Report) (string, bool) {
+	for _, p := range set.patterns {
+		count := 0
+		s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			count++
+			return r.apply(p.action, p.name, match)
+		})
+		report.add(p.name, count)
+		if count > 0 && p.action == ActionDrop {
+			return "", true
+		}
+	}
+	return s, false
+}
+
+func (r *Redactor) apply(action Action, rule string, value string) string {
+	rule = strings.TrimPrefix(rule, "field:")
+	if action == ActionHash {
+		sum := sha256.Sum256([]byte(r.salt + value))
+		return "[" + rule + ":" + hex.EncodeToString(sum[:8]) + "]"
+	}
+	return "[REDACTED:" + rule + "]"
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum used by card numbers.
+func luhnValid(s string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
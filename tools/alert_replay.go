@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"mcp-pb/redact"
+)
+
+const (
+	// Window used when an alert has no parseable evalStart
+	defaultAlertEvalWindow = 5 * time.Minute
+	// Maximum number of rows of a replayed alert query kept in the replay
+	maxReplayRows = 100
+)
+
+// AlertReplay is the result of re-running an alert query over the window that ended when the
+// alert fired.
+type AlertReplay struct {
+	Alert *Alert `json:"alert"`
+	// TriggeredAt is the evaluation time replayed and TriggerSource where it came from:
+	// "argument", "stateHistory", "lastTriggeredAt" or "now".
+	TriggeredAt   string                   `json:"triggeredAt"`
+	TriggerSource string                   `json:"triggerSource"`
+	Stream        string                   `json:"stream"`
+	WindowStart   string                   `json:"windowStart"`
+	WindowEnd     string                   `json:"windowEnd"`
+	Rows          []map[string]interface{} `json:"rows"`
+	RowCount      int                      `json:"rowCount"`
+	// Value is the first numeric value of the first redacted row, which is what a threshold alert compares.
+	Value        *float64 `json:"value,omitempty"`
+	ConditionMet *bool    `json:"conditionMet,omitempty"`
+	// Redactions reports what the redaction rules changed in the rows.
+	Redactions *redact.Report `json:"redactions,omitempty"`
+	// StateHistoryError is set when the state history could not be read; the replay then
+	// falls back to lastTriggeredAt.
+	StateHistoryError string `json:"stateHistoryError,omitempty"`
+}
+
+// ReplayAlert fetches an alert and re-runs its query over the evaluation window ending at the
+// time it last fired, or at triggeredAt if given (ISO 8601). The rows are redacted like
+// query_data_stream results.
+func ReplayAlert(ctx context.Context, alertID string, triggeredAt string) (*AlertReplay, error) {
+	alert, err := getParseableAlert(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+	if len(alert.Datasets) == 0 {
+		return nil, fmt.Errorf("alert %s has no datasets to query", alertID)
+	}
+	replay := &AlertReplay{Alert: alert, Stream: alert.Datasets[0]}
+
+	var end time.Time
+	switch {
+	case triggeredAt != "":
+		t, ok := parseTimestamp(triggeredAt)
+		if !ok {
+			return nil, fmt.Errorf("invalid triggeredAt %q, expected ISO 8601", triggeredAt)
+		}
+		end, replay.TriggerSource = t, "argument"
+	default:
+		history, err := getParseableAlertStateHistory(ctx, alertID)
+		if err != nil {
+			replay.StateHistoryError = err.Error()
+		} else {
+			for i := len(history.States) - 1; i >= 0; i-- {
+				if strings.EqualFold(history.States[i].State, "triggered") {
+					if t, ok := parseTimestamp(history.States[i].LastUpdatedAt); ok {
+						end, replay.TriggerSource = t, "stateHistory"
+					}
+					break
+				}
+			}
+		}
+		if replay.TriggerSource == "" && alert.LastTriggeredAt != "" {
+			if t, ok := parseTimestamp(alert.LastTriggeredAt); ok {
+				end, replay.TriggerSource = t, "lastTriggeredAt"
+			}
+		}
+		if replay.TriggerSource == "" {
+			end, replay.TriggerSource = time.Now().UTC(), "now"
+		}
+	}
+
+	window, err := parseHumanDuration(alert.EvalConfig.RollingWindow.EvalStart)
+	if err != nil || window <= 0 {
+		window = defaultAlertEvalWindow
+	}
+	start := end.Add(-window)
+	replay.TriggeredAt = end.UTC().Format(time.RFC3339)
+	replay.WindowStart = start.UTC().Format(time.RFC3339)
+	replay.WindowEnd = replay.TriggeredAt
+
+	if err := checkMaskedReferences(ctx, replay.Stream, alert.Query, nil); err != nil {
+		return nil, err
+	}
+	rows, err := doParseableQuery(ctx, alert.Query, replay.Stream, replay.WindowStart, replay.WindowEnd)
+	if err != nil {
+		return nil, fmt.Errorf("replaying alert query: %w", err)
+	}
+	replay.RowCount = len(rows)
+	// The value is taken after redaction, so a masked value column is not returned through it
+	if replay.Redactions, err = redactQueryRows(ctx, replay.Stream, rows); err != nil {
+		return nil, err
+	}
+	if value, ok := firstNumericValue(rows); ok {
+		replay.Value = &value
+		if met, ok := compareThreshold(value, alert.ThresholdConfig); ok {
+			replay.ConditionMet = &met
+		}
+	}
+	if len(rows) > maxReplayRows {
+		rows = rows[:maxReplayRows]
+	}
+	replay.Rows = rows
+	return replay, nil
+}
+
+// firstNumericValue returns the first numeric column of the first row, in schema-less
+// alphabetical column order since aggregate alert queries usually return a single column.
+func firstNumericValue(rows []map[string]interface{}) (float64, bool) {
+	if len(rows) == 0 {
+		return 0, false
+	}
+	for _, column := range resultColumns(rows[:1], nil) {
+		if f, ok := rows[0][column].(float64); ok {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// compareThreshold evaluates value <operator> threshold. It accepts the symbols Parseable
+// uses as well as spelled out operators.
+func compareThreshold(value float64, threshold ThresholdConfig) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(threshold.Operator)) {
+	case ">", "gt", "greater than":
+		return value > threshold.Value, true
+	case ">=", "gte", "greater than or equal to":
+		return value >= threshold.Value, true
+	case "<", "lt", "less than":
+		return value < threshold.Value, true
+	case "<=", "lte", "less than or equal to":
+		return value <= threshold.Value, true
+	case "=", "==", "eq", "equal to":
+		return value == threshold.Value, true
+	case "!=", "<>", "ne", "not equal to":
+		return value != threshold.Value, true
+	}
+	return false, false
+}
@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"math"
+	"time"
+)
+
+// Anomaly detectors over a series of bucketed values
+var anomalyMethods = []string{"zscore", "mad", "seasonal"}
+
+const (
+	// madScale makes the median absolute deviation comparable to a standard deviation
+	madScale = 1.4826
+	// Minimum number of earlier buckets for a rolling z-score
+	minRollingPoints = 3
+)
+
+// anomalyBucket is a bucket of the analyzed range with its value and, for the detectors that
+// had a baseline for it, the expected value and score: the number of standard deviations
+// (or their robust equivalent) the value is away from the expected value.
+type anomalyBucket struct {
+	Time     time.Time
+	Value    float64
+	Expected map[string]float64
+	Scores   map[string]float64
+}
+
+// minSpread keeps scores finite for constant baselines: a change of 1% of the center counts
+// as one deviation.
+func minSpread(spread float64, center float64) float64 {
+	return max(spread, 0.01*math.Abs(center), 1e-6)
+}
+
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+// medianAbsDeviation returns the median of values and their median absolute deviation.
+func medianAbsDeviation(values []float64) (float64, float64) {
+	m := median(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - m)
+	}
+	return m, median(deviations)
+}
+
+// presentValues returns the values of the buckets that have one.
+func presentValues(values []float64, present []bool) []float64 {
+	var result []float64
+	for i, v := range values {
+		if present[i] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// rollingZScores scores each value from start on against the mean and standard deviation of
+// the values of the window buckets before it.
+func rollingZScores(values []float64, present []bool, start int, window int, buckets []anomalyBucket) {
+	for i := start; i < len(values); i++ {
+		from := max(0, i-window)
+		previous := presentValues(values[from:i], present[from:i])
+		if !present[i] || len(previous) < minRollingPoints {
+			continue
+		}
+		mean, stddev := meanStddev(previous)
+		buckets[i-start].Expected["zscore"] = mean
+		buckets[i-start].Scores["zscore"] = (values[i] - mean) / minSpread(stddev, mean)
+	}
+}
+
+// madScores scores the values from start on against the median and median absolute deviation
+// of all of them, which a few outliers do not shift.
+func madScores(values []float64, present []bool, start int, buckets []anomalyBucket) {
+	analyzed := presentValues(values[start:], present[start:])
+	if len(analyzed) < minRollingPoints {
+		return
+	}
+	m, mad := medianAbsDeviation(analyzed)
+	for i := start; i < len(values); i++ {
+		if present[i] {
+			buckets[i-start].Expected["mad"] = m
+			buckets[i-start].Scores["mad"] = (values[i] - m) / minSpread(madScale*mad, m)
+		}
+	}
+}
+
+// seasonalScores scores the values from start on against the median of the values at the same
+// time in up to seasons earlier periods. The residuals are scaled by their median absolute
+// deviation over the analyzed range, so that the usual difference from the baseline is one.
+func seasonalScores(values []float64, present []bool, start int, periodBuckets int, seasons int, buckets []anomalyBucket) {
+	residuals := map[int]float64{}
+	for i := start; i < len(values); i++ {
+		var earlier []float64
+		for k := 1; k <= seasons; k++ {
+			if j := i - k*periodBuckets; j >= 0 && present[j] {
+				earlier = append(earlier, values[j])
+			}
+		}
+		if !present[i] || len(earlier) == 0 {
+			continue
+		}
+		baseline := median(earlier)
+		buckets[i-start].Expected["seasonal"] = baseline
+		residuals[i] = values[i] - baseline
+	}
+	if len(residuals) == 0 {
+		return
+	}
+	all := make([]float64, 0, len(residuals))
+	for _, r := range residuals {
+		all = append(all, r)
+	}
+	_, mad := medianAbsDeviation(all)
+	for i, r := range residuals {
+		expected := buckets[i-start].Expected["seasonal"]
+		buckets[i-start].Scores["seasonal"] = r / minSpread(madScale*mad, expected)
+	}
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnomalyScores(t *testing.T) {
+	all := func(n int) []bool {
+		present := make([]bool, n)
+		for i := range present {
+			present[i] = true
+		}
+		return present
+	}
+	missing := func(n int, gaps ...int) []bool {
+		present := all(n)
+		for _, i := range gaps {
+			present[i] = false
+		}
+		return present
+	}
+	tests := []struct {
+		name    string
+		method  string
+		values  []float64
+		present []bool
+		start   int
+		// window for zscore, period in buckets for seasonal
+		window int
+		// Scores and, where given, expected values by bucket index from start
+		want         map[int]float64
+		wantExpected map[int]float64
+	}{
+		{name: "zscore constant series", method: "zscore", values: []float64{10, 10, 10, 10, 10}, present: all(5), window: 3,
+			want: map[int]float64{3: 0, 4: 0}, wantExpected: map[int]float64{3: 10, 4: 10}},
+		{name: "zscore single spike", method: "zscore", values: []float64{9, 11, 9, 11, 30}, present: all(5), window: 4,
+			want: map[int]float64{3: 1.414, 4: 20}, wantExpected: map[int]float64{4: 10}},
+		{name: "zscore baseline before start", method: "zscore", values: []float64{9, 11, 9, 11, 30}, present: all(5), start: 3, window: 4,
+			want: map[int]float64{0: 1.414, 1: 20}},
+		{name: "zscore missing buckets", method: "zscore", values: []float64{9, 11, 0, 9, 11, 30}, present: missing(6, 2), window: 5,
+			want: map[int]float64{4: 1.414, 5: 20}},
+		{name: "zscore window too short", method: "zscore", values: []float64{9, 11, 9, 11, 30}, present: all(5), window: 2,
+			want: map[int]float64{}},
+
+		{name: "mad constant series", method: "mad", values: []float64{5, 5, 5, 5}, present: all(4),
+			want: map[int]float64{0: 0, 1: 0, 2: 0, 3: 0}},
+		{name: "mad single spike", method: "mad", values: []float64{10, 12, 10, 12, 11, 11, 100}, present: all(7),
+			want:         map[int]float64{0: -0.674, 1: 0.674, 2: -0.674, 3: 0.674, 4: 0, 5: 0, 6: 60.03},
+			wantExpected: map[int]float64{6: 11}},
+		{name: "mad ignores values before start", method: "mad", values: []float64{1000, 10, 12, 11}, present: all(4), start: 1,
+			want: map[int]float64{0: -0.674, 1: 0.674, 2: 0}},
+		{name: "mad missing buckets", method: "mad", values: []float64{10, 0, 12, 0, 11, 11, 100}, present: missing(7, 1, 3),
+			want: map[int]float64{0: -0.674, 2: 0.674, 4: 0, 5: 0, 6: 60.03}},
+		{name: "mad too few values", method: "mad", values: []float64{1, 0, 0, 2}, present: missing(4, 1, 2),
+			want: map[int]float64{}},
+
+		{name: "seasonal constant series", method: "seasonal", values: []float64{7, 7, 7, 7, 7, 7}, present: all(6), start: 2, window: 2,
+			want: map[int]float64{0: 0, 1: 0, 2: 0, 3: 0}},
+		{name: "seasonal single spike", method: "seasonal", values: []float64{10, 20, 10, 20, 10, 20, 10, 80}, present: all(8), start: 2, window: 2,
+			want:         map[int]float64{0: 0, 1: 0, 2: 0, 3: 0, 4: 0, 5: 300},
+			wantExpected: map[int]float64{4: 10, 5: 20}},
+		{name: "seasonal scaled by residual spread", method: "seasonal", values: []float64{10, 20, 11, 21, 9, 19, 10, 50}, present: all(8), start: 2, window: 2,
+			want: map[int]float64{0: 0.54, 1: 0.54, 2: -0.809, 3: -0.809, 4: 0, 5: 16.188}},
+		{name: "seasonal missing buckets", method: "seasonal", values: []float64{10, 20, 10, 20, 10, 0, 10, 80}, present: missing(8, 5), start: 2, window: 2,
+			want: map[int]float64{0: 0, 1: 0, 2: 0, 4: 0, 5: 300}, wantExpected: map[int]float64{5: 20}},
+		{name: "seasonal period longer than data", method: "seasonal", values: []float64{10, 20, 30, 40}, present: all(4), window: 10,
+			want: map[int]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := make([]anomalyBucket, len(tt.values)-tt.start)
+			for i := range buckets {
+				buckets[i] = anomalyBucket{Value: tt.values[tt.start+i], Expected: map[string]float64{}, Scores: map[string]float64{}}
+			}
+			switch tt.method {
+			case "zscore":
+				rollingZScores(tt.values, tt.present, tt.start, tt.window, buckets)
+			case "mad":
+				madScores(tt.values, tt.present, tt.start, buckets)
+			case "seasonal":
+				seasonalScores(tt.values, tt.present, tt.start, tt.window, 2, buckets)
+			}
+
+			got := map[int]float64{}
+			for i, b := range buckets {
+				if score, ok := b.Scores[tt.method]; ok {
+					got[i] = roundTo(score, 3)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scores = %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.wantExpected {
+				if expected := roundTo(buckets[i].Expected[tt.method], 3); expected != want {
+					t.Errorf("expected value of bucket %d = %v, want %v", i, expected, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeasonalScoresSeasons(t *testing.T) {
+	// The baseline is the median of the same bucket in the last seasons periods only
+	values := []float64{100, 100, 10, 10}
+	for _, tt := range []struct {
+		seasons int
+		want    float64
+	}{{1, 10}, {2, 55}, {3, 100}, {5, 100}} {
+		buckets := []anomalyBucket{{Expected: map[string]float64{}, Scores: map[string]float64{}}}
+		seasonalScores(values, []bool{true, true, true, true}, 3, 1, tt.seasons, buckets)
+		if got := buckets[0].Expected["seasonal"]; got != tt.want {
+			t.Errorf("seasons %d: expected value = %v, want %v", tt.seasons, got, tt.want)
+		}
+	}
+}
@@ -1,3 +1,263 @@
 package tools
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"mcp-pb/redact"
+)
+
 const parseableSQLPath = "/api/v1/query"
+
+// writesDisabled is the result of a write tool called while writes are not enabled.
+func writesDisabled(tool string) *mcp.CallToolResult {
+	slog.Warn("write refused, writes are disabled", "tool", tool)
+	return mcp.NewToolResultError(tool + " changes Parseable and write operations are disabled on this server. " +
+		"Start the server with --enable-writes or PARSEABLE_ENABLE_WRITES=true to allow it.")
+}
+
+// parseHumanDuration parses durations as used by Parseable, e.g. "5m", "1h30m", "7d" or "2w".
+// Besides the units of time.ParseDuration it supports d (days), w (weeks) and spelled out units.
+func parseHumanDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		j := i
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') && rest[j] != '.' {
+			j++
+		}
+		if i == 0 || j == i {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		value, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		var unit time.Duration
+		switch strings.TrimSpace(rest[i:j]) {
+		case "s", "sec", "secs", "second", "seconds":
+			unit = time.Second
+		case "m", "min", "mins", "minute", "minutes":
+			unit = time.Minute
+		case "h", "hr", "hrs", "hour", "hours":
+			unit = time.Hour
+		case "d", "day", "days":
+			unit = 24 * time.Hour
+		case "w", "week", "weeks":
+			unit = 7 * 24 * time.Hour
+		default:
+			d, err := time.ParseDuration("1" + strings.TrimSpace(rest[i:j]))
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			unit = d
+		}
+		total += time.Duration(value * float64(unit))
+		rest = strings.TrimSpace(rest[j:])
+	}
+	return total, nil
+}
+
+// timeRangeArguments reads an optional startTime and endTime (ISO 8601) or a relative 'since'
+// duration such as "1h" from the request. Without them the range is the defaultSince before
+// now. The range is returned formatted for doParseableQuery.
+func timeRangeArguments(req mcp.CallToolRequest, defaultSince time.Duration) (string, string, error) {
+	end := time.Now().UTC()
+	if s := mcp.ParseString(req, "endTime", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return "", "", fmt.Errorf("invalid endTime %q, expected ISO 8601", s)
+		}
+		end = t
+	}
+	start := end.Add(-defaultSince)
+	if s := mcp.ParseString(req, "startTime", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return "", "", fmt.Errorf("invalid startTime %q, expected ISO 8601", s)
+		}
+		start = t
+	} else if s := mcp.ParseString(req, "since", ""); s != "" {
+		d, err := parseHumanDuration(s)
+		if err != nil || d <= 0 {
+			return "", "", fmt.Errorf("invalid since %q, expected a duration such as '15m', '1h' or '7d'", s)
+		}
+		start = end.Add(-d)
+	}
+	if !start.Before(end) {
+		return "", "", fmt.Errorf("startTime must be before endTime")
+	}
+	return start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339), nil
+}
+
+// timeRangeOptions are the tool options read by timeRangeArguments.
+func timeRangeOptions(defaultSince string) []mcp.ToolOption {
+	return []mcp.ToolOption{
+		mcp.WithString("startTime", mcp.Description("Optional start time in ISO 8601 format with timezone, e.g. '2026-02-12T00:00:00Z'")),
+		mcp.WithString("endTime", mcp.Description("Optional end time in ISO 8601 format with timezone (default: now)")),
+		mcp.WithString("since", mcp.Description("Optional relative start used when startTime is not given, e.g. '15m', '1h' or '7d' (default: "+defaultSince+")")),
+	}
+}
+
+// redactQueryRows redacts rows of a query against stream in place, fetching the schema
+// first if column rules match on data type. Like query_data_stream it fails closed: if the
+// schema is needed and cannot be fetched, the rows must not be returned.
+func redactQueryRows(ctx context.Context, stream string, rows []map[string]interface{}) (*redact.Report, error) {
+	if ResultRedactor == nil {
+		return nil, nil
+	}
+	fieldTypes, err := redactionFieldTypes(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	report := ResultRedactor.Rows(stream, rows, fieldTypes)
+	return &report, nil
+}
+
+// redactionFieldTypes returns the field types of stream if its column rules match on data
+// type, and nil if they do not need them.
+func redactionFieldTypes(ctx context.Context, stream string) (map[string]string, error) {
+	if ResultRedactor == nil || !ResultRedactor.NeedsSchema(stream) {
+		return nil, nil
+	}
+	schema, err := getParseableSchema(ctx, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema for column masking: %w", err)
+	}
+	return redact.SchemaFieldTypes(schema), nil
+}
+
+// checkMaskedReferences refuses a query that uses fields masked by the redaction rules of the
+// streams it reads other than as plain columns, since the rules match result columns by name.
+// types are the field types of stream if already loaded; schemas are fetched as type rules need them.
+func checkMaskedReferences(ctx context.Context, stream string, query string, types map[string]string) error {
+	if ResultRedactor == nil {
+		return nil
+	}
+	tables, _ := sqlReferences(query)
+	if !slices.Contains(tables, stream) {
+		tables = append(tables, stream)
+	}
+	tableTypes := map[string]map[string]string{stream: types}
+	for _, table := range tables {
+		if tableTypes[table] == nil && ResultRedactor.NeedsSchema(table) {
+			schema, err := getParseableSchema(ctx, table)
+			if err != nil {
+				return fmt.Errorf("failed to get schema for column masking: %w", err)
+			}
+			tableTypes[table] = redact.SchemaFieldTypes(schema)
+		}
+	}
+	fields := maskedReferences(query, func(field string) bool {
+		for _, table := range tables {
+			if _, ok := ResultRedactor.FieldAction(table, field, tableTypes[table][field]); ok {
+				return true
+			}
+		}
+		return false
+	})
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = strconv.Quote(field)
+	}
+	return fmt.Errorf("the query uses the masked field(s) %s in an alias, expression, function, condition or set operation branch; "+
+		"masking applies to result columns by name, so select masked fields only as plain columns (e.g. SELECT %s) "+
+		"or leave them out", strings.Join(quoted, ", "), fields[0])
+}
+
+// redactAliasedRows redacts rows whose columns are aliases of stream fields, e.g. "trace_id"
+// for "span_trace_id", under the names of the fields so that their field and column rules apply.
+// Columns that are not in aliases keep their name.
+func redactAliasedRows(ctx context.Context, stream string, rows []map[string]interface{}, aliases map[string]string) (*redact.Report, error) {
+	renamed := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		renamed[i] = make(map[string]interface{}, len(row))
+		for column, value := range row {
+			renamed[i][aliasedField(column, aliases)] = value
+		}
+	}
+	report, err := redactQueryRows(ctx, stream, renamed)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		for column := range row {
+			if value, ok := renamed[i][aliasedField(column, aliases)]; ok {
+				row[column] = value
+			} else {
+				delete(row, column)
+			}
+		}
+	}
+	return report, nil
+}
+
+func aliasedField(column string, aliases map[string]string) string {
+	if field := aliases[column]; field != "" {
+		return field
+	}
+	return column
+}
+
+var byteUnits = map[string]float64{
+	"": 1, "b": 1, "byte": 1, "bytes": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// parseByteSize parses sizes such as "1024", "1024 Bytes", "20GB" or "10 GiB" into bytes.
+func parseByteSize(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes or a size such as '20 GiB'", s)
+	}
+	return value * unit, nil
+}
+
+// formatByteSize formats bytes with binary units, e.g. "20 GiB", the way Parseable prints sizes.
+func formatByteSize(bytes float64) string {
+	units := []string{"Bytes", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	if i == 0 || bytes == float64(int64(bytes)) {
+		return fmt.Sprintf("%d %s", int64(bytes), units[i])
+	}
+	return fmt.Sprintf("%.2f %s", bytes, units[i])
+}
+
+// roundTo rounds x to the given number of decimals, to keep computed values readable.
+func roundTo(x float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(x*p) / p
+}
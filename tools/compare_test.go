@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAdditiveAggregate(t *testing.T) {
+	tests := []struct {
+		aggregate string
+		want      bool
+	}{
+		{"COUNT(*)", true},
+		{"count( * )", true},
+		{"COUNT(status)", true},
+		{`COUNT("service.name")`, true},
+		{"SUM(bytes)", true},
+		{` sum("response bytes") `, true},
+		{"COUNT(DISTINCT user_id)", false},
+		{"count(distinct user_id)", false},
+		{"SUM(DISTINCT bytes)", false},
+		{"SUM(*)", false},
+		{"SUM(bytes) / COUNT(*)", false},
+		{"COUNT(*) * 2", false},
+		{"SUM(bytes * 8)", false},
+		{"AVG(duration_ms)", false},
+		{"approx_distinct(user_id)", false},
+		{"MAX(duration_ms)", false},
+	}
+	for _, tt := range tests {
+		if got := additiveAggregate.MatchString(tt.aggregate); got != tt.want {
+			t.Errorf("additiveAggregate(%q) = %v, want %v", tt.aggregate, got, tt.want)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name            string
+		groupBy         []string
+		before, after   []map[string]interface{}
+		scale           float64
+		wantChanges     []groupChange
+		wantAdded       []groupChange
+		wantDisappeared []groupChange
+	}{
+		{
+			name:        "no groups",
+			before:      []map[string]interface{}{{"value": 100.0}},
+			after:       []map[string]interface{}{{"value": 150.0}},
+			scale:       1,
+			wantChanges: []groupChange{{Before: f(100), After: f(150), Delta: 50, PercentChange: f(50)}},
+		},
+		{
+			name:        "scaled before values",
+			before:      []map[string]interface{}{{"value": 120.0}},
+			after:       []map[string]interface{}{{"value": 30.0}},
+			scale:       0.25,
+			wantChanges: []groupChange{{Before: f(30), After: f(30), Delta: 0, PercentChange: f(0)}},
+		},
+		{
+			name:    "groups aligned, new and disappeared",
+			groupBy: []string{"service"},
+			before: []map[string]interface{}{
+				{"service": "api", "value": 10.0},
+				{"service": "db", "value": 4.0},
+			},
+			after: []map[string]interface{}{
+				{"service": "api", "value": 5.0},
+				{"service": "web", "value": 7.0},
+			},
+			scale: 1,
+			wantChanges: []groupChange{
+				{Group: map[string]interface{}{"service": "api"}, Before: f(10), After: f(5), Delta: -5, PercentChange: f(-50)},
+			},
+			wantAdded:       []groupChange{{Group: map[string]interface{}{"service": "web"}, After: f(7), Delta: 7}},
+			wantDisappeared: []groupChange{{Group: map[string]interface{}{"service": "db"}, Before: f(4), Delta: -4, PercentChange: f(-100)}},
+		},
+		{
+			name:        "change from zero has no percent",
+			before:      []map[string]interface{}{{"value": 0.0}},
+			after:       []map[string]interface{}{{"value": 3.0}},
+			scale:       1,
+			wantChanges: []groupChange{{Before: f(0), After: f(3), Delta: 3}},
+		},
+		{
+			name:        "rows without a numeric value are skipped",
+			before:      []map[string]interface{}{{"value": nil}},
+			after:       []map[string]interface{}{{"value": 2.0}},
+			scale:       1,
+			wantChanges: []groupChange{},
+			wantAdded:   []groupChange{{After: f(2), Delta: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, added, disappeared := compareValues(tt.groupBy, tt.before, tt.after, tt.scale)
+			for _, c := range []struct {
+				name      string
+				got, want []groupChange
+			}{{"changes", changes, tt.wantChanges}, {"new", added, tt.wantAdded}, {"disappeared", disappeared, tt.wantDisappeared}} {
+				want := c.want
+				if want == nil {
+					want = []groupChange{}
+				}
+				if !reflect.DeepEqual(c.got, want) {
+					t.Errorf("%s = %+v, want %+v", c.name, c.got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package tools
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// drainWildcard stands for the variable tokens of a log template.
+const drainWildcard = "<*>"
+
+// drainVariablePattern matches tokens that are variables on their own: numbers with optional
+// units, hex numbers and ids, UUIDs, IP addresses, and timestamps.
+var drainVariablePattern = regexp.MustCompile(`^(?:` +
+	`[-+]?\d+(?:\.\d+)?(?:[a-zA-Z%]{1,3})?` +
+	`|0x[0-9a-fA-F]+` +
+	`|[0-9a-fA-F]{12,}` +
+	`|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}` +
+	`|\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?` +
+	`|\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)?` +
+	`|\d{2}:\d{2}:\d{2}(?:\.\d+)?` +
+	`)$`)
+
+// drainTokens splits a message into tokens, replacing variables with the wildcard. The value of
+// key=value and key:value tokens and the segments of paths are masked on their own, so that
+// the rest stays in the template.
+func drainTokens(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		trimmed := strings.TrimFunc(token, func(r rune) bool { return unicode.IsPunct(r) && r != '-' && r != '+' })
+		if trimmed != "" && drainVariablePattern.MatchString(trimmed) {
+			tokens[i] = drainWildcard
+			continue
+		}
+		if strings.Contains(token, "/") {
+			// Paths and URLs: mask variable segments, e.g. /users/42 becomes /users/<*>
+			segments := strings.Split(token, "/")
+			for j, segment := range segments {
+				if segment != "" && drainVariablePattern.MatchString(segment) {
+					segments[j] = drainWildcard
+				}
+			}
+			tokens[i] = strings.Join(segments, "/")
+			continue
+		}
+		if k := strings.IndexAny(token, "=:"); k > 0 && k < len(token)-1 {
+			value := strings.TrimFunc(token[k+1:], unicode.IsPunct)
+			if value != "" && drainVariablePattern.MatchString(value) {
+				tokens[i] = token[:k+1] + drainWildcard
+			}
+		}
+	}
+	return tokens
+}
+
+// drainCluster is a group of messages sharing a template.
+type drainCluster struct {
+	template  []string
+	count     int
+	examples  []string
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+type drainNode struct {
+	children map[string]*drainNode
+	clusters []*drainCluster
+}
+
+// drain clusters log messages into templates with the Drain algorithm (He et al., 2017): a
+// fixed depth parse tree routes a message by its number of tokens and its first tokens to a
+// few candidate clusters, and the message joins the most similar one or starts a new cluster.
+type drain struct {
+	depth       int
+	similarity  float64
+	maxChildren int
+	maxExamples int
+	root        *drainNode
+	clusters    []*drainCluster
+}
+
+func newDrain(depth int, similarity float64, maxChildren int, maxExamples int) *drain {
+	return &drain{
+		depth:       depth,
+		similarity:  similarity,
+		maxChildren: maxChildren,
+		maxExamples: maxExamples,
+		root:        &drainNode{children: map[string]*drainNode{}},
+	}
+}
+
+// add adds a message seen at ts (zero if unknown) and returns its cluster.
+func (d *drain) add(message string, ts time.Time) *drainCluster {
+	tokens := drainTokens(message)
+	leaf := d.leaf(tokens)
+	var best *drainCluster
+	bestSimilarity, bestWildcards := -1.0, -1
+	for _, c := range leaf.clusters {
+		s, wildcards := drainSimilarity(c.template, tokens)
+		if s > bestSimilarity || (s == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = c, s, wildcards
+		}
+	}
+	if best == nil || bestSimilarity < d.similarity {
+		best = &drainCluster{template: tokens}
+		leaf.clusters = append(leaf.clusters, best)
+		d.clusters = append(d.clusters, best)
+	} else {
+		for i, token := range tokens {
+			if best.template[i] != token {
+				best.template[i] = drainWildcard
+			}
+		}
+	}
+	best.count++
+	if len(best.examples) < d.maxExamples && !slices.Contains(best.examples, message) {
+		best.examples = append(best.examples, message)
+	}
+	if !ts.IsZero() {
+		if best.firstSeen.IsZero() || ts.Before(best.firstSeen) {
+			best.firstSeen = ts
+		}
+		if ts.After(best.lastSeen) {
+			best.lastSeen = ts
+		}
+	}
+	return best
+}
+
+// leaf walks the parse tree to the leaf of a message: by token count, then by the first
+// depth-2 tokens. Tokens with digits, and tokens past maxChildren, share the wildcard child.
+func (d *drain) leaf(tokens []string) *drainNode {
+	node := d.child(d.root, strconv.Itoa(len(tokens)), false)
+	for i := 0; i < d.depth-2 && i < len(tokens); i++ {
+		key := tokens[i]
+		if strings.ContainsFunc(key, unicode.IsDigit) {
+			key = drainWildcard
+		}
+		node = d.child(node, key, true)
+	}
+	return node
+}
+
+func (d *drain) child(node *drainNode, key string, limited bool) *drainNode {
+	if child, ok := node.children[key]; ok {
+		return child
+	}
+	if limited && key != drainWildcard && len(node.children) >= d.maxChildren-1 {
+		key = drainWildcard
+		if child, ok := node.children[key]; ok {
+			return child
+		}
+	}
+	child := &drainNode{children: map[string]*drainNode{}}
+	node.children[key] = child
+	return child
+}
+
+// drainSimilarity is the share of positions where a template and tokens of the same length
+// have the same token, not counting the wildcards of the template, and the number of wildcards.
+func drainSimilarity(template []string, tokens []string) (float64, int) {
+	if len(template) != len(tokens) {
+		return 0, 0
+	}
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+	same, wildcards := 0, 0
+	for i, token := range template {
+		switch {
+		case token == drainWildcard:
+			wildcards++
+		case token == tokens[i]:
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens)), wildcards
+}
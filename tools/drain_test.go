@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainTokens(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Connection to 10.0.0.12:5432 timed out after 30s", "Connection to <*> timed out after <*>"},
+		{"user 42 logged in", "user <*> logged in"},
+		{"took 12.5ms (p99 3%)", "took <*> (p99 <*>"},
+		{"request 3fa85f64-5717-4562-b3fc-2c963f66afa6 done", "request <*> done"},
+		{"commit 0xdeadbeef and deadbeefcafe1234", "commit <*> and <*>"},
+		{"at 2026-10-19T10:00:00.123Z and 10:00:01", "at <*> and <*>"},
+		{"GET /users/42/orders/7 returned 404", "GET /users/<*>/orders/<*> returned <*>"},
+		{"status=500 retries:3 user=bob", "status=<*> retries:<*> user=bob"},
+		{"error: disk full", "error: disk full"},
+		{"  spaced   out  ", "spaced out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := strings.Join(drainTokens(tt.message), " "); got != tt.want {
+				t.Errorf("drainTokens(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainSimilarity(t *testing.T) {
+	tests := []struct {
+		template, tokens string
+		want             float64
+		wantWildcards    int
+	}{
+		{"a b c d", "a b c d", 1, 0},
+		{"a b c d", "a b x y", 0.5, 0},
+		{"a <*> c d", "a b c e", 0.5, 1},
+		{"a b", "a b c", 0, 0},
+	}
+	for _, tt := range tests {
+		got, wildcards := drainSimilarity(strings.Fields(tt.template), strings.Fields(tt.tokens))
+		if got != tt.want || wildcards != tt.wantWildcards {
+			t.Errorf("drainSimilarity(%q, %q) = %g, %d; want %g, %d", tt.template, tt.tokens, got, wildcards, tt.want, tt.wantWildcards)
+		}
+	}
+}
+
+func TestDrainClusters(t *testing.T) {
+	tests := []struct {
+		name       string
+		similarity float64
+		messages   []string
+		want       map[string]int
+	}{
+		{
+			name:       "variables are masked before clustering",
+			similarity: 0.4,
+			messages:   []string{"user 1 logged in", "user 22 logged in", "user 333 logged in"},
+			want:       map[string]int{"user <*> logged in": 3},
+		},
+		{
+			name:       "differing words merge into a wildcard",
+			similarity: 0.4,
+			messages:   []string{"failed to open file config.yaml", "failed to open file data.db", "failed to open socket"},
+			want:       map[string]int{"failed to open file <*>": 2, "failed to open socket": 1},
+		},
+		{
+			name:       "below the similarity a new cluster starts",
+			similarity: 0.8,
+			messages:   []string{"cache hit for key alpha", "cache miss for key beta"},
+			want:       map[string]int{"cache hit for key alpha": 1, "cache miss for key beta": 1},
+		},
+		{
+			name:       "the first token routes messages",
+			similarity: 0.1,
+			messages:   []string{"GET /a ok", "PUT /a ok"},
+			want:       map[string]int{"GET /a ok": 1, "PUT /a ok": 1},
+		},
+		{
+			name:       "leading variables share a route",
+			similarity: 0.4,
+			messages:   []string{"42 items processed", "7 items processed"},
+			want:       map[string]int{"<*> items processed": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDrain(drainDepth, tt.similarity, drainMaxChildren, patternExamples)
+			for _, message := range tt.messages {
+				d.add(message, time.Time{})
+			}
+			got := map[string]int{}
+			for _, c := range d.clusters {
+				got[strings.Join(c.template, " ")] = c.count
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clusters = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainExamplesAndTimes(t *testing.T) {
+	d := newDrain(drainDepth, defaultDrainSimilarity, drainMaxChildren, 2)
+	base := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i, message := range []string{"job 1 done", "job 1 done", "job 2 done", "job 3 done"} {
+		// Out of time order, as rows are sampled newest first
+		d.add(message, base.Add(-time.Duration(i)*time.Minute))
+	}
+	if len(d.clusters) != 1 {
+		t.Fatalf("clusters = %d, want 1", len(d.clusters))
+	}
+	c := d.clusters[0]
+	if want := []string{"job 1 done", "job 2 done"}; !reflect.DeepEqual(c.examples, want) {
+		t.Errorf("examples = %q, want %q", c.examples, want)
+	}
+	if !c.firstSeen.Equal(base.Add(-3*time.Minute)) || !c.lastSeen.Equal(base) {
+		t.Errorf("seen from %v to %v, want %v to %v", c.firstSeen, c.lastSeen, base.Add(-3*time.Minute), base)
+	}
+}
+
+func TestClusterMessages(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"body": "timeout after 5s", "p_timestamp": "2026-10-19T12:00:00Z"},
+		{"body": "timeout after 10s", "p_timestamp": "2026-10-19T12:01:00Z"},
+		{"body": "disk full", "p_timestamp": "2026-10-19T12:02:00Z"},
+		{"body": "   "},
+		{"other": "no body"},
+	}
+	patterns, sampled := clusterMessages(rows, "body", defaultDrainSimilarity)
+	if sampled != 3 {
+		t.Errorf("sampled = %d, want 3", sampled)
+	}
+	if len(patterns) != 2 {
+		t.Fatalf("patterns = %+v, want 2", patterns)
+	}
+	want := logPattern{
+		Pattern:   "timeout after <*>",
+		Count:     2,
+		Percent:   66.67,
+		Examples:  []string{"timeout after 5s", "timeout after 10s"},
+		FirstSeen: "2026-10-19T12:00:00Z",
+		LastSeen:  "2026-10-19T12:01:00Z",
+	}
+	if !reflect.DeepEqual(patterns[0], want) {
+		t.Errorf("top pattern = %+v, want %+v", patterns[0], want)
+	}
+}
@@ -0,0 +1,185 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result formats supported by query_data_stream
+const (
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+	formatCSV      = "csv"
+	formatNDJSON   = "ndjson"
+	formatColumnar = "columnar"
+)
+
+var resultFormats = []string{formatJSON, formatMarkdown, formatCSV, formatNDJSON, formatColumnar}
+
+// schemaFieldNames returns the field names of a Parseable schema response in schema order.
+func schemaFieldNames(schema map[string]interface{}) []string {
+	var names []string
+	fields, _ := schema["fields"].([]interface{})
+	for _, f := range fields {
+		if field, ok := f.(map[string]interface{}); ok {
+			if name, ok := field["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// resultColumns returns the columns present in rows, ordered as in the schema followed by
+// columns not in the schema (aliases, aggregates) in alphabetical order.
+func resultColumns(rows []map[string]interface{}, schemaOrder []string) []string {
+	present := map[string]bool{}
+	for _, row := range rows {
+		for key := range row {
+			present[key] = true
+		}
+	}
+	columns := make([]string, 0, len(present))
+	for _, name := range schemaOrder {
+		if present[name] {
+			columns = append(columns, name)
+			delete(present, name)
+		}
+	}
+	var rest []string
+	for name := range present {
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+	return append(columns, rest...)
+}
+
+// typedValue converts a decoded JSON value to the type declared in the schema. JSON numbers
+// decode as float64, so integer columns are converted back to int64.
+func typedValue(v interface{}, dataType string) interface{} {
+	f, ok := v.(float64)
+	if !ok {
+		return v
+	}
+	t := strings.ToLower(dataType)
+	if (strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint")) && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return int64(f)
+	}
+	return v
+}
+
+// cellText renders a value for the text formats without scientific notation for numbers.
+func cellText(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// formatRows renders query rows in the requested format. Text formats (markdown, csv, ndjson)
+// are returned as a string, columnar as a map.
+func formatRows(format string, rows []map[string]interface{}, schemaOrder []string, fieldTypes map[string]string) (interface{}, error) {
+	columns := resultColumns(rows, schemaOrder)
+	switch format {
+	case formatMarkdown:
+		// A table needs at least one column, so results without any are described instead
+		if len(columns) == 0 {
+			if len(rows) == 0 {
+				return "_No rows_\n", nil
+			}
+			return fmt.Sprintf("_%d rows without columns_\n", len(rows)), nil
+		}
+		var b strings.Builder
+		escape := strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+		b.WriteString("|")
+		for _, c := range columns {
+			b.WriteString(" " + escape.Replace(c) + " |")
+		}
+		b.WriteString("\n|")
+		for range columns {
+			b.WriteString(" --- |")
+		}
+		b.WriteString("\n")
+		for _, row := range rows {
+			b.WriteString("|")
+			for _, c := range columns {
+				b.WriteString(" " + escape.Replace(cellText(typedValue(row[c], fieldTypes[c]))) + " |")
+			}
+			b.WriteString("\n")
+		}
+		return b.String(), nil
+	case formatCSV:
+		if len(columns) == 0 {
+			return "", nil
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(columns); err != nil {
+			return nil, err
+		}
+		record := make([]string, len(columns))
+		for _, row := range rows {
+			for i, c := range columns {
+				record[i] = cellText(typedValue(row[c], fieldTypes[c]))
+			}
+			if err := w.Write(record); err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		return buf.String(), w.Error()
+	case formatNDJSON:
+		var buf bytes.Buffer
+		for _, row := range rows {
+			typed := make(map[string]interface{}, len(row))
+			for key, value := range row {
+				typed[key] = typedValue(value, fieldTypes[key])
+			}
+			line, err := json.Marshal(typed)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.String(), nil
+	case formatColumnar:
+		types := make([]string, len(columns))
+		for i, c := range columns {
+			types[i] = fieldTypes[c]
+		}
+		values := make([][]interface{}, 0, len(rows))
+		for _, row := range rows {
+			record := make([]interface{}, len(columns))
+			for i, c := range columns {
+				record[i] = typedValue(row[c], fieldTypes[c])
+			}
+			values = append(values, record)
+		}
+		return map[string]interface{}{
+			"columns": columns,
+			"types":   types,
+			"values":  values,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(resultFormats, ", "))
+}
@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatRows(t *testing.T) {
+	schema := []string{"time", "level", "count"}
+	types := map[string]string{"time": "Timestamp(Millisecond, None)", "level": "Utf8", "count": "Int64"}
+	heterogeneous := []map[string]interface{}{
+		{"level": "info", "count": 3.0},
+		{"count": 1.0, "host": "web-1"},
+	}
+	nested := []map[string]interface{}{
+		{"level": "error", "tags": []interface{}{"a", "b"}, "meta": map[string]interface{}{"k": 1.5}},
+	}
+	quoting := []map[string]interface{}{
+		{"level": "a,b", "msg": "say \"hi\"\nbye", "n": 1e21},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		rows   []map[string]interface{}
+		want   interface{}
+	}{
+		{"markdown zero rows", formatMarkdown, nil, "_No rows_\n"},
+		{"markdown rows without columns", formatMarkdown, []map[string]interface{}{{}, {}}, "_2 rows without columns_\n"},
+		{"csv zero rows", formatCSV, nil, ""},
+		{"ndjson zero rows", formatNDJSON, nil, ""},
+		{"columnar zero rows", formatColumnar, nil, map[string]interface{}{
+			"columns": []string{},
+			"types":   []string{},
+			"values":  [][]interface{}{},
+		}},
+		{"markdown heterogeneous keys", formatMarkdown, heterogeneous,
+			"| level | count | host |\n| --- | --- | --- |\n| info | 3 |  |\n|  | 1 | web-1 |\n"},
+		{"csv heterogeneous keys", formatCSV, heterogeneous, "level,count,host\ninfo,3,\n,1,web-1\n"},
+		{"ndjson heterogeneous keys", formatNDJSON, heterogeneous,
+			"{\"count\":3,\"level\":\"info\"}\n{\"count\":1,\"host\":\"web-1\"}\n"},
+		{"columnar heterogeneous keys", formatColumnar, heterogeneous, map[string]interface{}{
+			"columns": []string{"level", "count", "host"},
+			"types":   []string{"Utf8", "Int64", ""},
+			"values": [][]interface{}{
+				{"info", int64(3), nil},
+				{nil, int64(1), "web-1"},
+			},
+		}},
+		{"markdown nested values", formatMarkdown, nested,
+			"| level | meta | tags |\n| --- | --- | --- |\n| error | {\"k\":1.5} | [\"a\",\"b\"] |\n"},
+		{"csv nested values", formatCSV, nested, "level,meta,tags\nerror,\"{\"\"k\"\":1.5}\",\"[\"\"a\"\",\"\"b\"\"]\"\n"},
+		{"ndjson nested values", formatNDJSON, nested, "{\"level\":\"error\",\"meta\":{\"k\":1.5},\"tags\":[\"a\",\"b\"]}\n"},
+		{"csv quoting", formatCSV, quoting, "level,msg,n\n\"a,b\",\"say \"\"hi\"\"\nbye\",1000000000000000000000\n"},
+		{"markdown escaping", formatMarkdown, []map[string]interface{}{{"msg": "a|b\r\nc"}},
+			"| msg |\n| --- |\n| a\\|b<br>c |\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatRows(tt.format, tt.rows, schema, types)
+			if err != nil {
+				t.Fatalf("formatRows: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("formatRows = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatRowsUnsupported(t *testing.T) {
+	if _, err := formatRows("xml", nil, nil, nil); err == nil {
+		t.Error("formatRows accepted an unsupported format")
+	}
+}
@@ -0,0 +1,424 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-pb/redact"
+)
+
+const (
+	// Maximum number of points per series of a metric query
+	maxMetricPoints = 1000
+	// Maximum number of series a metric query returns
+	maxMetricSeries = 100
+	// Number of points the step is chosen for when none is given
+	targetMetricPoints = 100
+)
+
+var (
+	metricAggregations = []string{"sum", "avg", "min", "max", "count"}
+	// Steps chosen when none is given
+	niceSteps = []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute,
+		30 * time.Minute, time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour}
+	labelMatcherPattern = regexp.MustCompile(`^\s*([^=!~\s]+)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+)
+
+// metricFields are the fields of an OpenTelemetry metrics stream, as flattened by Parseable:
+// one row per data point with metric_name, metric_type, data_point_value or the histogram
+// data_point_count, data_point_sum and buckets, and the attributes as their own fields.
+type metricFields struct {
+	Name, Type, Unit, Description, Time string
+	Value, Count, Sum, Buckets, Bounds  string
+	Monotonic, Temporality              string
+	types                               map[string]string
+}
+
+// metricNonLabelPrefixes and metricNonLabelFields are the fields of a metrics stream that are
+// not attributes of the data points.
+var (
+	metricNonLabelPrefixes = []string{"metric_", "data_point_", "scope_", "exemplar", "p_", "aggregation_temporality"}
+	metricNonLabelFields   = []string{"time_unix_nano", "start_time_unix_nano", "is_monotonic", "schema_url", "flags",
+		"resource_dropped_attributes_count"}
+)
+
+func resolveMetricFields(types map[string]string) (metricFields, error) {
+	f := metricFields{
+		Name:        findField(types, "metric_name", "name"),
+		Type:        findField(types, "metric_type", "type"),
+		Unit:        findField(types, "metric_unit", "unit"),
+		Description: findField(types, "metric_description", "description"),
+		Time:        findField(types, "time_unix_nano", "data_point_time_unix_nano", "timestamp", "p_timestamp"),
+		Value:       findField(types, "data_point_value", "value"),
+		Count:       findField(types, "data_point_count"),
+		Sum:         findField(types, "data_point_sum"),
+		Buckets:     findField(types, "data_point_bucket_counts"),
+		Bounds:      findField(types, "data_point_explicit_bounds"),
+		Monotonic:   findField(types, "is_monotonic"),
+		Temporality: findField(types, "aggregation_temporality_description", "aggregation_temporality"),
+		types:       types,
+	}
+	if f.Name == "" || f.Time == "" || (f.Value == "" && f.Count == "") {
+		return f, fmt.Errorf("the stream schema has no metric_name, time or value fields, it does not look like an OpenTelemetry metrics stream")
+	}
+	return f, nil
+}
+
+// isLabel reports whether a field of a metrics stream is an attribute of the data points.
+func (f metricFields) isLabel(field string) bool {
+	if slices.Contains(metricNonLabelFields, field) {
+		return false
+	}
+	for _, prefix := range metricNonLabelPrefixes {
+		if strings.HasPrefix(field, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// labels returns the attribute fields of the stream, sorted.
+func (f metricFields) labels() []string {
+	var labels []string
+	for field := range f.types {
+		if f.isLabel(field) {
+			labels = append(labels, field)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// bucketExpr is SQL for the start of the step a data point falls in.
+func (f metricFields) bucketExpr(step time.Duration) string {
+	return fmt.Sprintf("date_bin(INTERVAL '%d seconds', to_timestamp_nanos(%s), TIMESTAMP '1970-01-01T00:00:00')",
+		int64(step.Seconds()), quoteIdent(f.Time))
+}
+
+// seriesExpr is SQL identifying the series of a data point: the values of all its attributes.
+func (f metricFields) seriesExpr() string {
+	labels := f.labels()
+	if len(labels) == 0 {
+		return "''"
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("COALESCE(CAST(%s AS VARCHAR), '')", quoteIdent(label)))
+	}
+	return "concat_ws('|', " + strings.Join(parts, ", ") + ")"
+}
+
+// labelMatcher is a filter on an attribute, written as in PromQL: label="value",
+// label!="value", label=~"regex" or label!~"regex".
+type labelMatcher struct {
+	Label string `json:"label"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+func (m labelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Label, m.Op, m.Value)
+}
+
+// parseLabelMatcher parses a filter such as service.name="payments" or status=~"5.."; quotes
+// around the value are optional.
+func parseLabelMatcher(s string) (labelMatcher, error) {
+	parts := labelMatcherPattern.FindStringSubmatch(s)
+	if parts == nil {
+		return labelMatcher{}, fmt.Errorf("invalid label filter %q, expected e.g. service.name=\"payments\" or status=~\"5..\"", s)
+	}
+	m := labelMatcher{Label: parts[1], Op: parts[2], Value: parts[3]}
+	if len(m.Value) >= 2 && (m.Value[0] == '"' || m.Value[0] == '\'') && m.Value[len(m.Value)-1] == m.Value[0] {
+		m.Value = m.Value[1 : len(m.Value)-1]
+	}
+	return m, m.check()
+}
+
+// check validates the regular expression of a matcher.
+func (m labelMatcher) check() error {
+	if m.Op == "=~" || m.Op == "!~" {
+		if _, err := regexp.Compile(m.Value); err != nil {
+			return fmt.Errorf("invalid regular expression in label filter %s: %w", m, err)
+		}
+	}
+	return nil
+}
+
+// sql is the SQL condition of a matcher. As in PromQL, regular expressions must match the
+// whole value and a missing attribute counts as the empty string.
+func (m labelMatcher) sql() string {
+	column := fmt.Sprintf("COALESCE(CAST(%s AS VARCHAR), '')", quoteIdent(m.Label))
+	switch m.Op {
+	case "!=":
+		return column + " <> " + quoteLiteral(m.Value)
+	case "=~":
+		return column + " ~ " + quoteLiteral("^(?:"+m.Value+")$")
+	case "!~":
+		return column + " !~ " + quoteLiteral("^(?:"+m.Value+")$")
+	}
+	return column + " = " + quoteLiteral(m.Value)
+}
+
+// checkLabels returns an error naming the labels that are not attributes in the stream schema.
+func (f metricFields) checkLabels(labels []string) error {
+	var unknown []string
+	for _, label := range labels {
+		if _, ok := f.types[label]; !ok || !f.isLabel(label) {
+			unknown = append(unknown, label)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown labels: %s; the stream has: %s", strings.Join(unknown, ", "), strings.Join(f.labels(), ", "))
+	}
+	return nil
+}
+
+// metricStep returns the step given, or else one that gives about targetMetricPoints points
+// over the range. It fails if the step would give more than maxMetricPoints points.
+func metricStep(stepArg string, start time.Time, end time.Time) (time.Duration, error) {
+	span := end.Sub(start)
+	if stepArg == "" {
+		for _, step := range niceSteps {
+			if span/step <= targetMetricPoints {
+				return step, nil
+			}
+		}
+		return niceSteps[len(niceSteps)-1], nil
+	}
+	step, err := parseHumanDuration(stepArg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step %q: %w", stepArg, err)
+	}
+	if step < time.Second || step%time.Second != 0 {
+		return 0, fmt.Errorf("step must be a whole number of seconds, e.g. '30s' or '5m'")
+	}
+	if span/step > maxMetricPoints {
+		return 0, fmt.Errorf("step %s gives more than %d points over the time range, use a larger step", step, maxMetricPoints)
+	}
+	return step, nil
+}
+
+// metricPoint is a value of a series at the start of a step.
+type metricPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// MarshalJSON writes a point as [time, value], like Prometheus range query results.
+func (p metricPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.Time.UTC().Format(time.RFC3339), p.Value})
+}
+
+// metricSeries is a series of a metric query result: the values of its labels and its points
+// in time order.
+type metricSeries struct {
+	Labels map[string]string `json:"labels"`
+	Points []metricPoint     `json:"points"`
+}
+
+func seriesKey(labels map[string]string) string {
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+// rowLabels reads the values of labels from a result row.
+func rowLabels(row map[string]interface{}, labels []string) map[string]string {
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		values[label] = stringValue(row[label])
+	}
+	return values
+}
+
+func rowBucket(row map[string]interface{}) (time.Time, bool) {
+	ns, ok := unixNanos(row["bucket"])
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns).UTC(), true
+}
+
+func rowValue(row map[string]interface{}) (float64, bool) {
+	v, ok := row["value"].(float64)
+	return v, ok && !math.IsNaN(v)
+}
+
+// aggregateValues combines the values of several series at one point in time.
+func aggregateValues(values []float64, aggregation string) float64 {
+	if aggregation == "count" {
+		return float64(len(values))
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		switch aggregation {
+		case "min":
+			result = min(result, v)
+		case "max":
+			result = max(result, v)
+		default:
+			result += v
+		}
+	}
+	if aggregation == "avg" {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// groupedSeries builds series from rows with one value per bucket and underlying series, such
+// as the average of a gauge, by aggregating the values of the underlying series per group.
+func groupedSeries(rows []map[string]interface{}, groupBy []string, aggregation string) []metricSeries {
+	type group struct {
+		labels map[string]string
+		values map[time.Time][]float64
+	}
+	groups := map[string]*group{}
+	for _, row := range rows {
+		bucket, ok := rowBucket(row)
+		value, valueOK := rowValue(row)
+		if !ok || !valueOK {
+			continue
+		}
+		labels := rowLabels(row, groupBy)
+		key := seriesKey(labels)
+		if groups[key] == nil {
+			groups[key] = &group{labels: labels, values: map[time.Time][]float64{}}
+		}
+		groups[key].values[bucket] = append(groups[key].values[bucket], value)
+	}
+	bySeries := map[string]*metricSeries{}
+	for key, g := range groups {
+		series := &metricSeries{Labels: g.labels}
+		for bucket, values := range g.values {
+			series.Points = append(series.Points, metricPoint{Time: bucket, Value: aggregateValues(values, aggregation)})
+		}
+		bySeries[key] = series
+	}
+	return sortedSeries(bySeries)
+}
+
+// rateSeries computes per second rates from rows with one value per bucket and underlying
+// series ("series" column): the last value of a cumulative counter, or the sum of delta
+// values. Rates of the underlying series are then aggregated per group. With increase, the
+// increase per step is returned instead of the rate per second.
+func rateSeries(rows []map[string]interface{}, groupBy []string, cumulative bool, step time.Duration, aggregation string, increase bool) []metricSeries {
+	type sample struct {
+		bucket time.Time
+		value  float64
+	}
+	type underlying struct {
+		labels  map[string]string
+		samples []sample
+	}
+	underlyingSeries := map[string]*underlying{}
+	for _, row := range rows {
+		bucket, ok := rowBucket(row)
+		value, valueOK := rowValue(row)
+		if !ok || !valueOK {
+			continue
+		}
+		labels := rowLabels(row, groupBy)
+		key := seriesKey(labels) + "\x00" + stringValue(row["series"])
+		if underlyingSeries[key] == nil {
+			underlyingSeries[key] = &underlying{labels: labels}
+		}
+		underlyingSeries[key].samples = append(underlyingSeries[key].samples, sample{bucket, value})
+	}
+
+	// Rates per group and bucket, before aggregating
+	type groupRates struct {
+		labels map[string]string
+		values map[time.Time][]float64
+	}
+	groups := map[string]*groupRates{}
+	for _, u := range underlyingSeries {
+		sort.Slice(u.samples, func(i, j int) bool { return u.samples[i].bucket.Before(u.samples[j].bucket) })
+		key := seriesKey(u.labels)
+		if groups[key] == nil {
+			groups[key] = &groupRates{labels: u.labels, values: map[time.Time][]float64{}}
+		}
+		for i, s := range u.samples {
+			var delta float64
+			elapsed := step
+			if cumulative {
+				if i == 0 {
+					continue
+				}
+				prev := u.samples[i-1]
+				delta = s.value - prev.value
+				if delta < 0 {
+					// Counter reset
+					delta = s.value
+				}
+				elapsed = s.bucket.Sub(prev.bucket)
+			} else {
+				delta = s.value
+			}
+			rate := delta / elapsed.Seconds()
+			if increase {
+				rate *= step.Seconds()
+			}
+			groups[key].values[s.bucket] = append(groups[key].values[s.bucket], rate)
+		}
+	}
+
+	bySeries := map[string]*metricSeries{}
+	for key, g := range groups {
+		if len(g.values) == 0 {
+			continue
+		}
+		series := &metricSeries{Labels: g.labels}
+		for bucket, values := range g.values {
+			series.Points = append(series.Points, metricPoint{Time: bucket, Value: aggregateValues(values, aggregation)})
+		}
+		bySeries[key] = series
+	}
+	return sortedSeries(bySeries)
+}
+
+// sortedSeries returns the series ordered by their labels, with points in time order.
+func sortedSeries(bySeries map[string]*metricSeries) []metricSeries {
+	keys := make([]string, 0, len(bySeries))
+	for key := range bySeries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]metricSeries, 0, len(keys))
+	for _, key := range keys {
+		s := bySeries[key]
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+		series = append(series, *s)
+	}
+	return series
+}
+
+// redactSeriesLabels redacts the label values of series with the column rules of stream, as
+// redactQueryRows does for rows. Dropped labels are removed from the series.
+func redactSeriesLabels(ctx context.Context, stream string, series []metricSeries) (*redact.Report, error) {
+	rows := make([]map[string]interface{}, len(series))
+	for i, s := range series {
+		rows[i] = make(map[string]interface{}, len(s.Labels))
+		for label, value := range s.Labels {
+			rows[i][label] = value
+		}
+	}
+	report, err := redactQueryRows(ctx, stream, rows)
+	if err != nil || report == nil {
+		return report, err
+	}
+	for i, row := range rows {
+		labels := make(map[string]string, len(row))
+		for label, value := range row {
+			labels[label] = stringValue(value)
+		}
+		series[i].Labels = labels
+	}
+	return report, nil
+}
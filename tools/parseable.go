@@ -2,13 +2,19 @@ package tools
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+
+	"mcp-pb/audit"
+	"mcp-pb/redact"
 )
 
 // These variables must be set by main.go before calling RegisterParseableTools
@@ -18,6 +24,18 @@ var (
 	ParseablePass    string
 )
 
+// ResultRedactor masks sensitive data in results before they are returned to the client.
+// It is optional and set by main.go when a redaction config is given.
+var ResultRedactor *redact.Redactor
+
+// ResultTokenBudget is the estimated token size above which query_data_stream returns a digest
+// instead of rows. Zero disables automatic summarization. Set by main.go.
+var ResultTokenBudget int
+
+// WritesEnabled allows tools that change Parseable state, e.g. creating or deleting alerts.
+// Write tools refuse to run unless it is set by main.go.
+var WritesEnabled bool
+
 // package-level HTTP client; initialized in init() to respect UNSECURE env var
 var HTTPClient *http.Client
 
@@ -41,7 +59,7 @@ func addBasicAuth(req *http.Request) {
 	req.SetBasicAuth(ParseableUser, ParseablePass)
 }
 
-func doParseableQuery(query string, streamName string, startTime string, endTime string) ([]map[string]interface{}, error) {
+func doParseableQuery(ctx context.Context, query string, streamName string, startTime string, endTime string) ([]map[string]interface{}, error) {
 	payload := map[string]string{
 		"query":      query,
 		"streamName": streamName,
@@ -50,7 +68,8 @@ func doParseableQuery(query string, streamName string, startTime string, endTime
 	}
 	jsonPayload, _ := json.Marshal(payload)
 	url := ParseableBaseURL + parseableSQLPath
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	audit.NoteEndpoint(ctx, "POST", parseableSQLPath)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return nil, err
 	}
@@ -75,65 +94,51 @@ func doParseableQuery(query string, streamName string, startTime string, endTime
 	return arrResult, nil
 }
 
-func listParseableStreams() ([]map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/logstream"
-	return doSimpleGetArray(url)
+func listParseableStreams(ctx context.Context) ([]map[string]interface{}, error) {
+	return doSimpleGetArray(ctx, "/api/v1/logstream")
 }
 
-func getParseableSchema(stream string) (map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/logstream/" + stream + "/schema"
-	httpReq, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	addBasicAuth(httpReq)
-	resp, err := HTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			slog.Error("failed to close response body", "error", err)
-		}
-	}()
+func getParseableSchema(ctx context.Context, stream string) (map[string]interface{}, error) {
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := doParseableRequest(ctx, "GET", "/api/v1/logstream/"+stream+"/schema", nil, &result); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func getParseableStats(streamName string) (map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/logstream/" + streamName + "/stats"
-	stats, _, err := doSimpleGet(url)
+func getParseableStats(ctx context.Context, streamName string) (map[string]interface{}, error) {
+	stats, _, err := doSimpleGet(ctx, "/api/v1/logstream/"+streamName+"/stats")
 	return stats, err
 }
 
-func getParseableInfo(streamName string) (map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/logstream/" + streamName + "/info"
-	info, _, err := doSimpleGet(url)
+func getParseableInfo(ctx context.Context, streamName string) (map[string]interface{}, error) {
+	info, _, err := doSimpleGet(ctx, "/api/v1/logstream/"+streamName+"/info")
 	return info, err
 }
 
-func getParseableAbout() (map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/about"
-	about, _, err := doSimpleGet(url)
+func getParseableAbout(ctx context.Context) (map[string]interface{}, error) {
+	about, _, err := doSimpleGet(ctx, "/api/v1/about")
 	return about, err
 }
 
-func getParseableRoles() (map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/roles"
-	roles, _, err := doSimpleGet(url)
+func getParseableRoles(ctx context.Context) (map[string]interface{}, error) {
+	roles, _, err := doSimpleGet(ctx, "/api/v1/roles")
 	return roles, err
 }
 
-func getParseableUsers() ([]map[string]interface{}, error) {
-	url := ParseableBaseURL + "/api/v1/users"
-	return doSimpleGetArray(url)
+func getParseableUsers(ctx context.Context) ([]map[string]interface{}, error) {
+	return doSimpleGetArray(ctx, "/api/v1/users")
 }
 
-func doSimpleGet(url string) (map[string]interface{}, map[string]interface{}, error) {
-	httpReq, err := http.NewRequest("GET", url, nil)
+func getParseableUserGroups(ctx context.Context) ([]map[string]interface{}, error) {
+	var groups []map[string]interface{}
+	err := doParseableRequest(ctx, "GET", "/api/v1/usergroup", nil, &groups)
+	return groups, err
+}
+
+func doSimpleGet(ctx context.Context, path string) (map[string]interface{}, map[string]interface{}, error) {
+	audit.NoteEndpoint(ctx, "GET", path)
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", ParseableBaseURL+path, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -154,8 +159,9 @@ func doSimpleGet(url string) (map[string]interface{}, map[string]interface{}, er
 	return response, nil, nil
 }
 
-func doSimpleGetArray(url string) ([]map[string]interface{}, error) {
-	httpReq, err := http.NewRequest("GET", url, nil)
+func doSimpleGetArray(ctx context.Context, path string) ([]map[string]interface{}, error) {
+	audit.NoteEndpoint(ctx, "GET", path)
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", ParseableBaseURL+path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -175,3 +181,78 @@ func doSimpleGetArray(url string) ([]map[string]interface{}, error) {
 	}
 	return response, nil
 }
+
+// doParseableRequest sends a request with an optional JSON body to the Parseable API and decodes
+// the JSON response into out, if out is not nil. Responses outside 2xx are returned as errors
+// including the response body, which is where Parseable puts the reason.
+func doParseableRequest(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	return doParseableRequestWithHeaders(ctx, method, path, nil, body, out)
+}
+
+// doParseableRequestWithHeaders is doParseableRequest with extra request headers, which is how
+// Parseable takes stream options such as X-P-Time-Partition.
+func doParseableRequestWithHeaders(ctx context.Context, method string, path string, headers map[string]string, body interface{}, out interface{}) error {
+	respBody, err := sendParseableRequest(ctx, method, path, headers, body)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(bytes.TrimSpace(respBody)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// doParseableRequestText is doParseableRequest for endpoints that answer with plain text, such as
+// a generated password. A JSON string response is unquoted.
+func doParseableRequestText(ctx context.Context, method string, path string, body interface{}) (string, error) {
+	respBody, err := sendParseableRequest(ctx, method, path, nil, body)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(string(respBody))
+	var s string
+	if strings.HasPrefix(text, `"`) && json.Unmarshal([]byte(text), &s) == nil {
+		return s, nil
+	}
+	return text, nil
+}
+
+func sendParseableRequest(ctx context.Context, method string, path string, headers map[string]string, body interface{}) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
+	audit.NoteEndpoint(ctx, method, path)
+	httpReq, err := http.NewRequestWithContext(ctx, method, ParseableBaseURL+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		httpReq.Header.Set(name, value)
+	}
+	addBasicAuth(httpReq)
+	resp, err := HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("failed to close response body", "error", err)
+		}
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
@@ -46,7 +46,7 @@ Licensing:
 Use this tool to check Parseable capabilities, version information, and configuration state.
 `),
 	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		about, err := getParseableAbout()
+		about, err := getParseableAbout(ctx)
 		if err != nil {
 			slog.Error("failed to get response", "tool", "get_about", "error", err)
 			return mcp.NewToolResultError(err.Error()), nil
@@ -0,0 +1,428 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+// allStreams is the stream of grants that apply to every stream, such as admin.
+const allStreams = "*"
+
+// Privileges that allow querying a stream, and ingesting into it
+var (
+	readPrivileges   = []string{"admin", "editor", "writer", "reader"}
+	ingestPrivileges = []string{"admin", "editor", "writer", "ingestor"}
+)
+
+// accessGrant is one privilege a user holds, and the role, and group if any, it comes from.
+type accessGrant struct {
+	Privilege string `json:"privilege"`
+	Stream    string `json:"stream"`
+	Role      string `json:"role"`
+	Group     string `json:"group,omitempty"`
+}
+
+func (g accessGrant) via() string {
+	if g.Group != "" {
+		return fmt.Sprintf("role %s of group %s", g.Role, g.Group)
+	}
+	return "role " + g.Role
+}
+
+// userAccess is the effective access of a user: all grants, and the privileges per stream.
+type userAccess struct {
+	Username string              `json:"username"`
+	Method   string              `json:"method,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Admin    bool                `json:"admin"`
+	Global   []string            `json:"globalPrivileges"`
+	Streams  map[string][]string `json:"streams"`
+	Grants   []accessGrant       `json:"grants"`
+}
+
+// roleGrants turns the privileges of a role into grants. Privileges without a stream, such as
+// admin and editor, apply to all streams.
+func roleGrants(role string, group string, privileges interface{}) []accessGrant {
+	var grants []accessGrant
+	list, _ := privileges.([]interface{})
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		grant := accessGrant{Privilege: firstString(m, "privilege"), Stream: allStreams, Role: role, Group: group}
+		if resource, ok := m["resource"].(map[string]interface{}); ok {
+			if stream := firstString(resource, "stream"); stream != "" {
+				grant.Stream = stream
+			}
+		}
+		if grant.Privilege != "" {
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// userGroupNames returns the sorted names of the groups of a user. Groups are names, or
+// objects with a name.
+func userGroupNames(user map[string]interface{}) []string {
+	names := []string{}
+	groups, _ := user["user_groups"].([]interface{})
+	for _, group := range groups {
+		switch g := group.(type) {
+		case string:
+			names = append(names, g)
+		case map[string]interface{}:
+			if name := firstString(g, "name", "id"); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return sortedUnique(names)
+}
+
+// userGrants returns the grants of a user from its roles and the roles of its groups, from
+// group_roles of the user or else from the groups it is a member of. Roles are looked up in
+// roles when only their names are known.
+func userGrants(user map[string]interface{}, roles map[string]interface{}, groups []UserGroup) []accessGrant {
+	grants := []accessGrant{}
+	direct, _ := user["roles"].(map[string]interface{})
+	for _, role := range userRoleNames(user) {
+		privileges, ok := direct[role]
+		if !ok {
+			privileges = roles[role]
+		}
+		grants = append(grants, roleGrants(role, "", privileges)...)
+	}
+	// group_roles maps group names to their roles and privileges
+	groupRoles, _ := user["group_roles"].(map[string]interface{})
+	for group, value := range groupRoles {
+		groupRoleMap, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for role, privileges := range groupRoleMap {
+			if privileges == nil {
+				privileges = roles[role]
+			}
+			grants = append(grants, roleGrants(role, group, privileges)...)
+		}
+	}
+	username := firstString(user, "username", "id")
+	for _, group := range groups {
+		if _, ok := groupRoles[group.Name]; ok || !slices.Contains(group.Users, username) {
+			continue
+		}
+		for _, role := range group.Roles {
+			grants = append(grants, roleGrants(role, group.Name, roles[role])...)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.Stream != b.Stream {
+			return a.Stream < b.Stream
+		}
+		if a.Privilege != b.Privilege {
+			return a.Privilege < b.Privilege
+		}
+		return a.via() < b.via()
+	})
+	return grants
+}
+
+// summarizeGrants returns whether the grants include admin, the privileges on all streams and
+// the privileges per stream.
+func summarizeGrants(grants []accessGrant) (admin bool, global []string, streams map[string][]string) {
+	global = []string{}
+	streams = map[string][]string{}
+	for _, grant := range grants {
+		if grant.Privilege == "admin" {
+			admin = true
+		}
+		if grant.Stream == allStreams {
+			global = append(global, grant.Privilege)
+		} else {
+			streams[grant.Stream] = append(streams[grant.Stream], grant.Privilege)
+		}
+	}
+	global = sortedUnique(global)
+	for stream, privileges := range streams {
+		streams[stream] = sortedUnique(privileges)
+	}
+	return admin, global, streams
+}
+
+// computeUserAccess sums up the grants of a user per stream.
+func computeUserAccess(user map[string]interface{}, roles map[string]interface{}, groups []UserGroup) userAccess {
+	access := userAccess{
+		Username: firstString(user, "username", "id"),
+		Method:   firstString(user, "method"),
+		Groups:   userGroupNames(user),
+		Grants:   userGrants(user, roles, groups),
+	}
+	for _, group := range groups {
+		if slices.Contains(group.Users, access.Username) {
+			access.Groups = append(access.Groups, group.Name)
+		}
+	}
+	access.Groups = sortedUnique(access.Groups)
+	access.Admin, access.Global, access.Streams = summarizeGrants(access.Grants)
+	return access
+}
+
+// groupAccess is the access a group grants to its members.
+type groupAccess struct {
+	Name         string              `json:"name"`
+	Roles        []string            `json:"roles"`
+	Users        []string            `json:"users"`
+	Admin        bool                `json:"admin"`
+	Global       []string            `json:"globalPrivileges"`
+	Streams      map[string][]string `json:"streams"`
+	UnknownRoles []string            `json:"unknownRoles"`
+}
+
+func computeGroupAccess(group UserGroup, roles map[string]interface{}) groupAccess {
+	access := groupAccess{Name: group.Name, Roles: group.Roles, Users: group.Users, UnknownRoles: []string{}}
+	var grants []accessGrant
+	for _, role := range group.Roles {
+		privileges, ok := roles[role]
+		if !ok {
+			access.UnknownRoles = append(access.UnknownRoles, role)
+			continue
+		}
+		grants = append(grants, roleGrants(role, group.Name, privileges)...)
+	}
+	access.Admin, access.Global, access.Streams = summarizeGrants(grants)
+	return access
+}
+
+// streamAccess returns the grants of a user that give one of the privileges on stream.
+func streamAccess(access userAccess, stream string, privileges []string) []accessGrant {
+	var grants []accessGrant
+	for _, grant := range access.Grants {
+		if (grant.Stream == stream || grant.Stream == allStreams) && slices.Contains(privileges, grant.Privilege) {
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// accessReport is the result of analyze_access.
+type accessReport struct {
+	Users               []userAccess        `json:"users"`
+	Admins              []string            `json:"admins"`
+	MissingStreamGrants []map[string]string `json:"rolesWithMissingStreams"`
+	OrphanedRoles       []string            `json:"orphanedRoles"`
+	Groups              []groupAccess       `json:"groups"`
+	EmptyGroups         []string            `json:"emptyGroups"`
+	Readers             []map[string]string `json:"readers,omitempty"`
+	Ingestors           []map[string]string `json:"ingestors,omitempty"`
+}
+
+// analyzeAccess computes the access report. streams is nil if the stream list is not known, in
+// which case grants on missing streams are not checked.
+func analyzeAccess(users []map[string]interface{}, roles map[string]interface{}, groups []UserGroup, streams []string, stream string) accessReport {
+	report := accessReport{
+		Users:               []userAccess{},
+		Admins:              []string{},
+		MissingStreamGrants: []map[string]string{},
+		OrphanedRoles:       []string{},
+		Groups:              []groupAccess{},
+		EmptyGroups:         []string{},
+	}
+	usedRoles := map[string]bool{}
+	for _, group := range groups {
+		for _, role := range group.Roles {
+			usedRoles[role] = true
+		}
+		if len(group.Users) == 0 {
+			report.EmptyGroups = append(report.EmptyGroups, group.Name)
+		}
+		report.Groups = append(report.Groups, computeGroupAccess(group, roles))
+	}
+	for _, user := range users {
+		access := computeUserAccess(user, roles, groups)
+		for _, grant := range access.Grants {
+			usedRoles[grant.Role] = true
+		}
+		for _, role := range userRoleNames(user) {
+			usedRoles[role] = true
+		}
+		if access.Admin {
+			report.Admins = append(report.Admins, access.Username)
+		}
+		if stream != "" {
+			for _, grant := range streamAccess(access, stream, readPrivileges) {
+				report.Readers = append(report.Readers, map[string]string{"username": access.Username, "privilege": grant.Privilege, "via": grant.via()})
+			}
+			for _, grant := range streamAccess(access, stream, ingestPrivileges) {
+				report.Ingestors = append(report.Ingestors, map[string]string{"username": access.Username, "privilege": grant.Privilege, "via": grant.via()})
+			}
+		}
+		report.Users = append(report.Users, access)
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].Username < report.Users[j].Username })
+	sort.Strings(report.Admins)
+	for _, rows := range [][]map[string]string{report.Readers, report.Ingestors} {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i]["username"] < rows[j]["username"] })
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for name := range roles {
+		roleNames = append(roleNames, name)
+	}
+	sort.Strings(roleNames)
+	for _, name := range roleNames {
+		if !usedRoles[name] {
+			report.OrphanedRoles = append(report.OrphanedRoles, name)
+		}
+		if streams == nil {
+			continue
+		}
+		for _, grant := range roleGrants(name, "", roles[name]) {
+			if grant.Stream != allStreams && !slices.Contains(streams, grant.Stream) {
+				report.MissingStreamGrants = append(report.MissingStreamGrants, map[string]string{"role": name, "privilege": grant.Privilege, "stream": grant.Stream})
+			}
+		}
+	}
+	return report
+}
+
+// redactAccessReport redacts the usernames in report in place and returns the counts.
+func redactAccessReport(report *accessReport) redact.Report {
+	var redactions redact.Report
+	for i := range report.Users {
+		report.Users[i].Username = redactUsername(report.Users[i].Username, &redactions)
+	}
+	for i, name := range report.Admins {
+		report.Admins[i] = redactUsername(name, &redactions)
+	}
+	for _, group := range report.Groups {
+		for i, name := range group.Users {
+			group.Users[i] = redactUsername(name, &redactions)
+		}
+	}
+	for _, rows := range [][]map[string]string{report.Readers, report.Ingestors} {
+		for _, row := range rows {
+			row["username"] = redactUsername(row["username"], &redactions)
+		}
+	}
+	return redactions
+}
+
+func RegisterAnalyzeAccessTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"analyze_access",
+		mcp.WithDescription(`Analyze who can access what in Parseable, for security audits and access questions such as "who can read stream X?".
+Combines /api/v1/users, /api/v1/roles, /api/v1/usergroup and the stream list. Effective access includes the roles of
+the groups a user is a member of.
+
+Privileges: admin and editor apply to all streams; reader, writer and ingestor to one stream.
+admin, editor, writer and reader can query a stream; admin, editor, writer and ingestor can ingest into it.
+
+Returns a JSON object with:
+- users: per user the username, method, groups, admin flag, globalPrivileges (apply to all streams),
+  streams (privileges per stream) and grants (each privilege with its stream, '*' for all, role and group)
+- admins: users with the admin privilege
+- rolesWithMissingStreams: role privileges on streams that do not exist
+- orphanedRoles: roles not assigned to any user or group
+- groups: per group its roles, users, admin flag, globalPrivileges, streams and unknownRoles (roles that do not exist)
+- emptyGroups: groups without users
+- readers, ingestors: with stream, the users who can query or ingest into it, with the privilege and where it comes from
+- count: number of users
+If redaction is configured, usernames are redacted like those of get_users and 'redactions' reports the 'total' count and counts 'byRule'.
+`),
+		mcp.WithString("stream", mcp.Description("Optional stream to answer who can read and ingest into it")),
+		mcp.WithString("username", mcp.Description("Optional user to limit the users list to")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		stream := mcp.ParseString(req, "stream", "")
+		username := mcp.ParseString(req, "username", "")
+
+		users, err := getParseableUsers(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "analyze_access")
+			return mcp.NewToolResultError("failed to get users: " + err.Error()), nil
+		}
+		roles, err := getParseableRoles(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "analyze_access")
+			return mcp.NewToolResultError("failed to get roles: " + err.Error()), nil
+		}
+		var groups []UserGroup
+		var groupsError string
+		if groups, err = getUserGroups(ctx); err != nil {
+			groupsError = err.Error()
+		}
+		var streams []string
+		var streamsError string
+		if list, err := listParseableStreams(ctx); err != nil {
+			streamsError = err.Error()
+		} else {
+			streams = []string{}
+			for _, s := range list {
+				streams = append(streams, firstString(s, "name"))
+			}
+		}
+
+		report := analyzeAccess(users, roles, groups, streams, stream)
+		if username != "" {
+			var filtered []userAccess
+			for _, access := range report.Users {
+				if access.Username == username {
+					filtered = append(filtered, access)
+				}
+			}
+			if filtered == nil {
+				return mcp.NewToolResultError(fmt.Sprintf("user %q not found", username)), nil
+			}
+			report.Users = filtered
+		}
+
+		var redactions redact.Report
+		if ResultRedactor != nil {
+			redactions = redactAccessReport(&report)
+		}
+		result := map[string]interface{}{
+			"users":                   report.Users,
+			"admins":                  report.Admins,
+			"rolesWithMissingStreams": report.MissingStreamGrants,
+			"orphanedRoles":           report.OrphanedRoles,
+			"groups":                  report.Groups,
+			"emptyGroups":             report.EmptyGroups,
+			"count":                   len(report.Users),
+		}
+		if stream != "" {
+			if streams != nil && !slices.Contains(streams, stream) {
+				result["streamWarning"] = fmt.Sprintf("stream %q does not exist", stream)
+			}
+			result["stream"] = stream
+			result["readers"] = nonNil(report.Readers)
+			result["ingestors"] = nonNil(report.Ingestors)
+		}
+		if ResultRedactor != nil {
+			result["redactions"] = redactions
+		}
+		if streamsError != "" {
+			result["streamsError"] = "streams could not be listed, grants on missing streams were not checked: " + streamsError
+		}
+		if groupsError != "" {
+			result["groupsError"] = "user groups could not be listed, only group_roles of users were used: " + groupsError
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func nonNil(rows []map[string]string) []map[string]string {
+	if rows == nil {
+		return []map[string]string{}
+	}
+	return rows
+}
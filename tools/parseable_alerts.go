@@ -0,0 +1,235 @@
+package tools
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const parseableAlertsPath = "/api/v1/alerts"
+
+// AlertSummary is an entry of the alert list returned by /api/v1/alerts.
+type AlertSummary struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Severity  string   `json:"severity"`
+	State     string   `json:"state"`
+	AlertType string   `json:"alertType,omitempty"`
+	Datasets  []string `json:"datasets,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Created   string   `json:"created,omitempty"`
+}
+
+// ThresholdConfig is the condition of a threshold alert: the query result compared to a value.
+type ThresholdConfig struct {
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+}
+
+// RollingWindow is the window an alert query is evaluated over, e.g. evalStart "5m" and
+// evalEnd "now", re-evaluated every evalFrequency minutes.
+type RollingWindow struct {
+	EvalStart     string `json:"evalStart"`
+	EvalEnd       string `json:"evalEnd"`
+	EvalFrequency int    `json:"evalFrequency"`
+}
+
+// EvalConfig holds how an alert is evaluated.
+type EvalConfig struct {
+	RollingWindow RollingWindow `json:"rollingWindow"`
+}
+
+// NotificationConfig holds how often notifications are repeated, in minutes.
+type NotificationConfig struct {
+	Interval int `json:"interval"`
+}
+
+// Alert is the full definition and current state of a Parseable alert.
+type Alert struct {
+	Version            string              `json:"version,omitempty"`
+	ID                 string              `json:"id"`
+	Title              string              `json:"title"`
+	Severity           string              `json:"severity"`
+	Query              string              `json:"query"`
+	AlertType          string              `json:"alertType"`
+	Datasets           []string            `json:"datasets"`
+	ThresholdConfig    ThresholdConfig     `json:"thresholdConfig"`
+	EvalConfig         EvalConfig          `json:"evalConfig"`
+	NotificationConfig *NotificationConfig `json:"notificationConfig,omitempty"`
+	Targets            []string            `json:"targets"`
+	State              string              `json:"state,omitempty"`
+	NotificationState  string              `json:"notificationState,omitempty"`
+	Tags               []string            `json:"tags,omitempty"`
+	Created            string              `json:"created,omitempty"`
+	LastTriggeredAt    string              `json:"lastTriggeredAt,omitempty"`
+}
+
+// AlertStateTransition is a change of alert state, e.g. to "triggered" or "resolved".
+type AlertStateTransition struct {
+	State         string `json:"state"`
+	LastUpdatedAt string `json:"last_updated_at"`
+}
+
+// AlertStateHistory is the state history of an alert, oldest transition first.
+type AlertStateHistory struct {
+	AlertID string                 `json:"alert_id"`
+	States  []AlertStateTransition `json:"states"`
+}
+
+func listParseableAlerts(ctx context.Context) ([]AlertSummary, error) {
+	var alerts []AlertSummary
+	err := doParseableRequest(ctx, "GET", parseableAlertsPath, nil, &alerts)
+	return alerts, err
+}
+
+func getParseableAlert(ctx context.Context, alertID string) (*Alert, error) {
+	var alert Alert
+	if err := doParseableRequest(ctx, "GET", parseableAlertsPath+"/"+url.PathEscape(alertID), nil, &alert); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+func getParseableAlertStateHistory(ctx context.Context, alertID string) (*AlertStateHistory, error) {
+	var history AlertStateHistory
+	if err := doParseableRequest(ctx, "GET", parseableAlertsPath+"/"+url.PathEscape(alertID)+"/state", nil, &history); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(history.States, func(i, j int) bool {
+		ti, okI := parseTimestamp(history.States[i].LastUpdatedAt)
+		tj, okJ := parseTimestamp(history.States[j].LastUpdatedAt)
+		// Entries without a valid time go first, so the latest known state stays last
+		if !okI || !okJ {
+			return !okI && okJ
+		}
+		return ti.Before(tj)
+	})
+	return &history, nil
+}
+
+func RegisterListAlertsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_alerts",
+		mcp.WithDescription(`List the alerts configured in Parseable.
+Use this to find the id of an alert before calling get_alert or get_alert_state_history.
+Calls /api/v1/alerts.
+
+Returns a JSON object with an 'alerts' array and 'count' (number of alerts). Each alert includes:
+- id: unique alert identifier
+- title: alert name
+- severity: alert severity (e.g., "critical", "high", "medium", "low")
+- state: current state (e.g., "triggered", "not-triggered", "disabled")
+- alertType: kind of alert (e.g., "threshold", "anomaly", "forecast")
+- datasets: data streams the alert query runs against
+- tags: tags assigned to the alert
+- created: ISO 8601 timestamp when the alert was created
+`),
+		mcp.WithString("state", mcp.Description("Optional: only return alerts in this state, e.g. 'triggered'")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		state := mcp.ParseString(req, "state", "")
+
+		alerts, err := listParseableAlerts(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_alerts")
+			return mcp.NewToolResultError("failed to list alerts: " + err.Error()), nil
+		}
+		if state != "" {
+			filtered := alerts[:0]
+			for _, alert := range alerts {
+				if strings.EqualFold(alert.State, state) {
+					filtered = append(filtered, alert)
+				}
+			}
+			alerts = filtered
+		}
+		if alerts == nil {
+			alerts = []AlertSummary{}
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"alerts": alerts,
+			"count":  len(alerts),
+		})
+	})
+}
+
+func RegisterGetAlertTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_alert",
+		mcp.WithDescription(`Get the full definition and current state of a Parseable alert.
+Calls /api/v1/alerts/<alertId>.
+
+Returns a JSON object with:
+- id, title, severity, alertType, tags, created
+- query: the SQL query the alert evaluates
+- datasets: data streams the query runs against
+- thresholdConfig: the condition, 'operator' (e.g., ">", "<=", "=") and 'value' the query result is compared to
+- evalConfig.rollingWindow: 'evalStart' (e.g., "5m", how far back the query looks), 'evalEnd' (usually "now") and 'evalFrequency' (minutes between evaluations)
+- notificationConfig.interval: minutes between repeated notifications
+- targets: ids of the notification targets
+- state: current state (e.g., "triggered", "not-triggered", "disabled")
+- notificationState: whether notifications are active or muted
+- lastTriggeredAt: ISO 8601 timestamp of the last time the alert fired, if known
+
+To understand why an alert fired, use the explain-alert prompt or replay the query with query_data_stream over the evaluation window ending at the trigger time.
+`),
+		mcp.WithString("alertId", mcp.Required(), mcp.Description("Id of the alert, as returned by list_alerts")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alertID := mcp.ParseString(req, "alertId", "")
+		if alertID == "" {
+			slog.Warn("called with missing parameter", "parameter", "alertId", "tool", "get_alert")
+			return mcp.NewToolResultError("missing required field: alertId"), nil
+		}
+
+		alert, err := getParseableAlert(ctx, alertID)
+		if err != nil {
+			slog.Error("failed to get response", "alertId", alertID, "error", err, "tool", "get_alert")
+			return mcp.NewToolResultError("failed to get alert: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(alert)
+	})
+}
+
+func RegisterGetAlertStateHistoryTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_alert_state_history",
+		mcp.WithDescription(`Get the state transitions of a Parseable alert, oldest first.
+Use this to see when an alert fired and resolved.
+Calls /api/v1/alerts/<alertId>/state.
+
+Returns a JSON object with:
+- alertId: the alert id
+- transitions: array of state changes, each with 'state' (e.g., "triggered", "not-triggered", "disabled") and 'last_updated_at' (ISO 8601 timestamp of the change)
+- count: number of transitions
+`),
+		mcp.WithString("alertId", mcp.Required(), mcp.Description("Id of the alert, as returned by list_alerts")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alertID := mcp.ParseString(req, "alertId", "")
+		if alertID == "" {
+			slog.Warn("called with missing parameter", "parameter", "alertId", "tool", "get_alert_state_history")
+			return mcp.NewToolResultError("missing required field: alertId"), nil
+		}
+
+		history, err := getParseableAlertStateHistory(ctx, alertID)
+		if err != nil {
+			slog.Error("failed to get response", "alertId", alertID, "error", err, "tool", "get_alert_state_history")
+			return mcp.NewToolResultError("failed to get alert state history: " + err.Error()), nil
+		}
+		transitions := history.States
+		if transitions == nil {
+			transitions = []AlertStateTransition{}
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"alertId":     alertID,
+			"transitions": transitions,
+			"count":       len(transitions),
+		})
+	})
+}
@@ -0,0 +1,488 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// Maximum number of evaluations in an alert dry run; the step is widened to stay below it
+	maxDryRunEvaluations = 288
+	// Number of dry run evaluation queries run at the same time
+	dryRunConcurrency = 4
+	// Maximum number of firings listed in a dry run result
+	maxDryRunFirings = 50
+)
+
+var alertSeverities = []string{"critical", "high", "medium", "low"}
+
+// alertRequest is the body of the create and update alert requests.
+type alertRequest struct {
+	Title              string              `json:"title"`
+	Severity           string              `json:"severity"`
+	Query              string              `json:"query"`
+	AlertType          string              `json:"alertType"`
+	Datasets           []string            `json:"datasets"`
+	ThresholdConfig    ThresholdConfig     `json:"thresholdConfig"`
+	EvalConfig         EvalConfig          `json:"evalConfig"`
+	NotificationConfig *NotificationConfig `json:"notificationConfig,omitempty"`
+	Targets            []string            `json:"targets"`
+	Tags               []string            `json:"tags,omitempty"`
+}
+
+// alertValidation is the result of checking an alert definition against the stream schemas.
+type alertValidation struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type alertDryRunFiring struct {
+	At    string  `json:"at"`
+	Value float64 `json:"value"`
+}
+
+// alertDryRun reports how an alert would have behaved over a historical window.
+type alertDryRun struct {
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Step        string `json:"step"`
+	Evaluations int    `json:"evaluations"`
+	// ConditionMet counts evaluations where the condition held, TimesFired counts transitions
+	// from not met to met, which is when the alert would have notified.
+	ConditionMet      int                 `json:"conditionMet"`
+	TimesFired        int                 `json:"timesFired"`
+	FailedEvaluations int                 `json:"failedEvaluations,omitempty"`
+	FirstError        string              `json:"firstError,omitempty"`
+	Firings           []alertDryRunFiring `json:"firings"`
+	MinValue          *float64            `json:"minValue,omitempty"`
+	MaxValue          *float64            `json:"maxValue,omitempty"`
+}
+
+func alertDefinitionOptions() []mcp.ToolOption {
+	return []mcp.ToolOption{
+		mcp.WithString("title", mcp.Description("Alert name")),
+		mcp.WithString("severity", mcp.Enum(alertSeverities...), mcp.Description("Alert severity")),
+		mcp.WithString("query", mcp.Description("SQL query returning a single numeric value, e.g. 'SELECT COUNT(*) FROM app_logs WHERE level = 'error''. Do not add time conditions, the evaluation window is applied automatically.")),
+		mcp.WithArray("datasets", mcp.WithStringItems(), mcp.Description("Data streams the query reads. Must match the tables in the FROM clause.")),
+		mcp.WithString("operator", mcp.Enum(">", ">=", "<", "<=", "=", "!="), mcp.Description("Operator comparing the query result to the threshold")),
+		mcp.WithNumber("threshold", mcp.Description("Value the query result is compared to")),
+		mcp.WithString("evalStart", mcp.Description("How far back each evaluation looks, e.g. '5m', '1h' (default: 5m)")),
+		mcp.WithNumber("evalFrequency", mcp.Description("Minutes between evaluations (default: 1)")),
+		mcp.WithNumber("notificationInterval", mcp.Description("Optional minutes between repeated notifications while triggered")),
+		mcp.WithArray("targets", mcp.WithStringItems(), mcp.Description("Ids of the notification targets")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Optional tags")),
+		mcp.WithBoolean("dryRun", mcp.Description("If true, only validate the definition and evaluate it over a historical window without saving it. Does not need writes to be enabled.")),
+		mcp.WithString("dryRunStart", mcp.Description("Start of the dry run window in ISO 8601 format (default: 24 hours before dryRunEnd)")),
+		mcp.WithString("dryRunEnd", mcp.Description("End of the dry run window in ISO 8601 format (default: now)")),
+	}
+}
+
+// applyAlertArguments overrides the fields of def with the alert definition arguments present
+// in the request.
+func applyAlertArguments(req mcp.CallToolRequest, def *alertRequest) {
+	args := req.GetArguments()
+	if _, ok := args["title"]; ok {
+		def.Title = mcp.ParseString(req, "title", "")
+	}
+	if _, ok := args["severity"]; ok {
+		def.Severity = strings.ToLower(mcp.ParseString(req, "severity", ""))
+	}
+	if _, ok := args["query"]; ok {
+		def.Query = mcp.ParseString(req, "query", "")
+	}
+	if _, ok := args["datasets"]; ok {
+		def.Datasets = req.GetStringSlice("datasets", nil)
+	}
+	if _, ok := args["operator"]; ok {
+		def.ThresholdConfig.Operator = mcp.ParseString(req, "operator", "")
+	}
+	if _, ok := args["threshold"]; ok {
+		def.ThresholdConfig.Value = mcp.ParseFloat64(req, "threshold", 0)
+	}
+	if _, ok := args["evalStart"]; ok {
+		def.EvalConfig.RollingWindow.EvalStart = mcp.ParseString(req, "evalStart", "")
+	}
+	if _, ok := args["evalFrequency"]; ok {
+		def.EvalConfig.RollingWindow.EvalFrequency = mcp.ParseInt(req, "evalFrequency", 0)
+	}
+	if _, ok := args["notificationInterval"]; ok {
+		def.NotificationConfig = &NotificationConfig{Interval: mcp.ParseInt(req, "notificationInterval", 0)}
+	}
+	if _, ok := args["targets"]; ok {
+		def.Targets = req.GetStringSlice("targets", nil)
+	}
+	if _, ok := args["tags"]; ok {
+		def.Tags = req.GetStringSlice("tags", nil)
+	}
+}
+
+// validateAlertDefinition checks an alert definition and validates its query against the
+// schemas of its datasets. Fields missing from the schemas are warnings since the
+// reference check cannot tell every alias or expression apart from a field.
+func validateAlertDefinition(ctx context.Context, def alertRequest) alertValidation {
+	var v alertValidation
+	if strings.TrimSpace(def.Title) == "" {
+		v.Errors = append(v.Errors, "title is required")
+	}
+	severityOK := false
+	for _, s := range alertSeverities {
+		severityOK = severityOK || def.Severity == s
+	}
+	if !severityOK {
+		v.Errors = append(v.Errors, fmt.Sprintf("severity %q is not one of %s", def.Severity, strings.Join(alertSeverities, ", ")))
+	}
+	if _, ok := compareThreshold(0, def.ThresholdConfig); !ok {
+		v.Errors = append(v.Errors, fmt.Sprintf("operator %q is not supported", def.ThresholdConfig.Operator))
+	}
+	if d, err := parseHumanDuration(def.EvalConfig.RollingWindow.EvalStart); err != nil || d <= 0 {
+		v.Errors = append(v.Errors, fmt.Sprintf("evalStart %q is not a valid duration such as '5m' or '1h'", def.EvalConfig.RollingWindow.EvalStart))
+	}
+	if def.EvalConfig.RollingWindow.EvalFrequency <= 0 {
+		v.Errors = append(v.Errors, "evalFrequency must be a positive number of minutes")
+	}
+	if len(def.Targets) == 0 {
+		v.Warnings = append(v.Warnings, "no targets, the alert will not notify anyone")
+	}
+	if strings.TrimSpace(def.Query) == "" {
+		v.Errors = append(v.Errors, "query is required")
+	}
+	if len(def.Datasets) == 0 {
+		v.Errors = append(v.Errors, "at least one dataset is required")
+	}
+
+	fields := map[string]bool{}
+	for _, dataset := range def.Datasets {
+		schema, err := getParseableSchema(ctx, dataset)
+		if err != nil {
+			v.Errors = append(v.Errors, fmt.Sprintf("dataset %q: failed to get schema: %s", dataset, err))
+			continue
+		}
+		for _, name := range schemaFieldNames(schema) {
+			fields[name] = true
+		}
+	}
+	if def.Query != "" && len(def.Datasets) > 0 {
+		tables, columns := sqlReferences(def.Query)
+		if len(tables) == 0 {
+			v.Errors = append(v.Errors, "query has no FROM clause")
+		}
+		for _, table := range tables {
+			found := false
+			for _, dataset := range def.Datasets {
+				found = found || table == dataset
+			}
+			if !found {
+				v.Errors = append(v.Errors, fmt.Sprintf("query reads from %q which is not in datasets", table))
+			}
+		}
+		if len(fields) > 0 {
+			for _, column := range columns {
+				if !fields[column] {
+					v.Warnings = append(v.Warnings, fmt.Sprintf("query references %q which is not a field in the schema of the datasets", column))
+				}
+			}
+		}
+	}
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// dryRunAlert evaluates the alert condition at every evaluation time in [start, end].
+func dryRunAlert(ctx context.Context, def alertRequest, start time.Time, end time.Time) *alertDryRun {
+	window, err := parseHumanDuration(def.EvalConfig.RollingWindow.EvalStart)
+	if err != nil || window <= 0 {
+		window = defaultAlertEvalWindow
+	}
+	step := time.Duration(def.EvalConfig.RollingWindow.EvalFrequency) * time.Minute
+	if step <= 0 {
+		step = time.Minute
+	}
+	if n := end.Sub(start) / step; n > maxDryRunEvaluations {
+		step = (end.Sub(start) + maxDryRunEvaluations - 1) / maxDryRunEvaluations
+	}
+
+	var times []time.Time
+	for t := start.Add(window); !t.After(end); t = t.Add(step) {
+		times = append(times, t)
+	}
+	// The values of the evaluations are returned, so they must not expose masked fields: the
+	// query may not compute values from them and the rows are redacted before taking a value
+	fieldTypes, err := redactionFieldTypes(ctx, def.Datasets[0])
+	if err == nil {
+		err = checkMaskedReferences(ctx, def.Datasets[0], def.Query, fieldTypes)
+	}
+	if err != nil {
+		return &alertDryRun{
+			Start:             start.UTC().Format(time.RFC3339),
+			End:               end.UTC().Format(time.RFC3339),
+			Step:              step.String(),
+			Evaluations:       len(times),
+			Firings:           []alertDryRunFiring{},
+			FailedEvaluations: len(times),
+			FirstError:        err.Error(),
+		}
+	}
+	type evaluation struct {
+		value float64
+		ok    bool
+		err   error
+	}
+	results := make([]evaluation, len(times))
+	sem := make(chan struct{}, dryRunConcurrency)
+	var wg sync.WaitGroup
+	for i, t := range times {
+		wg.Add(1)
+		go func(i int, t time.Time) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rows, err := doParseableQuery(ctx, def.Query, def.Datasets[0],
+				t.Add(-window).UTC().Format(time.RFC3339), t.UTC().Format(time.RFC3339))
+			if err != nil {
+				results[i] = evaluation{err: err}
+				return
+			}
+			if ResultRedactor != nil {
+				ResultRedactor.Rows(def.Datasets[0], rows, fieldTypes)
+			}
+			value, ok := firstNumericValue(rows)
+			if !ok && len(rows) == 0 {
+				// Aggregates over no rows may return nothing, which counts as zero
+				value, ok = 0, true
+			}
+			results[i] = evaluation{value: value, ok: ok}
+		}(i, t)
+	}
+	wg.Wait()
+
+	dryRun := &alertDryRun{
+		Start:       start.UTC().Format(time.RFC3339),
+		End:         end.UTC().Format(time.RFC3339),
+		Step:        step.String(),
+		Evaluations: len(times),
+		Firings:     []alertDryRunFiring{},
+	}
+	wasMet := false
+	for i, r := range results {
+		if r.err != nil || !r.ok {
+			dryRun.FailedEvaluations++
+			if dryRun.FirstError == "" {
+				if r.err != nil {
+					dryRun.FirstError = r.err.Error()
+				} else {
+					dryRun.FirstError = "query did not return a numeric value"
+				}
+			}
+			continue
+		}
+		value := r.value
+		if dryRun.MinValue == nil || value < *dryRun.MinValue {
+			dryRun.MinValue = &value
+		}
+		if dryRun.MaxValue == nil || value > *dryRun.MaxValue {
+			dryRun.MaxValue = &value
+		}
+		met, _ := compareThreshold(value, def.ThresholdConfig)
+		if met {
+			dryRun.ConditionMet++
+			if !wasMet {
+				dryRun.TimesFired++
+				if len(dryRun.Firings) < maxDryRunFirings {
+					dryRun.Firings = append(dryRun.Firings, alertDryRunFiring{At: times[i].UTC().Format(time.RFC3339), Value: value})
+				}
+			}
+		}
+		wasMet = met
+	}
+	return dryRun
+}
+
+// dryRunWindow parses the dry run window arguments, defaulting to the last 24 hours.
+func dryRunWindow(req mcp.CallToolRequest) (time.Time, time.Time, error) {
+	end := time.Now().UTC()
+	if s := mcp.ParseString(req, "dryRunEnd", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid dryRunEnd %q, expected ISO 8601", s)
+		}
+		end = t
+	}
+	start := end.Add(-24 * time.Hour)
+	if s := mcp.ParseString(req, "dryRunStart", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid dryRunStart %q, expected ISO 8601", s)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("dryRunStart must be before dryRunEnd")
+	}
+	return start, end, nil
+}
+
+// handleAlertWrite validates def, runs a dry run if requested and otherwise sends it with
+// the given method and path. It is shared by create_alert and update_alert.
+func handleAlertWrite(ctx context.Context, req mcp.CallToolRequest, tool string, method string, path string, def alertRequest) (*mcp.CallToolResult, error) {
+	validation := validateAlertDefinition(ctx, def)
+
+	if mcp.ParseBoolean(req, "dryRun", false) {
+		result := map[string]interface{}{
+			"validation": validation,
+			"alert":      def,
+		}
+		if validation.Valid {
+			start, end, err := dryRunWindow(req)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			slog.Debug("dry running alert", "tool", tool, "start", start, "end", end)
+			result["dryRun"] = dryRunAlert(ctx, def, start, end)
+		}
+		return mcp.NewToolResultJSON(result)
+	}
+
+	if !validation.Valid {
+		slog.Warn("invalid alert definition", "tool", tool, "errors", validation.Errors)
+		return mcp.NewToolResultError("invalid alert definition: " + strings.Join(validation.Errors, "; ")), nil
+	}
+	if !WritesEnabled {
+		return writesDisabled(tool), nil
+	}
+
+	var saved Alert
+	if err := doParseableRequest(ctx, method, path, def, &saved); err != nil {
+		slog.Error("failed to get response", "error", err, "tool", tool)
+		return mcp.NewToolResultError("failed to save alert: " + err.Error()), nil
+	}
+	return mcp.NewToolResultJSON(map[string]interface{}{
+		"alert":    saved,
+		"warnings": validation.Warnings,
+	})
+}
+
+func RegisterCreateAlertTool(mcpServer *server.MCPServer) {
+	options := append([]mcp.ToolOption{
+		mcp.WithDescription(`Create a threshold alert in Parseable.
+The query is validated against the schemas of the datasets first: the FROM tables must be in datasets and fields that are not in the schema are reported as warnings.
+Use dryRun=true to evaluate the condition over a historical window (default: the last 24 hours) without saving the alert; this reports how many times it would have fired.
+Saving requires writes to be enabled on the server. Calls POST /api/v1/alerts.
+
+title, severity, query, datasets, operator and threshold are required.
+
+With dryRun, returns 'validation' (valid, errors, warnings), 'alert' (the definition) and 'dryRun' with:
+- evaluations: number of evaluation times in the window, every evalFrequency minutes (widened to at most 288 evaluations)
+- conditionMet: evaluations where the condition held
+- timesFired: number of times the alert would have fired (transitions from not met to met)
+- firings: time and value of each firing
+- minValue, maxValue: range of the query result
+- failedEvaluations, firstError: evaluations where the query failed
+
+Without dryRun, returns the saved 'alert' and any validation 'warnings'.
+`),
+		mcp.WithDestructiveHintAnnotation(false),
+	}, alertDefinitionOptions()...)
+	mcpServer.AddTool(mcp.NewTool("create_alert", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		def := alertRequest{
+			AlertType: "threshold",
+			EvalConfig: EvalConfig{RollingWindow: RollingWindow{
+				EvalStart:     "5m",
+				EvalEnd:       "now",
+				EvalFrequency: 1,
+			}},
+			Targets: []string{},
+		}
+		if _, ok := req.GetArguments()["threshold"].(float64); !ok {
+			slog.Warn("called with missing parameter", "parameter", "threshold", "tool", "create_alert")
+			return mcp.NewToolResultError("missing required field: threshold"), nil
+		}
+		applyAlertArguments(req, &def)
+		return handleAlertWrite(ctx, req, "create_alert", "POST", parseableAlertsPath, def)
+	})
+}
+
+func RegisterUpdateAlertTool(mcpServer *server.MCPServer) {
+	options := append([]mcp.ToolOption{
+		mcp.WithDescription(`Update an existing Parseable alert.
+Only the given fields are changed; the rest is taken from the current definition (see get_alert).
+The result is validated like create_alert, and dryRun=true evaluates the updated definition over a historical window without saving it.
+Saving requires writes to be enabled on the server. Calls PUT /api/v1/alerts/<alertId>.
+
+Returns the same structure as create_alert.
+`),
+		mcp.WithString("alertId", mcp.Required(), mcp.Description("Id of the alert to update, as returned by list_alerts")),
+	}, alertDefinitionOptions()...)
+	mcpServer.AddTool(mcp.NewTool("update_alert", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alertID := mcp.ParseString(req, "alertId", "")
+		if alertID == "" {
+			slog.Warn("called with missing parameter", "parameter", "alertId", "tool", "update_alert")
+			return mcp.NewToolResultError("missing required field: alertId"), nil
+		}
+
+		current, err := getParseableAlert(ctx, alertID)
+		if err != nil {
+			slog.Error("failed to get response", "alertId", alertID, "error", err, "tool", "update_alert")
+			return mcp.NewToolResultError("failed to get alert: " + err.Error()), nil
+		}
+		def := alertRequest{
+			Title:              current.Title,
+			Severity:           strings.ToLower(current.Severity),
+			Query:              current.Query,
+			AlertType:          current.AlertType,
+			Datasets:           current.Datasets,
+			ThresholdConfig:    current.ThresholdConfig,
+			EvalConfig:         current.EvalConfig,
+			NotificationConfig: current.NotificationConfig,
+			Targets:            current.Targets,
+			Tags:               current.Tags,
+		}
+		applyAlertArguments(req, &def)
+		return handleAlertWrite(ctx, req, "update_alert", "PUT", parseableAlertsPath+"/"+url.PathEscape(alertID), def)
+	})
+}
+
+func RegisterDeleteAlertTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_alert",
+		mcp.WithDescription(`Delete a Parseable alert.
+Requires writes to be enabled on the server. Calls DELETE /api/v1/alerts/<alertId>.
+
+Returns a JSON object with 'deleted' (the alert id) and 'title' of the deleted alert.
+`),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithString("alertId", mcp.Required(), mcp.Description("Id of the alert to delete, as returned by list_alerts")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alertID := mcp.ParseString(req, "alertId", "")
+		if alertID == "" {
+			slog.Warn("called with missing parameter", "parameter", "alertId", "tool", "delete_alert")
+			return mcp.NewToolResultError("missing required field: alertId"), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("delete_alert"), nil
+		}
+
+		alert, err := getParseableAlert(ctx, alertID)
+		if err != nil {
+			slog.Error("failed to get response", "alertId", alertID, "error", err, "tool", "delete_alert")
+			return mcp.NewToolResultError("failed to get alert: " + err.Error()), nil
+		}
+		if err := doParseableRequest(ctx, "DELETE", parseableAlertsPath+"/"+url.PathEscape(alertID), nil, nil); err != nil {
+			slog.Error("failed to get response", "alertId", alertID, "error", err, "tool", "delete_alert")
+			return mcp.NewToolResultError("failed to delete alert: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"deleted": alertID,
+			"title":   alert.Title,
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultAnomalyThreshold = 3.0
+	defaultAnomalyWindow    = 24
+	defaultAnomalySeasons   = 3
+	maxAnomalySeasons       = 8
+	defaultAnomalies        = 50
+	maxAnomalies            = 500
+)
+
+var anomalySeasonalities = map[string]time.Duration{"day": 24 * time.Hour, "week": 7 * 24 * time.Hour}
+
+// anomaly is a flagged bucket as returned by detect_anomalies.
+type anomaly struct {
+	Time      string             `json:"time"`
+	Value     float64            `json:"value"`
+	Direction string             `json:"direction"`
+	Score     float64            `json:"score"`
+	Methods   []string           `json:"methods"`
+	Scores    map[string]float64 `json:"scores"`
+	Expected  map[string]float64 `json:"expected"`
+}
+
+// flagAnomalies returns the buckets with a score of at least threshold for one of the methods,
+// leaving out the last bucket if it is partial.
+func flagAnomalies(buckets []anomalyBucket, methods []string, threshold float64, partialLast bool) []anomaly {
+	anomalies := []anomaly{}
+	for i, b := range buckets {
+		if partialLast && i == len(buckets)-1 {
+			break
+		}
+		a := anomaly{Time: b.Time.UTC().Format(time.RFC3339), Value: roundTo(b.Value, 3), Scores: map[string]float64{}, Expected: map[string]float64{}}
+		for _, method := range methods {
+			score, ok := b.Scores[method]
+			if !ok {
+				continue
+			}
+			a.Scores[method] = roundTo(score, 2)
+			a.Expected[method] = roundTo(b.Expected[method], 3)
+			if math.Abs(score) >= threshold {
+				a.Methods = append(a.Methods, method)
+				if math.Abs(score) > math.Abs(a.Score) {
+					a.Score = roundTo(score, 2)
+				}
+			}
+		}
+		if len(a.Methods) == 0 {
+			continue
+		}
+		a.Direction = "spike"
+		if a.Score < 0 {
+			a.Direction = "drop"
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies
+}
+
+func RegisterDetectAnomaliesTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Detect anomalies in the volume (or another aggregate) of a stream over time, computed on the server.
+Buckets the stream by time and scores every bucket with statistical detectors:
+- zscore: distance from the mean of the preceding 'window' buckets, in standard deviations
+- mad: distance from the median of the analyzed range, in robust standard deviations (median absolute deviation)
+- seasonal: distance from the median of the same time of day (or week) in the preceding 'seasons' periods
+A bucket is flagged if one of the scores is at least 'threshold' in absolute value. Counts of empty buckets are zero.
+
+Returns a JSON object with:
+- anomalies: flagged buckets in time order with value, direction (spike or drop), the highest score, the methods that
+  flagged them, and per method the score and expected value; at most 'limit', the highest scores first if there are more
+- baseline: mean, stddev, median, mad, min and max of the analyzed buckets
+- buckets: number of buckets analyzed; step; partialLast: true if the last bucket was not complete and is not flagged
+- series: the [time, value] pairs, if includeSeries is set
+- query: the generated SQL
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the stream")),
+		mcp.WithString("aggregate", mcp.Description("Optional SQL aggregate to analyze, e.g. 'AVG(duration_ms)' (default: COUNT(*))")),
+		mcp.WithString("filter", mcp.Description(`Optional SQL condition on the rows, e.g. status >= 500`)),
+		mcp.WithString("step", mcp.Description("Optional bucket size, e.g. '5m' or '1h' (default: about 100 buckets over the time range)")),
+		mcp.WithArray("methods", mcp.WithStringEnumItems(anomalyMethods), mcp.Description("Optional detectors to apply (default: all)")),
+		mcp.WithNumber("threshold", mcp.Description(fmt.Sprintf("Optional score from which a bucket is flagged (default: %g)", defaultAnomalyThreshold))),
+		mcp.WithNumber("window", mcp.Description(fmt.Sprintf("Optional number of preceding buckets for the zscore baseline (default: %d)", defaultAnomalyWindow))),
+		mcp.WithString("seasonality", mcp.Enum("day", "week"), mcp.Description("Optional period of the seasonal baseline (default: day)")),
+		mcp.WithNumber("seasons", mcp.Description(fmt.Sprintf("Optional number of preceding periods for the seasonal baseline (default: %d, at most %d)", defaultAnomalySeasons, maxAnomalySeasons))),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional maximum number of anomalies (default: %d, at most %d)", defaultAnomalies, maxAnomalies))),
+		mcp.WithBoolean("includeSeries", mcp.Description("Optional: also return the bucketed values (default: false)")),
+	}
+	options = append(options, timeRangeOptions("24h")...)
+	mcpServer.AddTool(mcp.NewTool("detect_anomalies", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "tool", "detect_anomalies")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		aggregate := mcp.ParseString(req, "aggregate", "")
+		methods := req.GetStringSlice("methods", anomalyMethods)
+		for _, method := range methods {
+			if !slices.Contains(anomalyMethods, method) {
+				return mcp.NewToolResultError("unsupported method: " + method + " (supported: " + strings.Join(anomalyMethods, ", ") + ")"), nil
+			}
+		}
+		threshold := mcp.ParseFloat64(req, "threshold", defaultAnomalyThreshold)
+		window := mcp.ParseInt(req, "window", defaultAnomalyWindow)
+		seasons := min(mcp.ParseInt(req, "seasons", defaultAnomalySeasons), maxAnomalySeasons)
+		limit := min(mcp.ParseInt(req, "limit", defaultAnomalies), maxAnomalies)
+		if threshold <= 0 || window <= 0 || seasons <= 0 || limit <= 0 {
+			return mcp.NewToolResultError("threshold, window, seasons and limit must be positive"), nil
+		}
+		seasonality := mcp.ParseString(req, "seasonality", "day")
+		period, ok := anomalySeasonalities[seasonality]
+		if !ok {
+			return mcp.NewToolResultError("unsupported seasonality: " + seasonality + " (supported: day, week)"), nil
+		}
+		startTime, endTime, err := timeRangeArguments(req, 24*time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		start, end := parseRange(startTime, endTime)
+		step, err := metricStep(mcp.ParseString(req, "step", ""), start, end)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		var notes []string
+		if slices.Contains(methods, "seasonal") && period%step != 0 {
+			if req.GetStringSlice("methods", nil) != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("the step %s does not divide a %s, which the seasonal method needs", step, seasonality)), nil
+			}
+			methods = slices.DeleteFunc(slices.Clone(methods), func(m string) bool { return m == "seasonal" })
+			notes = append(notes, fmt.Sprintf("the seasonal method was skipped because the step %s does not divide a %s", step, seasonality))
+		}
+
+		// Query the buckets before the range that the baselines need, aligned to whole buckets
+		start = time.Unix(0, start.UnixNano()/int64(step)*int64(step)).UTC()
+		queryStart := start
+		if slices.Contains(methods, "zscore") {
+			queryStart = start.Add(-time.Duration(window) * step)
+		}
+		if slices.Contains(methods, "seasonal") {
+			if seasonStart := start.Add(-time.Duration(seasons) * period); seasonStart.Before(queryStart) {
+				queryStart = seasonStart
+			}
+		}
+		count := aggregate == ""
+		if count {
+			aggregate = "COUNT(*)"
+		}
+		query := fmt.Sprintf("SELECT date_bin(INTERVAL '%d seconds', p_timestamp, TIMESTAMP '1970-01-01T00:00:00') AS bucket, %s AS value FROM %s",
+			int64(step.Seconds()), aggregate, quoteIdent(streamName))
+		if filter := mcp.ParseString(req, "filter", ""); filter != "" {
+			query += " WHERE " + filter
+		}
+		total := int(end.Sub(queryStart)/step) + 1
+		query += fmt.Sprintf(" GROUP BY bucket ORDER BY bucket LIMIT %d", total+1)
+		if err := checkMaskedReferences(ctx, streamName, query, nil); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		recordQueryLookback(streamName, queryStart.Format(time.RFC3339))
+		rows, err := doParseableQuery(ctx, query, streamName, queryStart.Format(time.RFC3339), endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "detect_anomalies", "query", query)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+
+		// Values on the grid of buckets from queryStart; without data a count is zero and
+		// another aggregate has no value
+		values := make([]float64, total)
+		present := make([]bool, total)
+		if count {
+			for i := range present {
+				present[i] = !queryStart.Add(time.Duration(i) * step).After(end)
+			}
+		}
+		for _, row := range rows {
+			bucket, ok := rowBucket(row)
+			value, valueOK := rowValue(row)
+			i := int(bucket.Sub(queryStart) / step)
+			if ok && valueOK && i >= 0 && i < total {
+				values[i], present[i] = value, true
+			}
+		}
+		first := int(start.Sub(queryStart) / step)
+		last := total
+		for last > first && queryStart.Add(time.Duration(last-1)*step).After(end) {
+			last--
+		}
+		values, present = values[:last], present[:last]
+		buckets := make([]anomalyBucket, last-first)
+		for i := range buckets {
+			buckets[i] = anomalyBucket{Time: start.Add(time.Duration(i) * step), Value: values[first+i], Expected: map[string]float64{}, Scores: map[string]float64{}}
+		}
+		for _, method := range methods {
+			switch method {
+			case "zscore":
+				rollingZScores(values, present, first, window, buckets)
+			case "mad":
+				madScores(values, present, first, buckets)
+			case "seasonal":
+				seasonalScores(values, present, first, int(period/step), seasons, buckets)
+			}
+		}
+		partialLast := len(buckets) > 0 && buckets[len(buckets)-1].Time.Add(step).After(end)
+		anomalies := flagAnomalies(buckets, methods, threshold, partialLast)
+		truncated := len(anomalies) > limit
+		if truncated {
+			sort.SliceStable(anomalies, func(i, j int) bool { return math.Abs(anomalies[i].Score) > math.Abs(anomalies[j].Score) })
+			anomalies = anomalies[:limit]
+			sort.SliceStable(anomalies, func(i, j int) bool { return anomalies[i].Time < anomalies[j].Time })
+		}
+
+		analyzed := presentValues(values[first:], present[first:])
+		baseline := map[string]interface{}{}
+		if len(analyzed) > 0 {
+			mean, stddev := meanStddev(analyzed)
+			m, mad := medianAbsDeviation(analyzed)
+			baseline = map[string]interface{}{
+				"mean":   roundTo(mean, 3),
+				"stddev": roundTo(stddev, 3),
+				"median": roundTo(m, 3),
+				"mad":    roundTo(mad, 3),
+				"min":    slices.Min(analyzed),
+				"max":    slices.Max(analyzed),
+			}
+		}
+		result := map[string]interface{}{
+			"anomalies":   anomalies,
+			"count":       len(anomalies),
+			"truncated":   truncated,
+			"baseline":    baseline,
+			"buckets":     len(analyzed),
+			"step":        step.String(),
+			"methods":     methods,
+			"threshold":   threshold,
+			"partialLast": partialLast,
+			"query":       query,
+			"startTime":   start.Format(time.RFC3339),
+			"endTime":     endTime,
+		}
+		if len(notes) > 0 {
+			result["notes"] = notes
+		}
+		if mcp.ParseBoolean(req, "includeSeries", false) {
+			series := make([]metricPoint, 0, len(buckets))
+			for i, b := range buckets {
+				if present[first+i] {
+					series = append(series, metricPoint{Time: b.Time, Value: b.Value})
+				}
+			}
+			result["series"] = series
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -0,0 +1,442 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/audit"
+)
+
+const (
+	parseableClusterInfoPath    = "/api/v1/cluster/info"
+	parseableClusterMetricsPath = "/api/v1/cluster/metrics"
+	// Timeout of a liveness or readiness probe of a single node
+	nodeProbeTimeout = 5 * time.Second
+	// Number of nodes probed at the same time
+	nodeProbeConcurrency = 8
+	// Staging files on a node above which the health report flags slow uploads to object storage
+	stagingFilesWarning = 100
+	// Ratio of a node's value to the median of its node type above which the report flags an outlier
+	nodeOutlierRatio = 2.0
+)
+
+// ClusterNode is a node of a distributed Parseable deployment as reported by /api/v1/cluster/info.
+type ClusterNode struct {
+	DomainName  string `json:"domain_name"`
+	NodeType    string `json:"node_type,omitempty"`
+	Reachable   bool   `json:"reachable"`
+	Status      string `json:"status,omitempty"`
+	Error       string `json:"error,omitempty"`
+	StagingPath string `json:"staging_path,omitempty"`
+	StoragePath string `json:"storage_path,omitempty"`
+	// Live and Ready are the results of probing the node's liveness and readiness endpoints.
+	Live       *bool  `json:"live,omitempty"`
+	Ready      *bool  `json:"ready,omitempty"`
+	ProbeError string `json:"probeError,omitempty"`
+}
+
+// requireClusterMode returns an error result unless /api/v1/about reports a distributed deployment.
+func requireClusterMode(ctx context.Context, tool string) *mcp.CallToolResult {
+	about, err := getParseableAbout(ctx)
+	if err != nil {
+		slog.Error("failed to get response", "error", err, "tool", tool)
+		return mcp.NewToolResultError("failed to get about: " + err.Error())
+	}
+	mode, _ := about["mode"].(string)
+	lower := strings.ToLower(mode)
+	if !strings.Contains(lower, "cluster") && !strings.Contains(lower, "distributed") {
+		return mcp.NewToolResultError(fmt.Sprintf("%s needs a distributed Parseable deployment, this one runs in mode %q. "+
+			"Use get_data_stream_stats and get_about for a standalone server.", tool, mode))
+	}
+	return nil
+}
+
+func listParseableClusterNodes(ctx context.Context) ([]ClusterNode, error) {
+	var nodes []ClusterNode
+	err := doParseableRequest(ctx, "GET", parseableClusterInfoPath, nil, &nodes)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].NodeType != nodes[j].NodeType {
+			return nodes[i].NodeType < nodes[j].NodeType
+		}
+		return nodes[i].DomainName < nodes[j].DomainName
+	})
+	return nodes, err
+}
+
+func getParseableClusterMetrics(ctx context.Context) ([]map[string]interface{}, error) {
+	var metrics []map[string]interface{}
+	err := doParseableRequest(ctx, "GET", parseableClusterMetricsPath, nil, &metrics)
+	return metrics, err
+}
+
+// probeNode calls an unauthenticated health endpoint of a node directly and reports whether
+// it answered with a 2xx status.
+func probeNode(ctx context.Context, domain string, path string) (bool, error) {
+	url := strings.TrimSuffix(domain, "/") + path
+	audit.NoteEndpoint(ctx, "GET", url)
+	ctx, cancel := context.WithTimeout(ctx, nodeProbeTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := HTTPClient.Do(httpReq)
+	if err != nil {
+		return false, err
+	}
+	if err := resp.Body.Close(); err != nil {
+		slog.Error("failed to close response body", "error", err)
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode <= 299, nil
+}
+
+// probeNodes sets the liveness and readiness of every node.
+func probeNodes(ctx context.Context, nodes []ClusterNode) {
+	sem := make(chan struct{}, nodeProbeConcurrency)
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			node := &nodes[i]
+			live, err := probeNode(ctx, node.DomainName, "/api/v1/liveness")
+			node.Live = &live
+			if err != nil {
+				node.ProbeError = err.Error()
+				return
+			}
+			ready, err := probeNode(ctx, node.DomainName, "/api/v1/readiness")
+			node.Ready = &ready
+			if err != nil {
+				node.ProbeError = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func RegisterListClusterNodesTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_cluster_nodes",
+		mcp.WithDescription(`List the nodes (ingestors, queriers, indexers) of a distributed Parseable deployment with their liveness and readiness.
+Only works when get_about reports a cluster mode. Calls /api/v1/cluster/info and the /api/v1/liveness and /api/v1/readiness endpoints of each node.
+
+Returns a JSON object with a 'nodes' array, 'count' and 'byType' (number of nodes per node type). Each node includes:
+- domain_name: URL of the node
+- node_type: e.g. "ingestor" or "querier"
+- reachable, status, error: what the querier reports about the node
+- staging_path, storage_path: where the node stages and stores data
+- live, ready: whether the node answered its liveness and readiness endpoints (omitted when probe is false)
+- probeError: why the node could not be probed
+`),
+		mcp.WithString("nodeType", mcp.Description("Optional: only list nodes of this type, e.g. 'ingestor' or 'querier'")),
+		mcp.WithBoolean("probe", mcp.Description("Optional: probe liveness and readiness of each node (default: true)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		nodeType := mcp.ParseString(req, "nodeType", "")
+		probe := mcp.ParseBoolean(req, "probe", true)
+		if result := requireClusterMode(ctx, "list_cluster_nodes"); result != nil {
+			return result, nil
+		}
+
+		nodes, err := listParseableClusterNodes(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_cluster_nodes")
+			return mcp.NewToolResultError("failed to get cluster info: " + err.Error()), nil
+		}
+		filtered := make([]ClusterNode, 0, len(nodes))
+		byType := map[string]int{}
+		for _, node := range nodes {
+			if nodeType == "" || strings.EqualFold(node.NodeType, nodeType) {
+				filtered = append(filtered, node)
+				byType[node.NodeType]++
+			}
+		}
+		if probe {
+			probeNodes(ctx, filtered)
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"nodes":  filtered,
+			"count":  len(filtered),
+			"byType": byType,
+		})
+	})
+}
+
+// nodeMetrics are the per node metrics the health report looks at.
+type nodeMetrics struct {
+	Address             string   `json:"address"`
+	NodeType            string   `json:"nodeType,omitempty"`
+	EventsIngested      *float64 `json:"eventsIngested,omitempty"`
+	EventsIngestedBytes *float64 `json:"eventsIngestedBytes,omitempty"`
+	StagingFiles        *float64 `json:"stagingFiles,omitempty"`
+	StagingBytes        *float64 `json:"stagingBytes,omitempty"`
+	ResidentMemoryBytes *float64 `json:"residentMemoryBytes,omitempty"`
+	LifetimeEventsBytes *float64 `json:"lifetimeEventsIngestedBytes,omitempty"`
+	MetricsTime         string   `json:"metricsTime,omitempty"`
+}
+
+func optionalStat(m map[string]interface{}, keys ...string) *float64 {
+	if v, ok := statNumber(m, keys...); ok {
+		return &v
+	}
+	return nil
+}
+
+func extractNodeMetrics(m map[string]interface{}) nodeMetrics {
+	n := nodeMetrics{
+		EventsIngested:      optionalStat(m, "parseable_events_ingested"),
+		EventsIngestedBytes: optionalStat(m, "parseable_events_ingested_size"),
+		StagingFiles:        optionalStat(m, "parseable_staging_files"),
+		StagingBytes:        optionalStat(m, "parseable_storage_size", "staging"),
+		ResidentMemoryBytes: optionalStat(m, "process_resident_memory_bytes"),
+		LifetimeEventsBytes: optionalStat(m, "parseable_lifetime_events_ingested_size"),
+	}
+	n.Address, _ = m["address"].(string)
+	n.NodeType, _ = m["node_type"].(string)
+	n.MetricsTime, _ = m["event_time"].(string)
+	return n
+}
+
+func RegisterGetClusterMetricsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_cluster_metrics",
+		mcp.WithDescription(`Get per node metrics of a distributed Parseable deployment.
+Only works when get_about reports a cluster mode. Calls /api/v1/cluster/metrics.
+
+Returns a JSON object with a 'nodes' array and 'count'. Each node includes:
+- address: URL of the node
+- nodeType: e.g. "ingestor" or "querier"
+- eventsIngested, eventsIngestedBytes: events and bytes ingested by the node since it started
+- lifetimeEventsIngestedBytes: bytes ingested by the node over its lifetime
+- stagingFiles, stagingBytes: data staged on the node's disk and not yet uploaded to object storage
+- residentMemoryBytes: memory used by the node process
+- metricsTime: when the metrics were collected
+With raw set, 'raw' holds the metrics exactly as returned by Parseable instead.
+`),
+		mcp.WithString("node", mcp.Description("Optional: only return metrics of nodes whose address contains this text")),
+		mcp.WithBoolean("raw", mcp.Description("Optional: return the metrics as returned by Parseable (default: false)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		nodeFilter := mcp.ParseString(req, "node", "")
+		if result := requireClusterMode(ctx, "get_cluster_metrics"); result != nil {
+			return result, nil
+		}
+
+		metrics, err := getParseableClusterMetrics(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_cluster_metrics")
+			return mcp.NewToolResultError("failed to get cluster metrics: " + err.Error()), nil
+		}
+		var raw []map[string]interface{}
+		nodes := []nodeMetrics{}
+		for _, m := range metrics {
+			n := extractNodeMetrics(m)
+			if nodeFilter != "" && !strings.Contains(n.Address, nodeFilter) {
+				continue
+			}
+			raw = append(raw, m)
+			nodes = append(nodes, n)
+		}
+		if mcp.ParseBoolean(req, "raw", false) {
+			return mcp.NewToolResultJSON(map[string]interface{}{
+				"raw":   raw,
+				"count": len(raw),
+			})
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"nodes": nodes,
+			"count": len(nodes),
+		})
+	})
+}
+
+// healthFinding is a problem found by the cluster health report.
+type healthFinding struct {
+	Severity string `json:"severity"`
+	Node     string `json:"node,omitempty"`
+	Message  string `json:"message"`
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// clusterFindings checks node reachability and probes, staging backlogs and outliers among
+// nodes of the same type.
+func clusterFindings(nodes []ClusterNode, metrics []nodeMetrics) []healthFinding {
+	findings := []healthFinding{}
+	for _, node := range nodes {
+		switch {
+		case !node.Reachable:
+			findings = append(findings, healthFinding{"critical", node.DomainName, fmt.Sprintf("%s is not reachable from the querier: %s", node.NodeType, node.Error)})
+		case node.Live != nil && !*node.Live:
+			message := node.NodeType + " failed its liveness check"
+			if node.ProbeError != "" {
+				message += ": " + node.ProbeError
+			}
+			findings = append(findings, healthFinding{"critical", node.DomainName, message})
+		case node.Ready != nil && !*node.Ready:
+			findings = append(findings, healthFinding{"warning", node.DomainName, node.NodeType + " is live but not ready to serve requests"})
+		}
+	}
+
+	reported := map[string]bool{}
+	byType := map[string][]nodeMetrics{}
+	for _, m := range metrics {
+		reported[strings.TrimSuffix(m.Address, "/")] = true
+		byType[m.NodeType] = append(byType[m.NodeType], m)
+		if m.StagingFiles != nil && *m.StagingFiles > stagingFilesWarning {
+			findings = append(findings, healthFinding{"warning", m.Address, fmt.Sprintf(
+				"%.0f files waiting in staging; uploads to object storage are falling behind, which slows ingestion and delays data becoming queryable", *m.StagingFiles)})
+		}
+	}
+	for _, node := range nodes {
+		if node.Reachable && !reported[strings.TrimSuffix(node.DomainName, "/")] {
+			findings = append(findings, healthFinding{"info", node.DomainName, "no metrics reported for this node"})
+		}
+	}
+
+	outliers := func(nodeType string, group []nodeMetrics, label string, value func(nodeMetrics) *float64, format func(float64) string, high bool) {
+		var values []float64
+		for _, m := range group {
+			if v := value(m); v != nil {
+				values = append(values, *v)
+			}
+		}
+		if len(values) < 2 {
+			return
+		}
+		med := median(values)
+		if med <= 0 {
+			return
+		}
+		for _, m := range group {
+			v := value(m)
+			if v == nil {
+				continue
+			}
+			switch {
+			case high && *v > med*nodeOutlierRatio:
+				findings = append(findings, healthFinding{"warning", m.Address, fmt.Sprintf("%s %s is %.1fx the %s median (%s)", label, format(*v), *v/med, nodeType, format(med))})
+			case !high && *v < med/nodeOutlierRatio:
+				findings = append(findings, healthFinding{"warning", m.Address, fmt.Sprintf("%s %s is %.1fx below the %s median (%s); load may be unevenly balanced", label, format(*v), med/math.Max(*v, 1), nodeType, format(med))})
+			}
+		}
+	}
+	count := func(v float64) string { return fmt.Sprintf("%.0f", v) }
+	for nodeType, group := range byType {
+		outliers(nodeType, group, "memory", func(m nodeMetrics) *float64 { return m.ResidentMemoryBytes }, formatByteSize, true)
+		outliers(nodeType, group, "staging", func(m nodeMetrics) *float64 { return m.StagingBytes }, formatByteSize, true)
+		if strings.EqualFold(nodeType, "ingestor") {
+			outliers(nodeType, group, "events ingested", func(m nodeMetrics) *float64 { return m.EventsIngested }, count, false)
+		}
+	}
+	severityRank := map[string]int{"critical": 0, "warning": 1, "info": 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank[findings[i].Severity] < severityRank[findings[j].Severity]
+	})
+	return findings
+}
+
+func RegisterClusterHealthReportTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"cluster_health_report",
+		mcp.WithDescription(`Summarize the health of a distributed Parseable deployment, e.g. to diagnose why ingestion is slow.
+Combines the node list, liveness and readiness probes of every node and per node metrics.
+Only works when get_about reports a cluster mode.
+
+Checks for:
+- nodes the querier cannot reach, that fail liveness, or are not ready
+- ingestors with a staging backlog (files not yet uploaded to object storage)
+- nodes whose memory or staging size is far above, or whose ingested events are far below, other nodes of the same type
+- nodes without metrics
+
+Returns a JSON object with:
+- status: "healthy", "degraded" (warnings only) or "unhealthy" (critical findings)
+- nodes: per node type counts of total, reachable, live and ready nodes
+- findings: array of severity ("critical", "warning", "info"), node and message, most severe first
+- metrics: the per node metrics used, as returned by get_cluster_metrics
+- metricsError: why metrics could not be read, if they could not
+`),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if result := requireClusterMode(ctx, "cluster_health_report"); result != nil {
+			return result, nil
+		}
+
+		nodes, err := listParseableClusterNodes(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "cluster_health_report")
+			return mcp.NewToolResultError("failed to get cluster info: " + err.Error()), nil
+		}
+		probeNodes(ctx, nodes)
+
+		result := map[string]interface{}{}
+		var metrics []nodeMetrics
+		if raw, err := getParseableClusterMetrics(ctx); err != nil {
+			slog.Warn("failed to get cluster metrics", "error", err, "tool", "cluster_health_report")
+			result["metricsError"] = err.Error()
+		} else {
+			for _, m := range raw {
+				metrics = append(metrics, extractNodeMetrics(m))
+			}
+		}
+
+		counts := map[string]map[string]int{}
+		for _, node := range nodes {
+			c, ok := counts[node.NodeType]
+			if !ok {
+				c = map[string]int{}
+				counts[node.NodeType] = c
+			}
+			c["total"]++
+			if node.Reachable {
+				c["reachable"]++
+			}
+			if node.Live != nil && *node.Live {
+				c["live"]++
+			}
+			if node.Ready != nil && *node.Ready {
+				c["ready"]++
+			}
+		}
+
+		findings := clusterFindings(nodes, metrics)
+		status := "healthy"
+		for _, f := range findings {
+			if f.Severity == "critical" {
+				status = "unhealthy"
+				break
+			}
+			if f.Severity == "warning" {
+				status = "degraded"
+			}
+		}
+		result["status"] = status
+		result["nodes"] = counts
+		result["findings"] = findings
+		result["metrics"] = metrics
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -0,0 +1,365 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultCompareGroups = 50
+	maxCompareGroups     = 500
+	// Maximum number of groups read per window
+	maxCompareRows = 10000
+)
+
+// timeWindow is a time range of compare_windows.
+type timeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (w timeWindow) format() map[string]string {
+	return map[string]string{
+		"startTime": w.Start.Format(time.RFC3339),
+		"endTime":   w.End.Format(time.RFC3339),
+		"length":    w.length().String(),
+	}
+}
+
+func (w timeWindow) length() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// additiveAggregate matches COUNT(*), COUNT(<field>) and SUM(<field>), whose values grow in
+// proportion to the length of a window. Others, such as COUNT(DISTINCT <field>), do not.
+var additiveAggregate = regexp.MustCompile(`(?i)^\s*(?:count\s*\(\s*(?:\*|"(?:[^"]|"")+"|[a-z_][a-z0-9_.]*)|sum\s*\(\s*(?:"(?:[^"]|"")+"|[a-z_][a-z0-9_.]*))\s*\)\s*$`)
+
+// groupChange is the value of a group in the two windows of compare_windows.
+type groupChange struct {
+	Group         map[string]interface{} `json:"group,omitempty"`
+	Before        *float64               `json:"before"`
+	After         *float64               `json:"after"`
+	Delta         float64                `json:"delta"`
+	PercentChange *float64               `json:"percentChange"`
+}
+
+// compareWindowArguments reads the two windows: given explicitly, or as the window before and
+// after a pivot time such as a deploy, by default now.
+func compareWindowArguments(req mcp.CallToolRequest) (timeWindow, timeWindow, error) {
+	var before, after timeWindow
+	size, err := parseHumanDuration(mcp.ParseString(req, "window", "1h"))
+	if err != nil || size <= 0 {
+		return before, after, fmt.Errorf("invalid window, expected a duration such as '30m' or '1h'")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	pivot := now.Add(-size)
+	if s := mcp.ParseString(req, "pivot", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return before, after, fmt.Errorf("invalid pivot %q, expected ISO 8601", s)
+		}
+		pivot = t.UTC()
+	}
+	before = timeWindow{pivot.Add(-size), pivot}
+	after = timeWindow{pivot, pivot.Add(size)}
+	if after.End.After(now) && now.After(pivot) {
+		after.End = now
+	}
+	for _, arg := range []struct {
+		name string
+		t    *time.Time
+	}{{"beforeStart", &before.Start}, {"beforeEnd", &before.End}, {"afterStart", &after.Start}, {"afterEnd", &after.End}} {
+		if s := mcp.ParseString(req, arg.name, ""); s != "" {
+			t, ok := parseTimestamp(s)
+			if !ok {
+				return before, after, fmt.Errorf("invalid %s %q, expected ISO 8601", arg.name, s)
+			}
+			*arg.t = t.UTC()
+		}
+	}
+	if !before.Start.Before(before.End) || !after.Start.Before(after.End) {
+		return before, after, fmt.Errorf("each window must start before it ends")
+	}
+	return before, after, nil
+}
+
+// compareValues aligns the values of the groups of two windows by their group keys. Groups in
+// both windows are changes; the others are new or disappeared.
+func compareValues(groupBy []string, beforeRows []map[string]interface{}, afterRows []map[string]interface{}, scale float64) (changes []groupChange, added []groupChange, disappeared []groupChange) {
+	type entry struct {
+		group  map[string]interface{}
+		before *float64
+		after  *float64
+	}
+	entries := map[string]*entry{}
+	var keys []string
+	read := func(rows []map[string]interface{}, after bool) {
+		for _, row := range rows {
+			value, ok := rowValue(row)
+			if !ok {
+				continue
+			}
+			group := make(map[string]interface{}, len(groupBy))
+			for _, field := range groupBy {
+				group[field] = row[field]
+			}
+			key := seriesKey(rowLabels(row, groupBy))
+			if entries[key] == nil {
+				entries[key] = &entry{group: group}
+				keys = append(keys, key)
+			}
+			if after {
+				entries[key].after = &value
+			} else {
+				value *= scale
+				entries[key].before = &value
+			}
+		}
+	}
+	read(beforeRows, false)
+	read(afterRows, true)
+
+	changes, added, disappeared = []groupChange{}, []groupChange{}, []groupChange{}
+	for _, key := range keys {
+		e := entries[key]
+		c := groupChange{Before: e.before, After: e.after}
+		if len(groupBy) > 0 {
+			c.Group = e.group
+		}
+		var before, after float64
+		if e.before != nil {
+			before = roundTo(*e.before, 3)
+			c.Before = &before
+		}
+		if e.after != nil {
+			after = roundTo(*e.after, 3)
+			c.After = &after
+		}
+		c.Delta = roundTo(after-before, 3)
+		if before != 0 {
+			percent := roundTo(100*(after-before)/math.Abs(before), 2)
+			c.PercentChange = &percent
+		}
+		switch {
+		case e.before == nil:
+			added = append(added, c)
+		case e.after == nil:
+			disappeared = append(disappeared, c)
+		default:
+			changes = append(changes, c)
+		}
+	}
+	return changes, added, disappeared
+}
+
+// sortChanges orders changes by the size of their absolute or relative change, largest first.
+// Relative changes from zero come first.
+func sortChanges(changes []groupChange, relative bool) {
+	size := func(c groupChange) float64 {
+		if !relative {
+			return math.Abs(c.Delta)
+		}
+		if c.PercentChange == nil {
+			return math.Inf(1)
+		}
+		return math.Abs(*c.PercentChange)
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return size(changes[i]) > size(changes[j]) })
+}
+
+func RegisterCompareWindowsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool("compare_windows",
+		mcp.WithDescription(fmt.Sprintf(`Compare an aggregate of a stream between two time windows, e.g. before and after a deploy, to answer "what changed?".
+Runs the same query over both windows at once, aligns the results by the groupBy fields and computes the differences.
+The windows are the 'window' before and after 'pivot' (default: the last window against the one before it), or are given
+with beforeStart, beforeEnd, afterStart and afterEnd.
+
+Returns a JSON object with:
+- changes: groups in both windows with before and after values, delta and percentChange, largest changes first
+- new: groups only in the after window; disappeared: groups only in the before window; each at most 'limit' (max %d)
+- totals: sum of the values of all groups in each window with delta and percentChange (meaningful for counts and sums)
+- groups: number of groups per window and of changed, new and disappeared groups
+- before, after: the windows with their length; scale: factor applied to the before values if they were normalized
+- notes: e.g. that the windows differ in length, as when the after window is cut off at now
+- query: the SQL run over both windows; truncated: true if a window had more than %d groups or lists were cut
+`, maxCompareGroups, maxCompareRows)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the stream")),
+		mcp.WithString("aggregate", mcp.Description("Optional SQL aggregate to compare, e.g. 'AVG(duration_ms)' (default: COUNT(*))")),
+		mcp.WithArray("groupBy", mcp.WithStringItems(), mcp.Description("Optional fields to group by, e.g. ['service', 'status']")),
+		mcp.WithString("filter", mcp.Description(`Optional SQL condition on the rows, e.g. status >= 500`)),
+		mcp.WithString("pivot", mcp.Description("Optional ISO 8601 time separating the windows, e.g. a deploy time (default: now minus window)")),
+		mcp.WithString("window", mcp.Description("Optional length of each window around the pivot, e.g. '30m' (default: 1h)")),
+		mcp.WithString("beforeStart", mcp.Description("Optional ISO 8601 start of the before window, overriding the pivot")),
+		mcp.WithString("beforeEnd", mcp.Description("Optional ISO 8601 end of the before window, overriding the pivot")),
+		mcp.WithString("afterStart", mcp.Description("Optional ISO 8601 start of the after window, overriding the pivot")),
+		mcp.WithString("afterEnd", mcp.Description("Optional ISO 8601 end of the after window, overriding the pivot")),
+		mcp.WithBoolean("normalize", mcp.Description("Optional: scale the before values to the length of the after window, for counts and sums over windows of different lengths (default: true for COUNT(*), COUNT(field) and SUM(field) if the lengths differ, otherwise false)")),
+		mcp.WithString("sortBy", mcp.Enum("absolute", "relative"), mcp.Description("Optional order of changes: by absolute delta or by percent change (default: absolute)")),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional maximum number of groups per list (default: %d, at most %d)", defaultCompareGroups, maxCompareGroups))),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "tool", "compare_windows")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		aggregate := mcp.ParseString(req, "aggregate", "COUNT(*)")
+		groupBy := req.GetStringSlice("groupBy", nil)
+		sortBy := mcp.ParseString(req, "sortBy", "absolute")
+		if sortBy != "absolute" && sortBy != "relative" {
+			return mcp.NewToolResultError("unsupported sortBy: " + sortBy + " (supported: absolute, relative)"), nil
+		}
+		limit := mcp.ParseInt(req, "limit", defaultCompareGroups)
+		if limit <= 0 {
+			limit = defaultCompareGroups
+		}
+		limit = min(limit, maxCompareGroups)
+		before, after, err := compareWindowArguments(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		// Counts and sums over windows of different lengths, such as an after window cut off at
+		// now, are only comparable when scaled; other aggregates are compared as they are
+		var notes []string
+		differ := before.length() != after.length()
+		additive := additiveAggregate.MatchString(aggregate)
+		_, normalizeSet := req.GetArguments()["normalize"]
+		normalize := mcp.ParseBoolean(req, "normalize", differ && additive)
+		scale := 1.0
+		if normalize {
+			scale = after.length().Seconds() / before.length().Seconds()
+		}
+		switch {
+		case differ && normalize && !normalizeSet:
+			notes = append(notes, fmt.Sprintf("the windows differ in length (before %s, after %s), so the before values were scaled by %.4g; "+
+				"set normalize to false to compare the raw values", before.length(), after.length(), scale))
+		case differ && !normalize && additive:
+			notes = append(notes, fmt.Sprintf("the windows differ in length (before %s, after %s) and normalize is false, "+
+				"so counts and sums differ by the window lengths alone", before.length(), after.length()))
+		case differ && !normalize:
+			notes = append(notes, fmt.Sprintf("the windows differ in length (before %s, after %s); the values are not scaled", before.length(), after.length()))
+		}
+
+		columns := make([]string, 0, len(groupBy)+1)
+		for _, field := range groupBy {
+			columns = append(columns, quoteIdent(field))
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(append(columns, aggregate+" AS value"), ", "), quoteIdent(streamName))
+		if filter := mcp.ParseString(req, "filter", ""); filter != "" {
+			query += " WHERE " + filter
+		}
+		if len(groupBy) > 0 {
+			query += fmt.Sprintf(" GROUP BY %s ORDER BY value DESC LIMIT %d", strings.Join(columns, ", "), maxCompareRows+1)
+		}
+
+		if err := checkMaskedReferences(ctx, streamName, query, nil); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		// Both windows at once
+		windows := []timeWindow{before, after}
+		rows := make([][]map[string]interface{}, len(windows))
+		errs := make([]error, len(windows))
+		var wg sync.WaitGroup
+		for i, w := range windows {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recordQueryLookback(streamName, w.Start.Format(time.RFC3339))
+				rows[i], errs[i] = doParseableQuery(ctx, query, streamName, w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+			}()
+		}
+		wg.Wait()
+		truncated := false
+		for i, err := range errs {
+			if err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "compare_windows", "query", query)
+				return mcp.NewToolResultError(fmt.Sprintf("query over the %s window failed: %s", []string{"before", "after"}[i], err)), nil
+			}
+			if len(rows[i]) > maxCompareRows {
+				rows[i], truncated = rows[i][:maxCompareRows], true
+			}
+		}
+
+		changes, added, disappeared := compareValues(groupBy, rows[0], rows[1], scale)
+		var totalBefore, totalAfter float64
+		for _, list := range [][]groupChange{changes, added, disappeared} {
+			for _, c := range list {
+				if c.Before != nil {
+					totalBefore += *c.Before
+				}
+				if c.After != nil {
+					totalAfter += *c.After
+				}
+			}
+		}
+		totals := map[string]interface{}{
+			"before": roundTo(totalBefore, 3),
+			"after":  roundTo(totalAfter, 3),
+			"delta":  roundTo(totalAfter-totalBefore, 3),
+		}
+		if totalBefore != 0 {
+			totals["percentChange"] = roundTo(100*(totalAfter-totalBefore)/math.Abs(totalBefore), 2)
+		}
+		groups := map[string]int{
+			"before":      len(changes) + len(disappeared),
+			"after":       len(changes) + len(added),
+			"changed":     len(changes),
+			"new":         len(added),
+			"disappeared": len(disappeared),
+		}
+
+		sortChanges(changes, sortBy == "relative")
+		sort.SliceStable(added, func(i, j int) bool { return *added[i].After > *added[j].After })
+		sort.SliceStable(disappeared, func(i, j int) bool { return *disappeared[i].Before > *disappeared[j].Before })
+		for _, list := range []*[]groupChange{&changes, &added, &disappeared} {
+			if len(*list) > limit {
+				*list, truncated = (*list)[:limit], true
+			}
+		}
+
+		// Group values may hold redacted fields
+		var groupRows []map[string]interface{}
+		for _, list := range [][]groupChange{changes, added, disappeared} {
+			for _, c := range list {
+				if c.Group != nil {
+					groupRows = append(groupRows, c.Group)
+				}
+			}
+		}
+		report, err := redactQueryRows(ctx, streamName, groupRows)
+		if err != nil {
+			slog.Error("failed to redact rows", "streamName", streamName, "error", err, "tool", "compare_windows")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		result := map[string]interface{}{
+			"changes":     changes,
+			"new":         added,
+			"disappeared": disappeared,
+			"totals":      totals,
+			"groups":      groups,
+			"before":      before.format(),
+			"after":       after.format(),
+			"scale":       roundTo(scale, 4),
+			"query":       query,
+			"truncated":   truncated,
+		}
+		if len(notes) > 0 {
+			result["notes"] = notes
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
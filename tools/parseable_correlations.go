@@ -0,0 +1,493 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+const (
+	parseableCorrelationsPath = "/api/v1/correlation"
+	// Default and maximum number of joined rows returned
+	defaultJoinRows = 100
+	maxJoinRows     = 1000
+)
+
+var joinTypes = []string{"inner", "left"}
+
+// CorrelationTable is a stream of a correlation and the fields selected from it.
+type CorrelationTable struct {
+	TableName      string   `json:"tableName"`
+	SelectedFields []string `json:"selectedFields"`
+}
+
+// CorrelationJoinCondition is one side of a join condition. Conditions come in pairs, one for
+// each stream.
+type CorrelationJoinCondition struct {
+	TableName string `json:"tableName"`
+	Field     string `json:"field"`
+}
+
+type CorrelationJoinConfig struct {
+	JoinConditions []CorrelationJoinCondition `json:"joinConditions"`
+}
+
+// Correlation is a saved join of two streams, as stored by Parseable under /api/v1/correlation.
+type Correlation struct {
+	ID           string                `json:"id"`
+	Title        string                `json:"title"`
+	UserID       string                `json:"userId,omitempty"`
+	Version      string                `json:"version,omitempty"`
+	TableConfigs []CorrelationTable    `json:"tableConfigs"`
+	JoinConfig   CorrelationJoinConfig `json:"joinConfig"`
+	Filter       json.RawMessage       `json:"filter,omitempty"`
+	StartTime    string                `json:"startTime,omitempty"`
+	EndTime      string                `json:"endTime,omitempty"`
+}
+
+func listParseableCorrelations(ctx context.Context) ([]Correlation, error) {
+	var correlations []Correlation
+	err := doParseableRequest(ctx, "GET", parseableCorrelationsPath, nil, &correlations)
+	return correlations, err
+}
+
+// findCorrelation returns a correlation by id, or by title case-insensitively, exactly or else
+// as a unique substring.
+func findCorrelation(ctx context.Context, ref string) (*Correlation, error) {
+	correlations, err := listParseableCorrelations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var exact, partial []Correlation
+	for _, c := range correlations {
+		if c.ID == ref {
+			return &c, nil
+		}
+		title := strings.ToLower(c.Title)
+		switch {
+		case title == strings.ToLower(ref):
+			exact = append(exact, c)
+		case strings.Contains(title, strings.ToLower(ref)):
+			partial = append(partial, c)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = partial
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no correlation with id or title %q", ref)
+	case 1:
+		return &matches[0], nil
+	}
+	titles := make([]string, 0, len(matches))
+	for _, c := range matches {
+		titles = append(titles, fmt.Sprintf("%q (%s)", c.Title, c.ID))
+	}
+	return nil, fmt.Errorf("%q matches several correlations: %s", ref, strings.Join(titles, ", "))
+}
+
+// joinSpec is a join of two streams on pairs of fields.
+type joinSpec struct {
+	Streams  [2]string
+	On       [][2]string
+	Fields   [2][]string
+	JoinType string
+	Filter   string
+}
+
+// joinSpec turns a saved correlation into a join. Its join conditions are pairs of a field of
+// the first and a field of the second stream.
+func (c Correlation) joinSpec() (joinSpec, error) {
+	spec := joinSpec{JoinType: "inner"}
+	if len(c.TableConfigs) != 2 {
+		return spec, fmt.Errorf("correlation %q joins %d streams, only joins of two streams are supported", c.Title, len(c.TableConfigs))
+	}
+	for i, table := range c.TableConfigs {
+		spec.Streams[i] = table.TableName
+		spec.Fields[i] = table.SelectedFields
+	}
+	conditions := c.JoinConfig.JoinConditions
+	if len(conditions) == 0 || len(conditions)%2 != 0 {
+		return spec, fmt.Errorf("correlation %q has no usable join conditions", c.Title)
+	}
+	for i := 0; i < len(conditions); i += 2 {
+		a, b := conditions[i], conditions[i+1]
+		if a.TableName == spec.Streams[1] && b.TableName == spec.Streams[0] {
+			a, b = b, a
+		}
+		spec.On = append(spec.On, [2]string{a.Field, b.Field})
+	}
+	return spec, nil
+}
+
+// checkJoin checks the join fields and selected fields against the stream schemas, and fills
+// in all schema fields for a stream without selected fields. It returns the field types per
+// stream, and warnings such as join fields of different types.
+func checkJoin(ctx context.Context, spec *joinSpec) ([2]map[string]string, []string, error) {
+	var types [2]map[string]string
+	var warnings []string
+	if spec.Streams[0] == spec.Streams[1] {
+		return types, nil, fmt.Errorf("the two streams must be different")
+	}
+	for i, stream := range spec.Streams {
+		schema, err := getParseableSchema(ctx, stream)
+		if err != nil {
+			return types, nil, fmt.Errorf("failed to get schema of stream %q: %w", stream, err)
+		}
+		types[i] = redact.SchemaFieldTypes(schema)
+		if len(spec.Fields[i]) == 0 {
+			spec.Fields[i] = schemaFieldNames(schema)
+		}
+		for _, field := range spec.Fields[i] {
+			if _, ok := types[i][field]; !ok {
+				return types, nil, fmt.Errorf("field %q is not in the schema of stream %q", field, stream)
+			}
+		}
+	}
+	for _, pair := range spec.On {
+		for i, field := range pair {
+			if _, ok := types[i][field]; !ok {
+				return types, nil, fmt.Errorf("join field %q is not in the schema of stream %q", field, spec.Streams[i])
+			}
+		}
+		if a, b := types[0][pair[0]], types[1][pair[1]]; a != b {
+			warnings = append(warnings, fmt.Sprintf("join fields %s.%s (%s) and %s.%s (%s) have different types, values may not match",
+				spec.Streams[0], pair[0], a, spec.Streams[1], pair[1], b))
+		}
+	}
+	return types, warnings, nil
+}
+
+// checkJoinMasking refuses joins on fields masked by the redaction rules of their stream and
+// filters that use masked fields. Both compare raw values in the query, which reveals them one
+// match at a time even though the masked columns of the joined rows are redacted.
+func checkJoinMasking(ctx context.Context, spec joinSpec, types [2]map[string]string) error {
+	if ResultRedactor == nil {
+		return nil
+	}
+	for _, pair := range spec.On {
+		for i, field := range pair {
+			if _, ok := ResultRedactor.FieldAction(spec.Streams[i], field, types[i][field]); ok {
+				return fmt.Errorf("join field %q is masked in stream %q, joining on it would reveal its values", field, spec.Streams[i])
+			}
+		}
+	}
+	if spec.Filter == "" {
+		return nil
+	}
+	// The filter may read other streams in subqueries, whose rules apply as well
+	streams := spec.Streams[:]
+	tableTypes := map[string]map[string]string{spec.Streams[0]: types[0], spec.Streams[1]: types[1]}
+	tables, _ := sqlReferences(spec.Filter)
+	for _, table := range tables {
+		if _, ok := tableTypes[table]; ok {
+			continue
+		}
+		fieldTypes, err := redactionFieldTypes(ctx, table)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, table)
+		tableTypes[table] = fieldTypes
+	}
+	// Unlike a query, a filter has no select list, so any use of a masked field is refused
+	var fields []string
+	for _, t := range tokenizeSQL(spec.Filter) {
+		if !t.isIdent() || slices.Contains(fields, t.text) {
+			continue
+		}
+		for _, stream := range streams {
+			if _, ok := ResultRedactor.FieldAction(stream, t.text, tableTypes[stream][t.text]); ok {
+				fields = append(fields, t.text)
+				break
+			}
+		}
+	}
+	if len(fields) > 0 {
+		quoted := make([]string, len(fields))
+		for i, field := range fields {
+			quoted[i] = strconv.Quote(field)
+		}
+		return fmt.Errorf("the filter uses the masked field(s) %s, conditions on masked fields would reveal their values", strings.Join(quoted, ", "))
+	}
+	return nil
+}
+
+// buildJoinQuery builds the SQL of a join. Selected fields are returned as "<stream>.<field>"
+// so fields of the same name in both streams stay apart.
+func buildJoinQuery(spec joinSpec, types [2]map[string]string, limit int) string {
+	var columns []string
+	for i, stream := range spec.Streams {
+		for _, field := range spec.Fields[i] {
+			columns = append(columns, fmt.Sprintf("%s.%s AS %s", quoteIdent(stream), quoteIdent(field), quoteIdent(stream+"."+field)))
+		}
+	}
+	var on []string
+	for _, pair := range spec.On {
+		on = append(on, fmt.Sprintf("%s.%s = %s.%s", quoteIdent(spec.Streams[0]), quoteIdent(pair[0]), quoteIdent(spec.Streams[1]), quoteIdent(pair[1])))
+	}
+	join := "JOIN"
+	if spec.JoinType == "left" {
+		join = "LEFT JOIN"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s %s %s ON %s", strings.Join(columns, ", "),
+		quoteIdent(spec.Streams[0]), join, quoteIdent(spec.Streams[1]), strings.Join(on, " AND "))
+	if spec.Filter != "" {
+		query += " WHERE " + spec.Filter
+	}
+	if _, ok := types[0]["p_timestamp"]; ok {
+		query += fmt.Sprintf(" ORDER BY %s.%s DESC", quoteIdent(spec.Streams[0]), quoteIdent("p_timestamp"))
+	}
+	return query + fmt.Sprintf(" LIMIT %d", limit)
+}
+
+// redactJoinedRows redacts the columns of each stream in joined rows with the rules of that
+// stream, in place.
+func redactJoinedRows(spec joinSpec, types [2]map[string]string, rows []map[string]interface{}) map[string]redact.Report {
+	if ResultRedactor == nil {
+		return nil
+	}
+	reports := map[string]redact.Report{}
+	for i, stream := range spec.Streams {
+		prefix := stream + "."
+		parts := make([]map[string]interface{}, len(rows))
+		for r, row := range rows {
+			parts[r] = map[string]interface{}{}
+			for key, value := range row {
+				if strings.HasPrefix(key, prefix) {
+					parts[r][strings.TrimPrefix(key, prefix)] = value
+				}
+			}
+		}
+		reports[stream] = ResultRedactor.Rows(stream, parts, types[i])
+		for r, row := range rows {
+			for key := range row {
+				if strings.HasPrefix(key, prefix) {
+					delete(row, key)
+				}
+			}
+			for key, value := range parts[r] {
+				row[prefix+key] = value
+			}
+		}
+	}
+	return reports
+}
+
+// runJoin checks and runs a join and returns the result of correlate_streams and run_correlation.
+func runJoin(ctx context.Context, tool string, spec joinSpec, startTime string, endTime string, limit int) (*mcp.CallToolResult, error) {
+	if limit <= 0 {
+		limit = defaultJoinRows
+	}
+	limit = min(limit, maxJoinRows)
+	types, warnings, err := checkJoin(ctx, &spec)
+	if err != nil {
+		slog.Warn("invalid join", "streams", spec.Streams, "error", err, "tool", tool)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if err := checkJoinMasking(ctx, spec, types); err != nil {
+		slog.Warn("query refused", "streams", spec.Streams, "error", err, "tool", tool)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	query := buildJoinQuery(spec, types, limit)
+
+	slog.Debug("executing query", "tool", tool, "streams", spec.Streams, "startTime", startTime, "endTime", endTime, "query", query)
+	recordQueryLookback(spec.Streams[0], startTime)
+	recordQueryLookback(spec.Streams[1], startTime)
+	rows, err := doParseableQuery(ctx, query, spec.Streams[0], startTime, endTime)
+	if err != nil {
+		slog.Error("failed to get response", "streams", spec.Streams, "error", err, "tool", tool, "query", query)
+		return mcp.NewToolResultError("query failed: " + err.Error() + "\nquery: " + query), nil
+	}
+
+	joinOn := make([]string, 0, len(spec.On))
+	for _, pair := range spec.On {
+		joinOn = append(joinOn, fmt.Sprintf("%s.%s = %s.%s", spec.Streams[0], pair[0], spec.Streams[1], pair[1]))
+	}
+	result := map[string]interface{}{
+		"streams":   spec.Streams,
+		"joinOn":    joinOn,
+		"joinType":  spec.JoinType,
+		"query":     query,
+		"startTime": startTime,
+		"endTime":   endTime,
+		"rows":      rows,
+		"count":     len(rows),
+		"truncated": len(rows) == limit,
+	}
+	if len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
+	if reports := redactJoinedRows(spec, types, rows); reports != nil {
+		result["redactions"] = reports
+	}
+	return mcp.NewToolResultJSON(result)
+}
+
+const joinResultDescription = `Returns a JSON object with:
+- streams, joinOn, joinType: the join that ran
+- query: the generated SQL
+- rows: joined rows, with columns named "<stream>.<field>", newest first
+- count: number of rows; truncated is true if the row limit was reached
+- warnings: e.g. join fields of different types
+- redactions: if redaction is configured, counts per stream
+Joins on fields masked by the redaction rules and filters using masked fields are refused.`
+
+func RegisterListCorrelationsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_correlations",
+		mcp.WithDescription(`List the saved correlations: joins of two data streams on matching fields, e.g. gateway logs and
+service logs on a request id. Calls /api/v1/correlation.
+
+Returns a JSON object with a 'correlations' array and 'count'. Each correlation has:
+- id, title: run it with run_correlation by either
+- tableConfigs: the streams ('tableName') and the fields selected from each ('selectedFields')
+- joinConfig.joinConditions: the join fields, in pairs of a stream and a field
+- startTime, endTime: the time range saved with it, if any
+`),
+		mcp.WithString("stream", mcp.Description("Optional stream to only list correlations joining it")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		stream := mcp.ParseString(req, "stream", "")
+		correlations, err := listParseableCorrelations(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_correlations")
+			return mcp.NewToolResultError("failed to list correlations: " + err.Error()), nil
+		}
+		if stream != "" {
+			correlations = slices.DeleteFunc(correlations, func(c Correlation) bool {
+				return !slices.ContainsFunc(c.TableConfigs, func(t CorrelationTable) bool { return t.TableName == stream })
+			})
+		}
+		if correlations == nil {
+			correlations = []Correlation{}
+		}
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"correlations": correlations,
+			"count":        len(correlations),
+		})
+	})
+}
+
+func RegisterRunCorrelationTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Run a saved correlation: join its two streams on its join fields over a time range.
+Without startTime, endTime or since, the time range saved with the correlation is used, or the last hour if it has none.
+The filter saved with a correlation is not applied; use 'filter' to narrow the rows.
+
+` + joinResultDescription + `
+`),
+		mcp.WithString("correlation", mcp.Required(), mcp.Description("Id or title of the correlation")),
+		mcp.WithString("filter", mcp.Description(`Optional SQL condition on the joined rows, with fields qualified by stream, e.g. "gateway"."status" >= 500`)),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional maximum number of rows (default: %d, at most %d)", defaultJoinRows, maxJoinRows))),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("run_correlation", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ref := mcp.ParseString(req, "correlation", "")
+		if ref == "" {
+			slog.Warn("called with missing parameter", "correlation", ref, "tool", "run_correlation")
+			return mcp.NewToolResultError("missing required field: correlation"), nil
+		}
+		correlation, err := findCorrelation(ctx, ref)
+		if err != nil {
+			slog.Error("failed to get response", "correlation", ref, "error", err, "tool", "run_correlation")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		spec, err := correlation.joinSpec()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		spec.Filter = mcp.ParseString(req, "filter", "")
+
+		startTime, endTime, err := correlationRange(req, correlation)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return runJoin(ctx, "run_correlation", spec, startTime, endTime, mcp.ParseInt(req, "limit", defaultJoinRows))
+	})
+}
+
+// correlationRange returns the range given in the request, else the range saved with the
+// correlation, else the last hour.
+func correlationRange(req mcp.CallToolRequest, c *Correlation) (string, string, error) {
+	args := req.GetArguments()
+	_, hasStart := args["startTime"]
+	_, hasEnd := args["endTime"]
+	_, hasSince := args["since"]
+	if !hasStart && !hasEnd && !hasSince {
+		from, fromOK := parseTimestamp(c.StartTime)
+		to, toOK := parseTimestamp(c.EndTime)
+		if fromOK && toOK && from.Before(to) {
+			return from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), nil
+		}
+	}
+	return timeRangeArguments(req, time.Hour)
+}
+
+func RegisterCorrelateStreamsTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Join two data streams on matching fields over a time range, e.g. to follow a request id from gateway
+logs into service logs. The join fields and selected fields are checked against both stream schemas before the query runs.
+Use joinValue to follow a single value, such as one request id.
+
+` + joinResultDescription + `
+`),
+		mcp.WithString("leftStream", mcp.Required(), mcp.Description("First stream, e.g. 'gateway'")),
+		mcp.WithString("rightStream", mcp.Required(), mcp.Description("Second stream, e.g. 'payments'")),
+		mcp.WithString("leftField", mcp.Required(), mcp.Description("Join field of the first stream, e.g. 'request_id'")),
+		mcp.WithString("rightField", mcp.Description("Join field of the second stream (default: same as leftField)")),
+		mcp.WithArray("leftFields", mcp.WithStringItems(), mcp.Description("Optional fields to return from the first stream (default: all)")),
+		mcp.WithArray("rightFields", mcp.WithStringItems(), mcp.Description("Optional fields to return from the second stream (default: all)")),
+		mcp.WithString("joinValue", mcp.Description("Optional value of the join field to follow, e.g. a request id")),
+		mcp.WithString("joinType", mcp.Enum(joinTypes...), mcp.Description("Optional: 'inner' returns matches only, 'left' also rows of the first stream without a match (default: inner)")),
+		mcp.WithString("filter", mcp.Description(`Optional SQL condition on the joined rows, with fields qualified by stream, e.g. "gateway"."status" >= 500`)),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional maximum number of rows (default: %d, at most %d)", defaultJoinRows, maxJoinRows))),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("correlate_streams", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		leftStream := mcp.ParseString(req, "leftStream", "")
+		rightStream := mcp.ParseString(req, "rightStream", "")
+		leftField := mcp.ParseString(req, "leftField", "")
+		rightField := mcp.ParseString(req, "rightField", leftField)
+		if leftStream == "" || rightStream == "" || leftField == "" {
+			slog.Warn("called with missing parameter", "leftStream", leftStream, "rightStream", rightStream, "leftField", leftField, "tool", "correlate_streams")
+			return mcp.NewToolResultError("missing required fields: leftStream, rightStream and leftField are required"), nil
+		}
+		joinType := mcp.ParseString(req, "joinType", "inner")
+		if !slices.Contains(joinTypes, joinType) {
+			return mcp.NewToolResultError("unsupported joinType: " + joinType + " (supported: " + strings.Join(joinTypes, ", ") + ")"), nil
+		}
+
+		spec := joinSpec{
+			Streams:  [2]string{leftStream, rightStream},
+			On:       [][2]string{{leftField, rightField}},
+			Fields:   [2][]string{req.GetStringSlice("leftFields", nil), req.GetStringSlice("rightFields", nil)},
+			JoinType: joinType,
+		}
+		var conditions []string
+		if value := mcp.ParseString(req, "joinValue", ""); value != "" {
+			conditions = append(conditions, fmt.Sprintf("CAST(%s.%s AS VARCHAR) = %s", quoteIdent(leftStream), quoteIdent(leftField), quoteLiteral(value)))
+		}
+		if filter := mcp.ParseString(req, "filter", ""); filter != "" {
+			conditions = append(conditions, "("+filter+")")
+		}
+		spec.Filter = strings.Join(conditions, " AND ")
+
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return runJoin(ctx, "correlate_streams", spec, startTime, endTime, mcp.ParseInt(req, "limit", defaultJoinRows))
+	})
+}
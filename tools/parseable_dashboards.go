@@ -0,0 +1,514 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+const (
+	parseableDashboardsPath = "/api/v1/dashboards"
+	// Number of tile queries run at the same time by run_dashboard
+	dashboardConcurrency = 4
+	// Default number of rows returned per tile by run_dashboard
+	defaultTileRows = 50
+	// Range the generated tile queries are test run over
+	dashboardTestWindow = time.Hour
+)
+
+var tileVisualizations = []string{"line", "bar", "area", "pie", "donut", "table", "stat"}
+
+// Tile is a single panel of a dashboard and the SQL query it runs.
+type Tile struct {
+	TileID      string `json:"tile_id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Stream      string `json:"stream,omitempty"`
+	Query       string `json:"query"`
+	Order       int    `json:"order,omitempty"`
+	// Visualization is passed through as is, its format depends on the Parseable version.
+	Visualization json.RawMessage `json:"visualization,omitempty"`
+}
+
+// Dashboard is a Parseable dashboard. Older Parseable versions use name instead of title.
+type Dashboard struct {
+	ID              string          `json:"dashboard_id,omitempty"`
+	Title           string          `json:"title,omitempty"`
+	Name            string          `json:"name,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	Author          string          `json:"author,omitempty"`
+	Tags            []string        `json:"tags,omitempty"`
+	Modified        string          `json:"modified,omitempty"`
+	TimeFilter      json.RawMessage `json:"time_filter,omitempty"`
+	RefreshInterval int             `json:"refresh_interval,omitempty"`
+	Tiles           []Tile          `json:"tiles"`
+}
+
+func (d Dashboard) displayTitle() string {
+	if d.Title != "" {
+		return d.Title
+	}
+	return d.Name
+}
+
+func (t Tile) displayTitle() string {
+	if t.Title != "" {
+		return t.Title
+	}
+	return t.Name
+}
+
+// stream returns the stream the tile queries: its stream field, or the first table in the
+// FROM clause of its query.
+func (t Tile) stream() string {
+	if t.Stream != "" {
+		return t.Stream
+	}
+	if tables, _ := sqlReferences(t.Query); len(tables) > 0 {
+		return tables[0]
+	}
+	return ""
+}
+
+func listParseableDashboards(ctx context.Context) ([]Dashboard, error) {
+	var dashboards []Dashboard
+	err := doParseableRequest(ctx, "GET", parseableDashboardsPath, nil, &dashboards)
+	return dashboards, err
+}
+
+func getParseableDashboard(ctx context.Context, dashboardID string) (*Dashboard, error) {
+	var dashboard Dashboard
+	if err := doParseableRequest(ctx, "GET", parseableDashboardsPath+"/"+url.PathEscape(dashboardID), nil, &dashboard); err != nil {
+		return nil, err
+	}
+	return &dashboard, nil
+}
+
+// findDashboard fetches a dashboard by id, or by title if no dashboard has that id. Titles
+// match case-insensitively, exactly or else as a unique substring.
+func findDashboard(ctx context.Context, ref string) (*Dashboard, error) {
+	dashboards, err := listParseableDashboards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var exact, partial []Dashboard
+	for _, d := range dashboards {
+		if d.ID == ref {
+			return getParseableDashboard(ctx, d.ID)
+		}
+		title := strings.ToLower(d.displayTitle())
+		switch {
+		case title == strings.ToLower(ref):
+			exact = append(exact, d)
+		case strings.Contains(title, strings.ToLower(ref)):
+			partial = append(partial, d)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = partial
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no dashboard with id or title %q", ref)
+	case 1:
+		return getParseableDashboard(ctx, matches[0].ID)
+	}
+	titles := make([]string, 0, len(matches))
+	for _, d := range matches {
+		titles = append(titles, fmt.Sprintf("%q (%s)", d.displayTitle(), d.ID))
+	}
+	return nil, fmt.Errorf("%q matches several dashboards: %s", ref, strings.Join(titles, ", "))
+}
+
+func RegisterListDashboardsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_dashboards",
+		mcp.WithDescription(`List the dashboards configured in Parseable.
+Calls /api/v1/dashboards.
+
+Returns a JSON object with a 'dashboards' array and 'count' (number of dashboards). Each dashboard includes:
+- id: unique dashboard identifier
+- title: dashboard name
+- description, author, tags, modified: dashboard metadata, if set
+- tileCount: number of tiles
+`),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		dashboards, err := listParseableDashboards(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_dashboards")
+			return mcp.NewToolResultError("failed to list dashboards: " + err.Error()), nil
+		}
+		items := make([]map[string]interface{}, 0, len(dashboards))
+		for _, d := range dashboards {
+			item := map[string]interface{}{
+				"id":        d.ID,
+				"title":     d.displayTitle(),
+				"tileCount": len(d.Tiles),
+			}
+			if d.Description != "" {
+				item["description"] = d.Description
+			}
+			if d.Author != "" {
+				item["author"] = d.Author
+			}
+			if len(d.Tags) > 0 {
+				item["tags"] = d.Tags
+			}
+			if d.Modified != "" {
+				item["modified"] = d.Modified
+			}
+			items = append(items, item)
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"dashboards": items,
+			"count":      len(items),
+		})
+	})
+}
+
+func RegisterGetDashboardTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_dashboard",
+		mcp.WithDescription(`Get a dashboard and its tiles, including the SQL query each tile runs.
+Calls /api/v1/dashboards/<dashboardId>.
+
+Returns the dashboard with:
+- dashboard_id, title, description, tags: dashboard metadata
+- time_filter, refresh_interval: the default time range and refresh of the dashboard, if set
+- tiles: array of tiles with tile_id, title, stream, query (the SQL run for the tile) and visualization
+`),
+		mcp.WithString("dashboard", mcp.Required(), mcp.Description("Id or title of the dashboard, e.g. 'payments'. A title matches if it is unique, ignoring case")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ref := mcp.ParseString(req, "dashboard", "")
+		if ref == "" {
+			slog.Warn("called with missing parameter", "parameter", "dashboard", "tool", "get_dashboard")
+			return mcp.NewToolResultError("missing required field: dashboard"), nil
+		}
+
+		dashboard, err := findDashboard(ctx, ref)
+		if err != nil {
+			slog.Error("failed to get response", "dashboard", ref, "error", err, "tool", "get_dashboard")
+			return mcp.NewToolResultError("failed to get dashboard: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(dashboard)
+	})
+}
+
+// tileResult is the outcome of running a tile query.
+type tileResult struct {
+	TileID     string                   `json:"tileId,omitempty"`
+	Title      string                   `json:"title"`
+	Stream     string                   `json:"stream"`
+	Query      string                   `json:"query"`
+	Rows       []map[string]interface{} `json:"rows,omitempty"`
+	Count      int                      `json:"count"`
+	Truncated  bool                     `json:"truncated,omitempty"`
+	Redactions *redact.Report           `json:"redactions,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+func runTile(ctx context.Context, tile Tile, startTime string, endTime string, maxRows int) tileResult {
+	result := tileResult{TileID: tile.TileID, Title: tile.displayTitle(), Stream: tile.stream(), Query: tile.Query}
+	if result.Stream == "" {
+		result.Error = "cannot tell which stream the tile queries"
+		return result
+	}
+	if err := checkMaskedReferences(ctx, result.Stream, tile.Query, nil); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	recordQueryLookback(result.Stream, startTime)
+	rows, err := doParseableQuery(ctx, tile.Query, result.Stream, startTime, endTime)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	report, err := redactQueryRows(ctx, result.Stream, rows)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Redactions = report
+	result.Count = len(rows)
+	if len(rows) > maxRows {
+		rows = rows[:maxRows]
+		result.Truncated = true
+	}
+	result.Rows = rows
+	return result
+}
+
+func RegisterRunDashboardTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Run the queries of a dashboard's tiles for a time range and return what the dashboard shows.
+Use this to answer questions like "what does the payments dashboard say for the last hour".
+All tiles run unless 'tile' is given. Tile queries run through the Parseable query API, and rows are redacted like query_data_stream results.
+
+Returns a JSON object with:
+- dashboard: id and title of the dashboard
+- startTime, endTime: the time range queried
+- tiles: array with per tile tileId, title, stream, query, rows, count (rows returned by the query),
+  truncated (true if rows were cut to maxRows), redactions and error (if the query failed)
+`),
+		mcp.WithString("dashboard", mcp.Required(), mcp.Description("Id or title of the dashboard, e.g. 'payments'. A title matches if it is unique, ignoring case")),
+		mcp.WithString("tile", mcp.Description("Optional id or title of a single tile to run")),
+		mcp.WithNumber("maxRows", mcp.Description(fmt.Sprintf("Optional maximum number of rows returned per tile (default: %d)", defaultTileRows))),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("run_dashboard", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ref := mcp.ParseString(req, "dashboard", "")
+		tileRef := mcp.ParseString(req, "tile", "")
+		maxRows := mcp.ParseInt(req, "maxRows", defaultTileRows)
+		if ref == "" {
+			slog.Warn("called with missing parameter", "parameter", "dashboard", "tool", "run_dashboard")
+			return mcp.NewToolResultError("missing required field: dashboard"), nil
+		}
+		if maxRows <= 0 {
+			maxRows = defaultTileRows
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		dashboard, err := findDashboard(ctx, ref)
+		if err != nil {
+			slog.Error("failed to get response", "dashboard", ref, "error", err, "tool", "run_dashboard")
+			return mcp.NewToolResultError("failed to get dashboard: " + err.Error()), nil
+		}
+		tiles := dashboard.Tiles
+		if tileRef != "" {
+			tiles = nil
+			for _, tile := range dashboard.Tiles {
+				if tile.TileID == tileRef || strings.EqualFold(tile.displayTitle(), tileRef) {
+					tiles = append(tiles, tile)
+				}
+			}
+			if len(tiles) == 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("dashboard %q has no tile with id or title %q", dashboard.displayTitle(), tileRef)), nil
+			}
+		}
+
+		slog.Debug("running dashboard", "dashboard", dashboard.ID, "tiles", len(tiles), "startTime", startTime, "endTime", endTime)
+		results := make([]tileResult, len(tiles))
+		sem := make(chan struct{}, dashboardConcurrency)
+		var wg sync.WaitGroup
+		for i, tile := range tiles {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results[i] = runTile(ctx, tile, startTime, endTime, maxRows)
+			}()
+		}
+		wg.Wait()
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"dashboard": map[string]string{"id": dashboard.ID, "title": dashboard.displayTitle()},
+			"startTime": startTime,
+			"endTime":   endTime,
+			"tiles":     results,
+		})
+	})
+}
+
+// tileSpec is a tile of a dashboard to generate, as passed to generate_dashboard.
+type tileSpec struct {
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	Query         string `json:"query"`
+	Visualization string `json:"visualization"`
+}
+
+// tileCheck is the validation and test run result of a generated tile.
+type tileCheck struct {
+	Title    string   `json:"title"`
+	Stream   string   `json:"stream,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// TestRows is the number of rows the query returned over the last hour.
+	TestRows *int `json:"testRows,omitempty"`
+}
+
+// checkTile validates the query of a generated tile against the stream schemas and test
+// runs it. Schemas are cached across tiles in schemaFields.
+func checkTile(ctx context.Context, spec tileSpec, schemaFields map[string]map[string]bool, startTime string, endTime string) tileCheck {
+	check := tileCheck{Title: spec.Title}
+	if strings.TrimSpace(spec.Title) == "" {
+		check.Errors = append(check.Errors, "title is required")
+	}
+	known := false
+	for _, v := range tileVisualizations {
+		known = known || spec.Visualization == v
+	}
+	if !known {
+		check.Warnings = append(check.Warnings, fmt.Sprintf("visualization %q is not one of %s", spec.Visualization, strings.Join(tileVisualizations, ", ")))
+	}
+	tables, columns := sqlReferences(spec.Query)
+	if len(tables) == 0 {
+		check.Errors = append(check.Errors, "query has no FROM clause")
+		return check
+	}
+	check.Stream = tables[0]
+	fields := map[string]bool{}
+	for _, table := range tables {
+		if _, ok := schemaFields[table]; !ok {
+			schema, err := getParseableSchema(ctx, table)
+			if err != nil {
+				schemaFields[table] = nil
+			} else {
+				schemaFields[table] = map[string]bool{}
+				for _, name := range schemaFieldNames(schema) {
+					schemaFields[table][name] = true
+				}
+			}
+		}
+		if schemaFields[table] == nil {
+			check.Errors = append(check.Errors, fmt.Sprintf("stream %q does not exist or its schema cannot be read", table))
+			continue
+		}
+		for name := range schemaFields[table] {
+			fields[name] = true
+		}
+	}
+	if len(check.Errors) > 0 {
+		return check
+	}
+	for _, column := range columns {
+		if !fields[column] {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("query references %q which is not a field in the schema", column))
+		}
+	}
+	if err := checkMaskedReferences(ctx, check.Stream, spec.Query, nil); err != nil {
+		check.Warnings = append(check.Warnings, err.Error())
+	}
+	rows, err := doParseableQuery(ctx, spec.Query, check.Stream, startTime, endTime)
+	if err != nil {
+		check.Errors = append(check.Errors, "test run failed: "+err.Error())
+		return check
+	}
+	count := len(rows)
+	check.TestRows = &count
+	if count == 0 {
+		check.Warnings = append(check.Warnings, "query returned no rows over the last hour")
+	}
+	return check
+}
+
+func RegisterGenerateDashboardTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"generate_dashboard",
+		mcp.WithDescription(`Build a dashboard definition from tiles you write for a natural-language description, validate it and optionally save it.
+Workflow: list the data streams and read their schemas with get_data_stream_schema, turn the requested dashboard into tiles
+(one SQL query per tile, using only fields from the schema), then call this tool. Fix reported errors and call it again before saving.
+
+Every tile query is checked against the schema of the streams it reads from and test run over the last hour.
+Saving calls POST /api/v1/dashboards and requires writes to be enabled on the server; without 'save' nothing is written.
+
+Returns a JSON object with:
+- dashboard: the generated dashboard definition
+- valid: true if no tile has errors
+- tiles: per tile title, stream, errors, warnings (e.g. fields missing from the schema or no rows) and testRows
+- saved: the saved dashboard, if 'save' was set and the definition is valid
+`),
+		mcp.WithString("title", mcp.Required(), mcp.Description("Dashboard title")),
+		mcp.WithString("description", mcp.Description("Optional dashboard description, e.g. the request it was generated from")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Optional dashboard tags")),
+		mcp.WithArray("tiles", mcp.Required(), mcp.Description("Tiles of the dashboard in display order"), mcp.Items(map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"title":         map[string]interface{}{"type": "string", "description": "Tile title"},
+				"description":   map[string]interface{}{"type": "string", "description": "Optional tile description"},
+				"query":         map[string]interface{}{"type": "string", "description": "SQL query of the tile, e.g. 'SELECT status, COUNT(*) AS count FROM payments GROUP BY status'. Do not filter on time, the dashboard time range is applied"},
+				"visualization": map[string]interface{}{"type": "string", "enum": tileVisualizations, "description": "How the tile is shown"},
+			},
+			"required": []string{"title", "query", "visualization"},
+		})),
+		mcp.WithBoolean("save", mcp.Description("Optional: save the dashboard if it is valid (default: false)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		title := mcp.ParseString(req, "title", "")
+		if title == "" {
+			slog.Warn("called with missing parameter", "parameter", "title", "tool", "generate_dashboard")
+			return mcp.NewToolResultError("missing required field: title"), nil
+		}
+		var specs []tileSpec
+		if raw, ok := req.GetArguments()["tiles"]; ok {
+			b, err := json.Marshal(raw)
+			if err == nil {
+				err = json.Unmarshal(b, &specs)
+			}
+			if err != nil {
+				return mcp.NewToolResultError("invalid tiles: " + err.Error()), nil
+			}
+		}
+		if len(specs) == 0 {
+			slog.Warn("called with missing parameter", "parameter", "tiles", "tool", "generate_dashboard")
+			return mcp.NewToolResultError("missing required field: tiles"), nil
+		}
+
+		end := time.Now().UTC()
+		startTime := end.Add(-dashboardTestWindow).Format(time.RFC3339)
+		endTime := end.Format(time.RFC3339)
+		dashboard := Dashboard{
+			Title:       title,
+			Description: mcp.ParseString(req, "description", ""),
+			Tags:        req.GetStringSlice("tags", nil),
+		}
+		schemaFields := map[string]map[string]bool{}
+		checks := make([]tileCheck, 0, len(specs))
+		valid := true
+		for i, spec := range specs {
+			check := checkTile(ctx, spec, schemaFields, startTime, endTime)
+			valid = valid && len(check.Errors) == 0
+			checks = append(checks, check)
+			visualization, _ := json.Marshal(map[string]string{"visualization_type": spec.Visualization})
+			dashboard.Tiles = append(dashboard.Tiles, Tile{
+				Title:         spec.Title,
+				Description:   spec.Description,
+				Stream:        check.Stream,
+				Query:         spec.Query,
+				Order:         i + 1,
+				Visualization: visualization,
+			})
+		}
+
+		result := map[string]interface{}{
+			"dashboard": dashboard,
+			"valid":     valid,
+			"tiles":     checks,
+		}
+		if !mcp.ParseBoolean(req, "save", false) {
+			return mcp.NewToolResultJSON(result)
+		}
+		if !valid {
+			slog.Warn("invalid dashboard definition", "tool", "generate_dashboard")
+			return mcp.NewToolResultError("dashboard not saved, fix the tile errors first"), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("generate_dashboard"), nil
+		}
+
+		var saved Dashboard
+		if err := doParseableRequest(ctx, "POST", parseableDashboardsPath, dashboard, &saved); err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "generate_dashboard")
+			return mcp.NewToolResultError("failed to save dashboard: " + err.Error()), nil
+		}
+		result["saved"] = saved
+		return mcp.NewToolResultJSON(result)
+	})
+}
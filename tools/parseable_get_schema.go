@@ -19,6 +19,7 @@ Returns a JSON object with a 'fields' array containing field definitions for eac
 Each field includes:
 - name: the field name (string)
 - data_type: the data type of the field (e.g., "String", "i64", "f64", "bool", "DateTime")
+- masked: present only if the field is masked in query_data_stream results; the action applied ("mask", "hash", "bucket" or "drop")
 
 Use this tool to understand what fields are available for filtering, grouping, or selecting in query_data_stream operations.
 `),
@@ -30,12 +31,13 @@ Use this tool to understand what fields are available for filtering, grouping, o
 			return mcp.NewToolResultError("missing required field: streamName"), nil
 		}
 
-		schema, err := getParseableSchema(stream)
+		schema, err := getParseableSchema(ctx, stream)
 		if err != nil {
 			slog.Error("failed to get response", "tool", "get_data_stream_schema", "streamName", stream, "error", err)
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
+		ResultRedactor.Schema(stream, schema)
 		return mcp.NewToolResultJSON(schema)
 	})
 }
@@ -0,0 +1,320 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// Smallest hot tier Parseable accepts for a stream
+	minHotTierBytes = 10 << 30
+	// Extra space on top of the estimated size in a hot tier recommendation
+	hotTierHeadroom = 1.2
+	// Lookback covered when no queries of the stream have been seen
+	defaultHotTierLookback = 7 * 24 * time.Hour
+	// Percentile of query lookbacks a recommended hot tier covers
+	hotTierLookbackPercentile = 0.9
+)
+
+// byteSize is a size in bytes that Parseable returns either as a number or as a string
+// such as "10 GiB", depending on the version.
+type byteSize float64
+
+func (b *byteSize) UnmarshalJSON(data []byte) error {
+	var n float64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = byteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, err := parseByteSize(s)
+	*b = byteSize(n)
+	return err
+}
+
+// HotTier is the hot tier of a stream: local disk Parseable keeps recent data on for faster queries.
+type HotTier struct {
+	Size          byteSize `json:"size"`
+	UsedSize      byteSize `json:"used_size"`
+	AvailableSize byteSize `json:"available_size"`
+	OldestEntry   string   `json:"oldest_date_time_entry,omitempty"`
+}
+
+func hotTierPath(streamName string) string {
+	return "/api/v1/logstream/" + streamName + "/hottier"
+}
+
+func getParseableHotTier(ctx context.Context, streamName string) (*HotTier, error) {
+	var hotTier HotTier
+	if err := doParseableRequest(ctx, "GET", hotTierPath(streamName), nil, &hotTier); err != nil {
+		return nil, err
+	}
+	return &hotTier, nil
+}
+
+// hotTierEnabled reports whether the server has hot tier storage configured, from /api/v1/about.
+func hotTierEnabled(ctx context.Context) (bool, error) {
+	about, err := getParseableAbout(ctx)
+	if err != nil {
+		return false, err
+	}
+	switch v := about["hotTier"].(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strings.EqualFold(v, "enabled") || strings.EqualFold(v, "true"), nil
+	}
+	return false, nil
+}
+
+// HotTierRecommendation is a suggested hot tier size: the stored bytes per day of the stream
+// times the lookback that queries of the stream reach, plus headroom.
+type HotTierRecommendation struct {
+	Bytes             float64 `json:"bytes"`
+	Size              string  `json:"size"`
+	BytesPerDay       float64 `json:"bytesPerDay"`
+	LookbackDays      float64 `json:"lookbackDays"`
+	LookbackSource    string  `json:"lookbackSource"`
+	QueriesObserved   int     `json:"queriesObserved"`
+	RaisedToMinimum   bool    `json:"raisedToMinimum,omitempty"`
+	ExceedsStoredData bool    `json:"exceedsStoredData,omitempty"`
+	Reasoning         string  `json:"reasoning"`
+}
+
+// recommendHotTier sizes a hot tier from the storage growth of the stream (stored size over
+// the span between its first and latest event) and how far back queries reach. lookback
+// overrides the observed query lookbacks when set.
+func recommendHotTier(ctx context.Context, streamName string, lookback time.Duration) (*HotTierRecommendation, error) {
+	stats, err := getParseableStats(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	info, err := getParseableInfo(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info: %w", err)
+	}
+	stored, _ := statNumber(stats, "storage", "size")
+	rec := &HotTierRecommendation{}
+
+	spanDays := 1.0
+	first, _ := info["firstEventAt"].(string)
+	latest, _ := info["latestEventAt"].(string)
+	if firstAt, ok := parseTimestamp(first); ok {
+		if latestAt, ok := parseTimestamp(latest); ok && latestAt.After(firstAt) {
+			spanDays = math.Max(latestAt.Sub(firstAt).Hours()/24, 1)
+		}
+	}
+	rec.BytesPerDay = math.Round(stored / spanDays)
+
+	observed, count := queryLookbackPercentile(streamName, hotTierLookbackPercentile)
+	rec.QueriesObserved = count
+	switch {
+	case lookback > 0:
+		rec.LookbackSource = "argument"
+	case count > 0:
+		lookback, rec.LookbackSource = observed, "observedQueries"
+	default:
+		lookback, rec.LookbackSource = defaultHotTierLookback, "default"
+	}
+	// Hot tier data is kept per day, so a partial day needs the whole day
+	rec.LookbackDays = math.Max(math.Ceil(lookback.Hours()/24), 1)
+
+	rec.Bytes = math.Ceil(rec.BytesPerDay * rec.LookbackDays * hotTierHeadroom)
+	if rec.Bytes < minHotTierBytes {
+		rec.Bytes = minHotTierBytes
+		rec.RaisedToMinimum = true
+	}
+	rec.ExceedsStoredData = rec.Bytes > stored*hotTierHeadroom && !rec.RaisedToMinimum
+	rec.Size = formatByteSize(rec.Bytes)
+
+	var why strings.Builder
+	fmt.Fprintf(&why, "The stream stores about %s per day (%s over %.0f days). ", formatByteSize(rec.BytesPerDay), formatByteSize(stored), spanDays)
+	switch rec.LookbackSource {
+	case "observedQueries":
+		fmt.Fprintf(&why, "90%% of the %d queries seen by this server reach back %.0f days or less. ", count, rec.LookbackDays)
+	case "argument":
+		fmt.Fprintf(&why, "Queries are expected to reach back %.0f days. ", rec.LookbackDays)
+	default:
+		fmt.Fprintf(&why, "No queries of the stream were seen by this server, so %.0f days are assumed. ", rec.LookbackDays)
+	}
+	fmt.Fprintf(&why, "Covering them with %.0f%% headroom needs %s", (hotTierHeadroom-1)*100, rec.Size)
+	if rec.RaisedToMinimum {
+		fmt.Fprintf(&why, ", raised to the minimum hot tier size of %s", formatByteSize(minHotTierBytes))
+	}
+	why.WriteString(".")
+	if rec.ExceedsStoredData {
+		why.WriteString(" This is more than the stream stores today; the hot tier only fills up as data arrives.")
+	}
+	rec.Reasoning = why.String()
+	return rec, nil
+}
+
+func RegisterGetHotTierTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_hot_tier",
+		mcp.WithDescription(`Get the hot tier of a data stream and a sizing recommendation.
+The hot tier keeps the most recent data of a stream on local disk so queries over it are faster.
+Calls /api/v1/logstream/<streamName>/hottier, /api/v1/about, and the stream stats and info for the recommendation.
+
+Returns a JSON object with:
+- stream: data stream name
+- serverHotTierEnabled: whether hot tier storage is configured on the Parseable server
+- hotTier: the current hot tier with size, used_size and available_size in bytes and oldest_date_time_entry, or null if none is set
+- hotTierError: why the hot tier could not be read, if it is not set
+- recommendation: suggested size (bytes and size), bytesPerDay stored by the stream, lookbackDays covered,
+  lookbackSource ("observedQueries" from queries run through this server, "argument" or "default"), queriesObserved and reasoning
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the data stream")),
+		mcp.WithString("lookback", mcp.Description("Optional time range queries need to be fast for, e.g. '3d' (default: what queries through this server reached, else 7d)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "parameter", "streamName", "tool", "get_hot_tier")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		var lookback time.Duration
+		if s := mcp.ParseString(req, "lookback", ""); s != "" {
+			d, err := parseHumanDuration(s)
+			if err != nil || d <= 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid lookback %q, expected a duration such as '3d'", s)), nil
+			}
+			lookback = d
+		}
+
+		result := map[string]interface{}{
+			"stream":  streamName,
+			"hotTier": nil,
+		}
+		enabled, err := hotTierEnabled(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_hot_tier")
+			return mcp.NewToolResultError("failed to get about: " + err.Error()), nil
+		}
+		result["serverHotTierEnabled"] = enabled
+		// Parseable answers with an error when the stream has no hot tier
+		if hotTier, err := getParseableHotTier(ctx, streamName); err != nil {
+			result["hotTierError"] = err.Error()
+		} else {
+			result["hotTier"] = hotTier
+		}
+		recommendation, err := recommendHotTier(ctx, streamName, lookback)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_hot_tier")
+			return mcp.NewToolResultError("failed to size hot tier: " + err.Error()), nil
+		}
+		result["recommendation"] = recommendation
+
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func RegisterSetHotTierTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"set_hot_tier",
+		mcp.WithDescription(`Set the hot tier size of a data stream. Use get_hot_tier first to see the recommended size.
+Requires hot tier storage to be configured on the Parseable server and writes to be enabled on this server.
+Calls PUT /api/v1/logstream/<streamName>/hottier.
+
+Returns a JSON object with 'stream', 'previous' (the hot tier before, if any) and 'hotTier' (the hot tier after the change).
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the data stream")),
+		mcp.WithString("size", mcp.Required(), mcp.Description("Hot tier size, e.g. '20 GiB' or a number of bytes. At least 10 GiB")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		sizeArg := mcp.ParseString(req, "size", "")
+		if streamName == "" || sizeArg == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "size", sizeArg, "tool", "set_hot_tier")
+			return mcp.NewToolResultError("missing required fields: streamName and size are required"), nil
+		}
+		if err := checkStreamName(streamName); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		size, err := parseByteSize(sizeArg)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if size < minHotTierBytes {
+			return mcp.NewToolResultError(fmt.Sprintf("size %s is below the minimum hot tier size of %s", formatByteSize(size), formatByteSize(minHotTierBytes))), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("set_hot_tier"), nil
+		}
+		enabled, err := hotTierEnabled(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "set_hot_tier")
+			return mcp.NewToolResultError("failed to get about: " + err.Error()), nil
+		}
+		if !enabled {
+			return mcp.NewToolResultError("hot tier storage is not configured on the Parseable server, set P_HOT_TIER_DIR there first"), nil
+		}
+
+		previous, _ := getParseableHotTier(ctx, streamName)
+		body := map[string]string{"size": formatByteSize(math.Round(size))}
+		if err := doParseableRequest(ctx, "PUT", hotTierPath(streamName), body, nil); err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "set_hot_tier")
+			return mcp.NewToolResultError("failed to set hot tier: " + err.Error()), nil
+		}
+		hotTier, err := getParseableHotTier(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "set_hot_tier")
+			return mcp.NewToolResultError("hot tier set, but failed to read it back: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"stream":   streamName,
+			"previous": previous,
+			"hotTier":  hotTier,
+		})
+	})
+}
+
+func RegisterDeleteHotTierTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_hot_tier",
+		mcp.WithDescription(`Remove the hot tier of a data stream. Its data stays in object storage, but queries over it are no longer served from local disk.
+Requires writes to be enabled on the server. Calls DELETE /api/v1/logstream/<streamName>/hottier.
+
+Returns a JSON object with 'stream' and 'deleted' (the hot tier that was removed).
+`),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the data stream")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "parameter", "streamName", "tool", "delete_hot_tier")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		if err := checkStreamName(streamName); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("delete_hot_tier"), nil
+		}
+
+		hotTier, err := getParseableHotTier(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "delete_hot_tier")
+			return mcp.NewToolResultError("failed to get hot tier: " + err.Error()), nil
+		}
+		if err := doParseableRequest(ctx, "DELETE", hotTierPath(streamName), nil, nil); err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "delete_hot_tier")
+			return mcp.NewToolResultError("failed to delete hot tier: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"stream":  streamName,
+			"deleted": hotTier,
+		})
+	})
+}
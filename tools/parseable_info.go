@@ -36,7 +36,7 @@ Use this tool before querying a stream to understand its fields and structure.
 			return mcp.NewToolResultError("missing required field: streamName"), nil
 		}
 
-		info, err := getParseableInfo(streamName)
+		info, err := getParseableInfo(ctx, streamName)
 		if err != nil {
 			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_data_stream_info")
 			return mcp.NewToolResultError("failed to get info: " + err.Error()), nil
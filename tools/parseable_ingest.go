@@ -0,0 +1,264 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+const (
+	parseableIngestPath = "/api/v1/ingest"
+	// Maximum number of events accepted by a single ingest_events call
+	maxIngestEvents = 10000
+	// Maximum size of a single event, serialized as JSON
+	maxIngestEventBytes = 256 << 10
+	// Maximum size of the body of a single ingest request
+	maxIngestBatchBytes = 4 << 20
+	// Default number of events sent per ingest request
+	defaultIngestBatchSize = 500
+	// Maximum number of problems listed in an ingest_events result
+	maxIngestProblems = 50
+)
+
+// eventProblem is a validation problem of an event, by its index in the events argument.
+type eventProblem struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// valueMatchesType reports whether a JSON value can be stored in a field of the given Arrow
+// data type. Types the check does not know are accepted.
+func valueMatchesType(v interface{}, dataType string) bool {
+	if v == nil {
+		return true
+	}
+	t := strings.ToLower(dataType)
+	switch {
+	case strings.Contains(t, "utf8"):
+		_, ok := v.(string)
+		return ok
+	case strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint"):
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case strings.HasPrefix(t, "float") || strings.HasPrefix(t, "decimal"):
+		_, ok := v.(float64)
+		return ok
+	case t == "boolean":
+		_, ok := v.(bool)
+		return ok
+	case strings.HasPrefix(t, "timestamp") || strings.HasPrefix(t, "date"):
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, ok = parseTimestamp(s)
+		return ok
+	case strings.Contains(t, "list"):
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return true
+}
+
+// validateEvents checks the size of each event and, if fieldTypes is not nil, that values
+// match the stream schema. Fields the schema does not have are reported as warnings since
+// Parseable adds them to the schema unless the stream has a static schema.
+func validateEvents(events []map[string]interface{}, fieldTypes map[string]string) (sizes []int, errs []eventProblem, warnings []eventProblem) {
+	sizes = make([]int, len(events))
+	newFields := map[string]int{}
+	for i, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			errs = append(errs, eventProblem{Index: i, Message: err.Error()})
+			continue
+		}
+		sizes[i] = len(b)
+		if len(b) > maxIngestEventBytes {
+			errs = append(errs, eventProblem{Index: i, Message: fmt.Sprintf("event is %s, above the limit of %s", formatByteSize(float64(len(b))), formatByteSize(maxIngestEventBytes))})
+		}
+		names := make([]string, 0, len(event))
+		for name := range event {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if strings.HasPrefix(name, "p_") {
+				warnings = append(warnings, eventProblem{Index: i, Field: name, Message: "fields starting with p_ are reserved by Parseable"})
+				continue
+			}
+			if fieldTypes == nil {
+				continue
+			}
+			dataType, ok := fieldTypes[name]
+			if !ok {
+				if _, seen := newFields[name]; !seen {
+					newFields[name] = i
+				}
+				continue
+			}
+			if !valueMatchesType(event[name], dataType) {
+				errs = append(errs, eventProblem{Index: i, Field: name, Message: fmt.Sprintf("value %v does not match the field type %s", event[name], dataType)})
+			}
+		}
+	}
+	for name, i := range newFields {
+		warnings = append(warnings, eventProblem{Index: i, Field: name, Message: "field is not in the stream schema and will be added, unless the stream has a static schema"})
+	}
+	sort.SliceStable(warnings, func(a, b int) bool { return warnings[a].Index < warnings[b].Index })
+	return sizes, errs, warnings
+}
+
+// ingestBatches splits events into batches of at most batchSize events and maxIngestBatchBytes.
+func ingestBatches(events []map[string]interface{}, sizes []int, batchSize int) [][]map[string]interface{} {
+	var batches [][]map[string]interface{}
+	start, bytes := 0, 2
+	for i := range events {
+		if i > start && (i-start >= batchSize || bytes+sizes[i]+1 > maxIngestBatchBytes) {
+			batches = append(batches, events[start:i])
+			start, bytes = i, 2
+		}
+		bytes += sizes[i] + 1
+	}
+	if start < len(events) {
+		batches = append(batches, events[start:])
+	}
+	return batches
+}
+
+func truncateProblems(problems []eventProblem) []eventProblem {
+	if len(problems) > maxIngestProblems {
+		return problems[:maxIngestProblems]
+	}
+	return problems
+}
+
+func RegisterIngestEventsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"ingest_events",
+		mcp.WithDescription(fmt.Sprintf(`Send JSON events to a data stream, e.g. deployment annotations, synthetic test events or test fixtures.
+Events are validated against the stream schema first: values must match the field types, and new fields are reported as warnings.
+If any event is invalid nothing is sent, unless skipInvalid is set. Events are sent in batches through POST /api/v1/ingest
+with the X-P-Stream and X-P-Log-Source headers. If the stream does not exist Parseable creates it.
+Requires writes to be enabled on the server, except with dryRun.
+
+Limits: at most %d events per call and %s per event.
+
+Returns a JSON object with:
+- stream: data stream name
+- received: number of events given
+- ingested: number of events sent successfully
+- skipped: number of invalid events left out
+- batches: number of ingest requests sent
+- errors: validation problems with the event index, field and message (at most %d)
+- warnings: e.g. fields new to the schema (at most %d)
+- schemaError: why the schema could not be read, in which case types were not checked
+- ingestError: why a batch failed; events after it were not sent
+`, maxIngestEvents, formatByteSize(maxIngestEventBytes), maxIngestProblems, maxIngestProblems)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the data stream to send events to")),
+		mcp.WithArray("events", mcp.Required(), mcp.Description("Events as JSON objects, e.g. [{\"event\": \"deploy\", \"service\": \"payments\", \"version\": \"1.4.2\"}]"),
+			mcp.Items(map[string]interface{}{"type": "object"})),
+		mcp.WithString("logSource", mcp.Description("Optional log source format sent as X-P-Log-Source (default: json)")),
+		mcp.WithNumber("batchSize", mcp.Description(fmt.Sprintf("Optional number of events per ingest request (default: %d)", defaultIngestBatchSize))),
+		mcp.WithBoolean("skipInvalid", mcp.Description("Optional: send the valid events and leave out invalid ones instead of sending nothing (default: false)")),
+		mcp.WithBoolean("dryRun", mcp.Description("Optional: only validate the events (default: false)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		logSource := mcp.ParseString(req, "logSource", "json")
+		batchSize := mcp.ParseInt(req, "batchSize", defaultIngestBatchSize)
+		skipInvalid := mcp.ParseBoolean(req, "skipInvalid", false)
+		dryRun := mcp.ParseBoolean(req, "dryRun", false)
+		rawEvents, _ := req.GetArguments()["events"].([]interface{})
+		if streamName == "" || len(rawEvents) == 0 {
+			slog.Warn("called with missing parameter", "streamName", streamName, "events", len(rawEvents), "tool", "ingest_events")
+			return mcp.NewToolResultError("missing required fields: streamName and events are required"), nil
+		}
+		if len(rawEvents) > maxIngestEvents {
+			return mcp.NewToolResultError(fmt.Sprintf("%d events given, at most %d are accepted per call", len(rawEvents), maxIngestEvents)), nil
+		}
+		if batchSize <= 0 {
+			batchSize = defaultIngestBatchSize
+		}
+		events := make([]map[string]interface{}, 0, len(rawEvents))
+		for i, raw := range rawEvents {
+			event, ok := raw.(map[string]interface{})
+			if !ok {
+				return mcp.NewToolResultError(fmt.Sprintf("event %d is not a JSON object", i)), nil
+			}
+			events = append(events, event)
+		}
+
+		result := map[string]interface{}{
+			"stream":   streamName,
+			"received": len(events),
+			"ingested": 0,
+			"skipped":  0,
+			"batches":  0,
+		}
+		var fieldTypes map[string]string
+		if schema, err := getParseableSchema(ctx, streamName); err != nil {
+			result["schemaError"] = err.Error()
+		} else {
+			fieldTypes = redact.SchemaFieldTypes(schema)
+		}
+		sizes, errs, warnings := validateEvents(events, fieldTypes)
+		result["errors"] = truncateProblems(errs)
+		result["warnings"] = truncateProblems(warnings)
+
+		if len(errs) > 0 {
+			if !skipInvalid {
+				result["ingestError"] = fmt.Sprintf("%d validation errors, nothing was sent; fix the events or set skipInvalid", len(errs))
+				return mcp.NewToolResultJSON(result)
+			}
+			invalid := map[int]bool{}
+			for _, problem := range errs {
+				invalid[problem.Index] = true
+			}
+			valid := make([]map[string]interface{}, 0, len(events))
+			validSizes := make([]int, 0, len(events))
+			for i, event := range events {
+				if !invalid[i] {
+					valid = append(valid, event)
+					validSizes = append(validSizes, sizes[i])
+				}
+			}
+			result["skipped"] = len(events) - len(valid)
+			events, sizes = valid, validSizes
+		}
+		if dryRun || len(events) == 0 {
+			return mcp.NewToolResultJSON(result)
+		}
+		if !WritesEnabled {
+			return writesDisabled("ingest_events"), nil
+		}
+
+		headers := map[string]string{
+			"X-P-Stream":     streamName,
+			"X-P-Log-Source": logSource,
+		}
+		ingested := 0
+		for i, batch := range ingestBatches(events, sizes, batchSize) {
+			if err := doParseableRequestWithHeaders(ctx, "POST", parseableIngestPath, headers, batch, nil); err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "batch", i, "error", err, "tool", "ingest_events")
+				if ingested == 0 {
+					return mcp.NewToolResultError("failed to ingest events: " + err.Error()), nil
+				}
+				result["ingestError"] = fmt.Sprintf("batch %d failed: %s", i+1, err)
+				break
+			}
+			ingested += len(batch)
+			result["batches"] = i + 1
+		}
+		result["ingested"] = ingested
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -42,7 +42,7 @@ All metrics are calculated at the time of the API call and reflect the current s
 			return mcp.NewToolResultError("missing required field: streamName"), nil
 		}
 
-		stats, err := getParseableStats(streamName)
+		stats, err := getParseableStats(ctx, streamName)
 		if err != nil {
 			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_data_stream_stats")
 			return mcp.NewToolResultError("failed to get stats: " + err.Error()), nil
@@ -17,7 +17,7 @@ func RegisterListDataStreamsTool(mcpServer *server.MCPServer) {
 			"Returns a JSON object with a 'streams' array containing stream objects with metadata (including 'name' field for the stream name) and 'count' (number of streams). "+
 			"All returned streams are accessible and queryable by the current user."),
 	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		streams, err := listParseableStreams()
+		streams, err := listParseableStreams(ctx)
 		if err != nil {
 			slog.Error("failed to get response", "error", err, "tool", "get_data_streams")
 			return mcp.NewToolResultError(err.Error()), nil
@@ -0,0 +1,354 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// Maximum number of rows read by a metric query, one per step and underlying series
+	maxMetricRows = 100000
+	// Maximum number of metric names suggested when a metric is not found
+	maxMetricSuggestions = 20
+)
+
+var histogramValues = []string{"avg", "count", "sum"}
+
+// metricInfo describes a metric from its data points.
+type metricInfo struct {
+	Name        string `json:"metric"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	Monotonic   bool   `json:"monotonic"`
+	Delta       bool   `json:"delta"`
+}
+
+func (m metricInfo) isHistogram() bool {
+	t := strings.ToLower(m.Type)
+	return strings.Contains(t, "histogram") || strings.Contains(t, "summary")
+}
+
+// isCounter reports whether the values of the metric only go up, so that its rate is what is
+// of interest: monotonic sums, and the count and sum of histograms.
+func (m metricInfo) isCounter() bool {
+	return m.isHistogram() || (m.Monotonic && strings.Contains(strings.ToLower(m.Type), "sum"))
+}
+
+// lookupMetric reads the type, unit and temporality of a metric from its data points in the
+// time range. If it has none, the error suggests metric names containing the name.
+func lookupMetric(ctx context.Context, stream string, f metricFields, name string, startTime string, endTime string) (metricInfo, error) {
+	info := metricInfo{Name: name}
+	column := func(field string, alias string) string {
+		if field == "" {
+			return "NULL AS " + alias
+		}
+		return quoteIdent(field) + " AS " + alias
+	}
+	query := fmt.Sprintf("SELECT %s, %s, %s, %s, %s, COUNT(*) AS points FROM %s WHERE %s = %s GROUP BY 1, 2, 3, 4, 5 ORDER BY points DESC LIMIT 1",
+		column(f.Type, "type"), column(f.Unit, "unit"), column(f.Description, "description"),
+		column(f.Monotonic, "monotonic"), column(f.Temporality, "temporality"),
+		quoteIdent(stream), quoteIdent(f.Name), quoteLiteral(name))
+	rows, err := doParseableQuery(ctx, query, stream, startTime, endTime)
+	if err != nil {
+		return info, fmt.Errorf("query failed: %w", err)
+	}
+	if len(rows) == 0 {
+		suggestions := []string{}
+		query := fmt.Sprintf("SELECT DISTINCT %s AS name FROM %s WHERE %s ILIKE %s ORDER BY name LIMIT %d",
+			quoteIdent(f.Name), quoteIdent(stream), quoteIdent(f.Name), quoteLiteral("%"+name+"%"), maxMetricSuggestions)
+		if similar, err := doParseableQuery(ctx, query, stream, startTime, endTime); err == nil {
+			for _, row := range similar {
+				suggestions = append(suggestions, stringValue(row["name"]))
+			}
+		}
+		if len(suggestions) == 0 {
+			return info, fmt.Errorf("metric %q has no data points in %s between %s and %s", name, stream, startTime, endTime)
+		}
+		return info, fmt.Errorf("metric %q has no data points in %s between %s and %s; similar metrics: %s",
+			name, stream, startTime, endTime, strings.Join(suggestions, ", "))
+	}
+	row := rows[0]
+	info.Type = stringValue(row["type"])
+	info.Unit = stringValue(row["unit"])
+	info.Description = stringValue(row["description"])
+	switch m := row["monotonic"].(type) {
+	case bool:
+		info.Monotonic = m
+	case string:
+		info.Monotonic = strings.EqualFold(m, "true")
+	}
+	// AGGREGATION_TEMPORALITY_DELTA, or 1 for delta in the OpenTelemetry enum
+	switch t := row["temporality"].(type) {
+	case string:
+		info.Delta = strings.Contains(strings.ToLower(t), "delta")
+	case float64:
+		info.Delta = t == 1
+	}
+	return info, nil
+}
+
+// metricQuery selects the values of a metric, reduced to one value per step and underlying
+// series, split by the groupBy labels.
+type metricQuery struct {
+	Metric   string
+	Matchers []labelMatcher
+	GroupBy  []string
+	Column   string
+	// SQL aggregate reducing the values of a series within a step, e.g. MAX for the last value
+	// of a cumulative counter
+	Reduce string
+	Step   time.Duration
+}
+
+func (f metricFields) seriesQuery(stream string, q metricQuery) string {
+	columns := []string{f.bucketExpr(q.Step) + " AS bucket"}
+	groupBy := []string{"bucket"}
+	for _, label := range q.GroupBy {
+		columns = append(columns, quoteIdent(label))
+		groupBy = append(groupBy, quoteIdent(label))
+	}
+	columns = append(columns, f.seriesExpr()+" AS series", fmt.Sprintf("%s(%s) AS value", q.Reduce, quoteIdent(q.Column)))
+	groupBy = append(groupBy, "series")
+
+	conditions := []string{quoteIdent(f.Name) + " = " + quoteLiteral(q.Metric), quoteIdent(q.Column) + " IS NOT NULL"}
+	for _, m := range q.Matchers {
+		conditions = append(conditions, m.sql())
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s GROUP BY %s ORDER BY bucket LIMIT %d",
+		strings.Join(columns, ", "), quoteIdent(stream), strings.Join(conditions, " AND "), strings.Join(groupBy, ", "), maxMetricRows+1)
+}
+
+// runMetricQuery runs a series query and computes the series: rates of counters if rate is
+// set, else the per step values aggregated across the underlying series.
+func runMetricQuery(ctx context.Context, stream string, f metricFields, q metricQuery, info metricInfo, rate bool, increase bool,
+	aggregation string, startTime string, endTime string) ([]metricSeries, string, bool, error) {
+	q.Reduce = "AVG"
+	if rate {
+		q.Reduce = "MAX"
+		if info.Delta {
+			q.Reduce = "SUM"
+		}
+	}
+	query := f.seriesQuery(stream, q)
+	rows, err := doParseableQuery(ctx, query, stream, startTime, endTime)
+	if err != nil {
+		return nil, query, false, fmt.Errorf("query failed: %w", err)
+	}
+	truncated := len(rows) > maxMetricRows
+	if truncated {
+		rows = rows[:maxMetricRows]
+	}
+	if rate {
+		return rateSeries(rows, q.GroupBy, !info.Delta, q.Step, aggregation, increase), query, truncated, nil
+	}
+	return groupedSeries(rows, q.GroupBy, aggregation), query, truncated, nil
+}
+
+// divideSeries divides the points of series by the points of the series with the same labels
+// and time in divisors, dropping points without a non-zero divisor.
+func divideSeries(series []metricSeries, divisors []metricSeries) []metricSeries {
+	byKey := map[string]map[time.Time]float64{}
+	for _, d := range divisors {
+		points := map[time.Time]float64{}
+		for _, p := range d.Points {
+			points[p.Time] = p.Value
+		}
+		byKey[seriesKey(d.Labels)] = points
+	}
+	result := make([]metricSeries, 0, len(series))
+	for _, s := range series {
+		divided := metricSeries{Labels: s.Labels, Points: []metricPoint{}}
+		for _, p := range s.Points {
+			if d, ok := byKey[seriesKey(s.Labels)][p.Time]; ok && d != 0 {
+				divided.Points = append(divided.Points, metricPoint{Time: p.Time, Value: p.Value / d})
+			}
+		}
+		if len(divided.Points) > 0 {
+			result = append(result, divided)
+		}
+	}
+	return result
+}
+
+// metricStreamFields checks that a stream holds metrics and returns its metric fields.
+func metricStreamFields(ctx context.Context, stream string) (metricFields, error) {
+	types, err := telemetryStreamFields(ctx, stream, "metrics")
+	if err != nil {
+		return metricFields{}, err
+	}
+	return resolveMetricFields(types)
+}
+
+// parseRange parses the RFC 3339 range returned by timeRangeArguments.
+func parseRange(startTime string, endTime string) (time.Time, time.Time) {
+	start, _ := time.Parse(time.RFC3339, startTime)
+	end, _ := time.Parse(time.RFC3339, endTime)
+	return start, end
+}
+
+func RegisterQueryMetricTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(fmt.Sprintf(`Query a metric of an OpenTelemetry metrics stream (telemetryType "metrics") as time series, without writing SQL.
+The SQL is generated for the metric type: gauges are averaged per step, counters (monotonic sums) are turned into per second
+rates handling counter resets and delta or cumulative temporality, and histograms give the average (sum / count), or the rate
+of their count or sum. Values of the series within a step are combined with 'aggregation' per group of groupBy labels.
+
+Returns a JSON object with:
+- metric, type, unit, description, monotonic, delta: the metric
+- rate: whether values are per second rates; aggregation; step
+- series: per combination of groupBy labels its 'labels' and 'points' as [time, value] pairs, at the start of each step
+- count: number of series (at most %d); truncated: true if series or data points were left out
+- query: the generated SQL
+`, maxMetricSeries)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the metrics stream")),
+		mcp.WithString("metric", mcp.Required(), mcp.Description("Metric name, e.g. 'http.server.request.duration'")),
+		mcp.WithArray("filters", mcp.WithStringItems(), mcp.Description(`Optional label filters as in PromQL, e.g. ['service.name="payments"', 'http.response.status_code=~"5.."']`)),
+		mcp.WithArray("groupBy", mcp.WithStringItems(), mcp.Description("Optional labels to split series by, e.g. ['service.name']")),
+		mcp.WithString("aggregation", mcp.Enum(metricAggregations...), mcp.Description("Optional aggregation across series (default: sum for counters, avg otherwise)")),
+		mcp.WithBoolean("rate", mcp.Description("Optional: per second rate instead of the value (default: true for counters and histograms)")),
+		mcp.WithString("histogramValue", mcp.Enum(histogramValues...), mcp.Description("Optional value of histograms: avg (sum / count), count or sum (default: avg)")),
+		mcp.WithString("step", mcp.Description("Optional step between points, e.g. '1m' (default: about 100 points over the time range)")),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("query_metric", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		metric := mcp.ParseString(req, "metric", "")
+		groupBy := req.GetStringSlice("groupBy", nil)
+		histogramValue := mcp.ParseString(req, "histogramValue", "avg")
+		if streamName == "" || metric == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "metric", metric, "tool", "query_metric")
+			return mcp.NewToolResultError("missing required fields: streamName and metric are required"), nil
+		}
+		if !slices.Contains(histogramValues, histogramValue) {
+			return mcp.NewToolResultError("unsupported histogramValue: " + histogramValue + " (supported: " + strings.Join(histogramValues, ", ") + ")"), nil
+		}
+		var matchers []labelMatcher
+		for _, filter := range req.GetStringSlice("filters", nil) {
+			m, err := parseLabelMatcher(filter)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			matchers = append(matchers, m)
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		start, end := parseRange(startTime, endTime)
+		step, err := metricStep(mcp.ParseString(req, "step", ""), start, end)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		f, err := metricStreamFields(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "query_metric")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		labels := slices.Clone(groupBy)
+		for _, m := range matchers {
+			labels = append(labels, m.Label)
+		}
+		if err := f.checkLabels(labels); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		recordQueryLookback(streamName, startTime)
+		info, err := lookupMetric(ctx, streamName, f, metric, startTime, endTime)
+		if err != nil {
+			slog.Warn("metric not found", "streamName", streamName, "metric", metric, "error", err, "tool", "query_metric")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		rate := mcp.ParseBoolean(req, "rate", info.isCounter())
+		aggregation := mcp.ParseString(req, "aggregation", "")
+		if aggregation == "" {
+			aggregation = "avg"
+			if rate {
+				aggregation = "sum"
+			}
+		}
+		if !slices.Contains(metricAggregations, aggregation) {
+			return mcp.NewToolResultError("unsupported aggregation: " + aggregation + " (supported: " + strings.Join(metricAggregations, ", ") + ")"), nil
+		}
+
+		q := metricQuery{Metric: metric, Matchers: matchers, GroupBy: groupBy, Step: step, Column: f.Value}
+		var series []metricSeries
+		var query string
+		var truncated bool
+		switch {
+		case info.isHistogram() && histogramValue == "avg":
+			// The average of a histogram over a step is the increase of its sum divided by the
+			// increase of its count.
+			if f.Sum == "" || f.Count == "" {
+				return mcp.NewToolResultError("the stream has no data_point_sum and data_point_count fields for histograms"), nil
+			}
+			q.Column = f.Sum
+			sums, sumQuery, sumTruncated, err := runMetricQuery(ctx, streamName, f, q, info, true, true, "sum", startTime, endTime)
+			if err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "query_metric", "query", sumQuery)
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			q.Column = f.Count
+			counts, countQuery, countTruncated, err := runMetricQuery(ctx, streamName, f, q, info, true, true, "sum", startTime, endTime)
+			if err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "query_metric", "query", countQuery)
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			series, query, truncated = divideSeries(sums, counts), sumQuery, sumTruncated || countTruncated
+			rate, aggregation = false, "avg"
+		default:
+			if info.isHistogram() {
+				q.Column = f.Count
+				if histogramValue == "sum" {
+					q.Column = f.Sum
+				}
+			}
+			if q.Column == "" {
+				return mcp.NewToolResultError(fmt.Sprintf("the stream has no value field for %s metrics", info.Type)), nil
+			}
+			series, query, truncated, err = runMetricQuery(ctx, streamName, f, q, info, rate, false, aggregation, startTime, endTime)
+			if err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "query_metric", "query", query)
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+		if len(series) > maxMetricSeries {
+			series, truncated = series[:maxMetricSeries], true
+		}
+		report, err := redactSeriesLabels(ctx, streamName, series)
+		if err != nil {
+			slog.Error("failed to redact series", "streamName", streamName, "error", err, "tool", "query_metric")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		result := map[string]interface{}{
+			"metric":      info.Name,
+			"type":        info.Type,
+			"unit":        info.Unit,
+			"description": info.Description,
+			"monotonic":   info.Monotonic,
+			"delta":       info.Delta,
+			"rate":        rate,
+			"aggregation": aggregation,
+			"step":        step.String(),
+			"startTime":   startTime,
+			"endTime":     endTime,
+			"series":      series,
+			"count":       len(series),
+			"truncated":   truncated,
+			"query":       query,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultPatternSample = 5000
+	maxPatternSample     = 20000
+	defaultPatterns      = 20
+	maxPatterns          = 100
+	// Drain parameters: depth of the parse tree (routing by the first token), similarity to join
+	// a cluster, children per node
+	drainDepth             = 3
+	defaultDrainSimilarity = 0.4
+	drainMaxChildren       = 100
+	patternExamples        = 3
+)
+
+// logPattern is a message template found by cluster_log_patterns.
+type logPattern struct {
+	Pattern   string   `json:"pattern"`
+	Count     int      `json:"count"`
+	Percent   float64  `json:"percent"`
+	Examples  []string `json:"examples"`
+	FirstSeen string   `json:"firstSeen,omitempty"`
+	LastSeen  string   `json:"lastSeen,omitempty"`
+}
+
+// clusterMessages clusters the messages in the field of rows and returns the patterns by
+// descending count, and the number of messages clustered.
+func clusterMessages(rows []map[string]interface{}, field string, similarity float64) ([]logPattern, int) {
+	d := newDrain(drainDepth, similarity, drainMaxChildren, patternExamples)
+	messages := 0
+	for _, row := range rows {
+		message := strings.TrimSpace(stringValue(row[field]))
+		if message == "" {
+			continue
+		}
+		var ts time.Time
+		if s, ok := row["p_timestamp"].(string); ok {
+			ts, _ = parseTimestamp(s)
+		}
+		d.add(message, ts)
+		messages++
+	}
+	patterns := make([]logPattern, 0, len(d.clusters))
+	for _, c := range d.clusters {
+		p := logPattern{
+			Pattern:  strings.Join(c.template, " "),
+			Count:    c.count,
+			Percent:  roundTo(100*float64(c.count)/float64(messages), 2),
+			Examples: make([]string, 0, len(c.examples)),
+		}
+		for _, example := range c.examples {
+			p.Examples = append(p.Examples, truncateValue(example))
+		}
+		if !c.firstSeen.IsZero() {
+			p.FirstSeen = c.firstSeen.UTC().Format(time.RFC3339)
+			p.LastSeen = c.lastSeen.UTC().Format(time.RFC3339)
+		}
+		patterns = append(patterns, p)
+	}
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].Count > patterns[j].Count })
+	return patterns, messages
+}
+
+func RegisterClusterLogPatternsTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(fmt.Sprintf(`Find the common message patterns of a stream by clustering log lines into templates.
+Samples the most recent messages of a field (default: body) and groups them with the Drain algorithm: variable parts such as
+numbers, ids, IP addresses and timestamps become <*>, e.g. "Connection to <*> timed out after <*>".
+Use it to see which kinds of messages (or errors, with a filter) dominate instead of reading raw rows.
+
+Returns a JSON object with:
+- patterns: the top patterns with their count and share of the sample, up to %d example lines, and firstSeen and lastSeen
+- count: number of patterns returned; totalPatterns: number of patterns found in the sample
+- sampled: number of messages clustered; sampleLimitReached: true if the stream had more messages than the sample size
+- query: the SQL used to sample the messages
+`, patternExamples)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the stream")),
+		mcp.WithString("field", mcp.Description("Optional field holding the messages (default: body)")),
+		mcp.WithString("filter", mcp.Description(`Optional SQL condition on the rows to sample, e.g. "severity_text" = 'ERROR' or body ILIKE '%error%'`)),
+		mcp.WithNumber("sampleSize", mcp.Description(fmt.Sprintf("Optional number of most recent messages to cluster (default: %d, at most %d)", defaultPatternSample, maxPatternSample))),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional number of patterns to return (default: %d, at most %d)", defaultPatterns, maxPatterns))),
+		mcp.WithNumber("similarity", mcp.Description(fmt.Sprintf("Optional similarity between 0 and 1 for a message to join a pattern; lower gives fewer, more general patterns (default: %g)", defaultDrainSimilarity))),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("cluster_log_patterns", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		field := mcp.ParseString(req, "field", "body")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "tool", "cluster_log_patterns")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		sampleSize := mcp.ParseInt(req, "sampleSize", defaultPatternSample)
+		if sampleSize <= 0 {
+			sampleSize = defaultPatternSample
+		}
+		sampleSize = min(sampleSize, maxPatternSample)
+		limit := mcp.ParseInt(req, "limit", defaultPatterns)
+		if limit <= 0 {
+			limit = defaultPatterns
+		}
+		limit = min(limit, maxPatterns)
+		similarity := mcp.ParseFloat64(req, "similarity", defaultDrainSimilarity)
+		if similarity <= 0 || similarity > 1 {
+			return mcp.NewToolResultError("similarity must be between 0 and 1"), nil
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		conditions := []string{quoteIdent(field) + " IS NOT NULL"}
+		if filter := mcp.ParseString(req, "filter", ""); filter != "" {
+			conditions = append(conditions, "("+filter+")")
+		}
+		query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s ORDER BY %s DESC LIMIT %d",
+			quoteIdent("p_timestamp"), quoteIdent(field), quoteIdent(streamName), strings.Join(conditions, " AND "),
+			quoteIdent("p_timestamp"), sampleSize)
+		if err := checkMaskedReferences(ctx, streamName, query, nil); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		recordQueryLookback(streamName, startTime)
+		rows, err := doParseableQuery(ctx, query, streamName, startTime, endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "cluster_log_patterns", "query", query)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+		// Redact before clustering, so that neither patterns nor examples hold redacted values
+		report, err := redactQueryRows(ctx, streamName, rows)
+		if err != nil {
+			slog.Error("failed to redact rows", "streamName", streamName, "error", err, "tool", "cluster_log_patterns")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		patterns, sampled := clusterMessages(rows, field, similarity)
+		totalPatterns := len(patterns)
+		if len(patterns) > limit {
+			patterns = patterns[:limit]
+		}
+		result := map[string]interface{}{
+			"patterns":           patterns,
+			"count":              len(patterns),
+			"totalPatterns":      totalPatterns,
+			"sampled":            sampled,
+			"sampleLimitReached": len(rows) == sampleSize,
+			"field":              field,
+			"query":              query,
+			"startTime":          startTime,
+			"endTime":            endTime,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
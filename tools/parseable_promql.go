@@ -0,0 +1,622 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// promSeriesLabel keys intermediate series by the series column of their rows, so that rates
+// are computed per underlying series before they are aggregated.
+const promSeriesLabel = "series"
+
+// promMetric is the metric a selector resolves to: the data point column holding its values,
+// or buckets for histograms whose buckets are selected.
+type promMetric struct {
+	info    metricInfo
+	column  string
+	buckets bool
+}
+
+// promEvaluator evaluates a parsed PromQL expression over a metrics stream, one SQL query per
+// selector, computing rates, aggregations and quantiles of the series in Go.
+type promEvaluator struct {
+	ctx       context.Context
+	stream    string
+	f         metricFields
+	startTime string
+	endTime   string
+	step      time.Duration
+	metrics   map[string]promMetric
+	queries   []string
+	truncated bool
+}
+
+// metric resolves the metric name of a selector. Prometheus names of histogram series such as
+// x_bucket, x_count and x_sum select the buckets, count and sum of the OpenTelemetry histogram x.
+func (e *promEvaluator) metric(name string) (promMetric, error) {
+	if m, ok := e.metrics[name]; ok {
+		return m, nil
+	}
+	info, err := lookupMetric(e.ctx, e.stream, e.f, name, e.startTime, e.endTime)
+	m := promMetric{info: info, column: e.f.Value, buckets: info.isHistogram()}
+	if err != nil {
+		for suffix, column := range map[string]string{"_bucket": "", "_count": e.f.Count, "_sum": e.f.Sum} {
+			base, ok := strings.CutSuffix(name, suffix)
+			if !ok {
+				continue
+			}
+			if baseInfo, baseErr := lookupMetric(e.ctx, e.stream, e.f, base, e.startTime, e.endTime); baseErr == nil && baseInfo.isHistogram() {
+				m, err = promMetric{info: baseInfo, column: column, buckets: suffix == "_bucket"}, nil
+			}
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	if m.buckets {
+		m.column = ""
+	}
+	e.metrics[name] = m
+	return m, nil
+}
+
+// labels returns the labels to read for the labels needed by the enclosing expression, all
+// labels if needed is nil. The le label of histograms is not a field of the stream.
+func (e *promEvaluator) labels(needed []string, matchers []labelMatcher) ([]string, error) {
+	labels := []string{}
+	if needed == nil {
+		labels = e.f.labels()
+	}
+	for _, label := range needed {
+		if label != "le" && label != "__name__" && !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	check := slices.Clone(labels)
+	for _, m := range matchers {
+		check = append(check, m.Label)
+	}
+	return labels, e.f.checkLabels(check)
+}
+
+func (e *promEvaluator) query(query string) ([]map[string]interface{}, error) {
+	e.queries = append(e.queries, query)
+	rows, err := doParseableQuery(e.ctx, query, e.stream, e.startTime, e.endTime)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if len(rows) > maxMetricRows {
+		rows, e.truncated = rows[:maxMetricRows], true
+	}
+	return rows, nil
+}
+
+// rows selects the values of a metric per step and underlying series.
+func (e *promEvaluator) rows(sel promSelector, m promMetric, labels []string, reduce string) ([]map[string]interface{}, error) {
+	if m.column == "" {
+		return nil, fmt.Errorf("the stream has no value field for %s", sel.Metric)
+	}
+	return e.query(e.f.seriesQuery(e.stream, metricQuery{
+		Metric: m.info.Name, Matchers: sel.Matchers, GroupBy: labels, Column: m.column, Reduce: reduce, Step: e.step,
+	}))
+}
+
+// eval evaluates expr to series that have the needed labels, or all labels if needed is nil.
+func (e *promEvaluator) eval(expr promExpr, needed []string) ([]metricSeries, error) {
+	switch x := expr.(type) {
+	case promNumber:
+		return nil, unsupportedPromQL("number %g outside of histogram_quantile", x.Value)
+	case promSelector:
+		if x.Range > 0 {
+			return nil, fmt.Errorf("range selector %s[%s] must be used in rate, irate or increase", x.Metric, x.Range)
+		}
+		m, err := e.metric(x.Metric)
+		if err != nil {
+			return nil, err
+		}
+		if m.buckets {
+			return nil, fmt.Errorf("%s is a histogram; select %s_count or %s_sum, or use histogram_quantile", x.Metric, m.info.Name, m.info.Name)
+		}
+		labels, err := e.labels(needed, x.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		// The last value of cumulative counters, as Prometheus takes the last sample
+		reduce := "AVG"
+		if m.info.isCounter() && !m.info.Delta {
+			reduce = "MAX"
+		}
+		rows, err := e.rows(x, m, labels, reduce)
+		if err != nil {
+			return nil, err
+		}
+		series := groupedSeries(rows, append(labels, promSeriesLabel), "avg")
+		for i := range series {
+			series[i].Labels["__name__"] = x.Metric
+		}
+		return series, nil
+	case promCall:
+		if x.Func == "histogram_quantile" {
+			return e.histogramQuantile(x)
+		}
+		return e.rate(x, needed)
+	case promAggregation:
+		by := x.By
+		if by == nil {
+			by = []string{}
+		}
+		series, err := e.eval(x.Expr, by)
+		if err != nil {
+			return nil, err
+		}
+		return aggregateSeriesBy(series, by, x.Op), nil
+	}
+	return nil, fmt.Errorf("unexpected expression %T", expr)
+}
+
+// rangeArgument returns the range selector that is the only argument of a rate function.
+func rangeArgument(call promCall) (promSelector, error) {
+	if len(call.Args) == 1 {
+		if sel, ok := call.Args[0].(promSelector); ok && sel.Range > 0 {
+			return sel, nil
+		}
+	}
+	return promSelector{}, fmt.Errorf("%s() expects one range selector, e.g. %s(http_requests_total[5m])", call.Func, call.Func)
+}
+
+// rate evaluates rate, irate and increase. Rates are computed from the values at each step
+// and summed over the range in whole steps.
+func (e *promEvaluator) rate(call promCall, needed []string) ([]metricSeries, error) {
+	sel, err := rangeArgument(call)
+	if err != nil {
+		return nil, err
+	}
+	m, err := e.metric(sel.Metric)
+	if err != nil {
+		return nil, err
+	}
+	if m.buckets {
+		return nil, fmt.Errorf("%s() of the histogram %s is only supported in histogram_quantile; use %s_count or %s_sum", call.Func, sel.Metric, m.info.Name, m.info.Name)
+	}
+	labels, err := e.labels(needed, sel.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	reduce := "MAX"
+	if m.info.Delta {
+		reduce = "SUM"
+	}
+	rows, err := e.rows(sel, m, labels, reduce)
+	if err != nil {
+		return nil, err
+	}
+	groupBy := append(labels, promSeriesLabel)
+	if call.Func == "irate" {
+		return rateSeries(rows, groupBy, !m.info.Delta, e.step, "sum", false), nil
+	}
+	increases := rateSeries(rows, groupBy, !m.info.Delta, e.step, "sum", true)
+	return windowSeries(increases, sel.Range, e.step, call.Func == "rate"), nil
+}
+
+// windowSteps is the number of steps a range covers, at least one.
+func windowSteps(window time.Duration, step time.Duration) int {
+	return max(1, int(window/step))
+}
+
+// windowSeries sums the increases per step of each series over the window before each point,
+// divided by the window in seconds if perSecond is set.
+func windowSeries(series []metricSeries, window time.Duration, step time.Duration, perSecond bool) []metricSeries {
+	span := time.Duration(windowSteps(window, step)) * step
+	for i, s := range series {
+		points := make([]metricPoint, len(s.Points))
+		for j, p := range s.Points {
+			var sum float64
+			for k := j; k >= 0 && s.Points[k].Time.After(p.Time.Add(-span)); k-- {
+				sum += s.Points[k].Value
+			}
+			if perSecond {
+				sum /= span.Seconds()
+			}
+			points[j] = metricPoint{Time: p.Time, Value: sum}
+		}
+		series[i].Points = points
+	}
+	return series
+}
+
+// aggregateSeriesBy aggregates the points of series with the same values of the by labels.
+func aggregateSeriesBy(series []metricSeries, by []string, op string) []metricSeries {
+	type group struct {
+		labels map[string]string
+		values map[time.Time][]float64
+	}
+	groups := map[string]*group{}
+	for _, s := range series {
+		labels := make(map[string]string, len(by))
+		for _, label := range by {
+			labels[label] = s.Labels[label]
+		}
+		key := seriesKey(labels)
+		if groups[key] == nil {
+			groups[key] = &group{labels: labels, values: map[time.Time][]float64{}}
+		}
+		for _, p := range s.Points {
+			groups[key].values[p.Time] = append(groups[key].values[p.Time], p.Value)
+		}
+	}
+	bySeries := map[string]*metricSeries{}
+	for key, g := range groups {
+		s := &metricSeries{Labels: g.labels}
+		for t, values := range g.values {
+			s.Points = append(s.Points, metricPoint{Time: t, Value: aggregateValues(values, op)})
+		}
+		bySeries[key] = s
+	}
+	return sortedSeries(bySeries)
+}
+
+// histogramBuckets are the bucket counts of a histogram and the upper bounds of all buckets but
+// the last, as in OpenTelemetry data points.
+type histogramBuckets struct {
+	bounds []float64
+	counts []float64
+}
+
+// add adds the counts of o, if it has the same buckets.
+func (h *histogramBuckets) add(o histogramBuckets) bool {
+	if h.counts == nil {
+		h.bounds, h.counts = o.bounds, slices.Clone(o.counts)
+		return true
+	}
+	if !slices.Equal(h.bounds, o.bounds) {
+		return false
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	return true
+}
+
+// quantile estimates the q-quantile by linear interpolation within the bucket it falls in, as
+// histogram_quantile does in Prometheus. It is NaN for an empty histogram.
+func (h histogramBuckets) quantile(q float64) float64 {
+	var total float64
+	for _, c := range h.counts {
+		total += c
+	}
+	if total == 0 || len(h.bounds) == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	var seen float64
+	for i, c := range h.counts {
+		if c <= 0 || seen+c < rank {
+			seen += c
+			continue
+		}
+		if i >= len(h.bounds) {
+			// The +Inf bucket: the upper bound of the highest finite bucket
+			return h.bounds[len(h.bounds)-1]
+		}
+		upper := h.bounds[i]
+		lower := 0.0
+		if i > 0 {
+			lower = h.bounds[i-1]
+		} else if upper <= 0 {
+			return upper
+		}
+		return lower + (upper-lower)*(rank-seen)/c
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// floatList reads a list of numbers of a result row, given as a list or a JSON string.
+func floatList(v interface{}) []float64 {
+	var list []interface{}
+	switch l := v.(type) {
+	case []interface{}:
+		list = l
+	case string:
+		if err := json.Unmarshal([]byte(l), &list); err != nil {
+			return nil
+		}
+	}
+	values := make([]float64, 0, len(list))
+	for _, item := range list {
+		switch n := item.(type) {
+		case float64:
+			values = append(values, n)
+		case string:
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return nil
+			}
+			values = append(values, f)
+		default:
+			return nil
+		}
+	}
+	return values
+}
+
+// histogramArgument returns the rate or increase of a histogram selector that histogram_quantile
+// is applied to, and the labels it is grouped by: nil for no aggregation, i.e. per series.
+func histogramArgument(expr promExpr) (promSelector, []string, error) {
+	var by []string
+	if agg, ok := expr.(promAggregation); ok {
+		if agg.Op != "sum" || !slices.Contains(agg.By, "le") {
+			return promSelector{}, nil, unsupportedPromQL("histogram_quantile over %s by (...), expected sum by (le) (rate(x_bucket[5m]))", agg.Op)
+		}
+		by = slices.DeleteFunc(slices.Clone(agg.By), func(label string) bool { return label == "le" })
+		expr = agg.Expr
+	}
+	call, ok := expr.(promCall)
+	if !ok || (call.Func != "rate" && call.Func != "increase") {
+		return promSelector{}, nil, unsupportedPromQL("histogram_quantile over this expression, expected sum by (le) (rate(x_bucket[5m]))")
+	}
+	sel, err := rangeArgument(call)
+	return sel, by, err
+}
+
+// histogramQuantile evaluates histogram_quantile over the bucket counts of histogram data
+// points: their increases per step are summed over the range and across the series of a
+// group, and the quantile is estimated from the summed buckets.
+func (e *promEvaluator) histogramQuantile(call promCall) ([]metricSeries, error) {
+	if len(call.Args) != 2 {
+		return nil, fmt.Errorf("histogram_quantile() expects a quantile and a histogram, e.g. histogram_quantile(0.95, sum by (le) (rate(x_bucket[5m])))")
+	}
+	q, ok := call.Args[0].(promNumber)
+	if !ok || q.Value < 0 || q.Value > 1 {
+		return nil, fmt.Errorf("the quantile of histogram_quantile must be a number between 0 and 1")
+	}
+	sel, by, err := histogramArgument(call.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	m, err := e.metric(sel.Metric)
+	if err != nil {
+		return nil, err
+	}
+	if !m.buckets {
+		return nil, fmt.Errorf("%s is not a histogram (type %s)", sel.Metric, m.info.Type)
+	}
+	if e.f.Buckets == "" || e.f.Bounds == "" {
+		return nil, fmt.Errorf("the stream has no data_point_bucket_counts and data_point_explicit_bounds fields for histogram_quantile")
+	}
+	labels, err := e.labels(by, sel.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{e.f.bucketExpr(e.step) + " AS bucket"}
+	for _, label := range labels {
+		columns = append(columns, quoteIdent(label))
+	}
+	columns = append(columns, e.f.seriesExpr()+" AS series", quoteIdent(e.f.Buckets)+" AS counts", quoteIdent(e.f.Bounds)+" AS bounds")
+	conditions := []string{quoteIdent(e.f.Name) + " = " + quoteLiteral(m.info.Name), quoteIdent(e.f.Buckets) + " IS NOT NULL"}
+	for _, matcher := range sel.Matchers {
+		conditions = append(conditions, matcher.sql())
+	}
+	rows, err := e.query(fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d",
+		strings.Join(columns, ", "), quoteIdent(e.stream), strings.Join(conditions, " AND "), quoteIdent(e.f.Time), maxMetricRows+1))
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets per underlying series and step: the last data point of cumulative histograms, or
+	// the sum of delta data points
+	type underlying struct {
+		labels  map[string]string
+		buckets map[time.Time]*histogramBuckets
+	}
+	underlyingSeries := map[string]*underlying{}
+	for _, row := range rows {
+		bucket, ok := rowBucket(row)
+		h := histogramBuckets{bounds: floatList(row["bounds"]), counts: floatList(row["counts"])}
+		if !ok || h.counts == nil || len(h.counts) != len(h.bounds)+1 {
+			continue
+		}
+		labels := rowLabels(row, labels)
+		key := seriesKey(labels) + "\x00" + stringValue(row[promSeriesLabel])
+		if underlyingSeries[key] == nil {
+			underlyingSeries[key] = &underlying{labels: labels, buckets: map[time.Time]*histogramBuckets{}}
+		}
+		if current := underlyingSeries[key].buckets[bucket]; m.info.Delta && current != nil {
+			current.add(h)
+		} else {
+			underlyingSeries[key].buckets[bucket] = &h
+		}
+	}
+
+	// Increases per step, summed per group
+	type group struct {
+		labels    map[string]string
+		increases map[time.Time]*histogramBuckets
+	}
+	groups := map[string]*group{}
+	for _, u := range underlyingSeries {
+		times := make([]time.Time, 0, len(u.buckets))
+		for t := range u.buckets {
+			times = append(times, t)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		key := seriesKey(u.labels)
+		if groups[key] == nil {
+			groups[key] = &group{labels: u.labels, increases: map[time.Time]*histogramBuckets{}}
+		}
+		for i, t := range times {
+			increase := *u.buckets[t]
+			if !m.info.Delta {
+				if i == 0 {
+					continue
+				}
+				prev := u.buckets[times[i-1]]
+				if slices.Equal(prev.bounds, increase.bounds) {
+					counts := make([]float64, len(increase.counts))
+					for j := range counts {
+						counts[j] = increase.counts[j] - prev.counts[j]
+						if counts[j] < 0 {
+							// Counter reset: the counts since the reset
+							counts = increase.counts
+							break
+						}
+					}
+					increase.counts = counts
+				}
+			}
+			if groups[key].increases[t] == nil {
+				groups[key].increases[t] = &histogramBuckets{}
+			}
+			groups[key].increases[t].add(increase)
+		}
+	}
+
+	steps := windowSteps(sel.Range, e.step)
+	bySeries := map[string]*metricSeries{}
+	for key, g := range groups {
+		s := &metricSeries{Labels: g.labels}
+		for t := range g.increases {
+			var window histogramBuckets
+			for i := 0; i < steps; i++ {
+				if h := g.increases[t.Add(-time.Duration(i)*e.step)]; h != nil {
+					window.add(*h)
+				}
+			}
+			if v := window.quantile(q.Value); !math.IsNaN(v) {
+				s.Points = append(s.Points, metricPoint{Time: t, Value: v})
+			}
+		}
+		if len(s.Points) > 0 {
+			bySeries[key] = s
+		}
+	}
+	return sortedSeries(bySeries), nil
+}
+
+// promMatrix formats series as the result of a Prometheus range query, with label values
+// that are empty or only used internally left out.
+func promMatrix(series []metricSeries) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(series))
+	for _, s := range series {
+		values := make([][]interface{}, 0, len(s.Points))
+		for _, p := range s.Points {
+			values = append(values, []interface{}{p.Time.Unix(), strconv.FormatFloat(p.Value, 'f', -1, 64)})
+		}
+		result = append(result, map[string]interface{}{"metric": s.Labels, "values": values})
+	}
+	return result
+}
+
+// promLabels removes the internal series label and empty labels, which Prometheus does not have.
+func promLabels(series []metricSeries) []metricSeries {
+	for i, s := range series {
+		labels := map[string]string{}
+		for label, value := range s.Labels {
+			if label != promSeriesLabel && value != "" {
+				labels[label] = value
+			}
+		}
+		series[i].Labels = labels
+	}
+	return series
+}
+
+func RegisterPromQLQueryTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(fmt.Sprintf(`Run a PromQL range query over an OpenTelemetry metrics stream (telemetryType "metrics").
+The query is translated to SQL over the metric data points; rates, aggregations and histogram quantiles are computed from the
+values at each step. Supported PromQL: %s.
+Binary operators, offset, @, subqueries, without and other functions are not supported and are reported as such.
+
+Returns a JSON object like the Prometheus query_range API:
+- status: "success"; data: resultType "matrix" and result, a list of series with 'metric' labels and 'values' as [unix time, "value"] pairs
+- count: number of series (at most %d); truncated: true if series or data points were left out
+- sql: the generated SQL queries; step
+`, promQLSupported, maxMetricSeries)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the metrics stream")),
+		mcp.WithString("query", mcp.Required(), mcp.Description(`PromQL query, e.g. 'sum by (service.name) (rate(http.server.request.count[5m]))'`)),
+		mcp.WithString("step", mcp.Description("Optional query resolution step, e.g. '1m' (default: about 100 points over the time range)")),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("promql_query", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		query := mcp.ParseString(req, "query", "")
+		if streamName == "" || query == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "query", query, "tool", "promql_query")
+			return mcp.NewToolResultError("missing required fields: streamName and query are required"), nil
+		}
+		expr, err := parsePromQL(query)
+		if errors.Is(err, errPromQLUnsupported) {
+			return mcp.NewToolResultError(err.Error() + "\nSupported: " + promQLSupported), nil
+		}
+		if err != nil {
+			return mcp.NewToolResultError("invalid PromQL: " + err.Error()), nil
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		start, end := parseRange(startTime, endTime)
+		step, err := metricStep(mcp.ParseString(req, "step", ""), start, end)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		f, err := metricStreamFields(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "promql_query")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		recordQueryLookback(streamName, startTime)
+		e := &promEvaluator{ctx: ctx, stream: streamName, f: f, startTime: startTime, endTime: endTime, step: step, metrics: map[string]promMetric{}}
+		series, err := e.eval(expr, nil)
+		if errors.Is(err, errPromQLUnsupported) {
+			return mcp.NewToolResultError(err.Error() + "\nSupported: " + promQLSupported), nil
+		}
+		if err != nil {
+			slog.Warn("failed to evaluate PromQL", "streamName", streamName, "query", query, "error", err, "tool", "promql_query")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		series = promLabels(series)
+		truncated := e.truncated
+		if len(series) > maxMetricSeries {
+			series, truncated = series[:maxMetricSeries], true
+		}
+		report, err := redactSeriesLabels(ctx, streamName, series)
+		if err != nil {
+			slog.Error("failed to redact series", "streamName", streamName, "error", err, "tool", "promql_query")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		result := map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "matrix",
+				"result":     promMatrix(series),
+			},
+			"query":     query,
+			"sql":       e.queries,
+			"step":      step.String(),
+			"startTime": startTime,
+			"endTime":   endTime,
+			"count":     len(series),
+			"truncated": truncated,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -2,26 +2,35 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
 )
 
 func RegisterQueryDataStreamTool(mcpServer *server.MCPServer) {
-	mcpServer.AddTool(mcp.NewTool(
-		"query_data_stream",
-		mcp.WithDescription("Execute a SQL query against a data stream in Parseable and retrieve rows of data. "+
-			"All parameters are required. "+
-			"Supported SQL operations: SELECT with column selection, WHERE conditions (but not time-based), GROUP BY, ORDER BY, LIMIT, and aggregate functions (COUNT, SUM, AVG, MIN, MAX). "+
-			"Time filtering is handled by startTime and endTime parameters - do not include time conditions in the WHERE clause. "+
-			"The FROM clause table name must exactly match the streamName parameter. "+
-			"Returns a JSON object with 'rows' (array of data objects) and 'count' (number of rows returned)."),
+	options := []mcp.ToolOption{
+		mcp.WithDescription("Execute a SQL query against a data stream in Parseable and retrieve rows of data. " +
+			"All parameters are required. " +
+			"Supported SQL operations: SELECT with column selection, WHERE conditions (but not time-based), GROUP BY, ORDER BY, LIMIT, and aggregate functions (COUNT, SUM, AVG, MIN, MAX). " +
+			"Time filtering is handled by startTime and endTime parameters - do not include time conditions in the WHERE clause. " +
+			"The FROM clause table name must exactly match the streamName parameter. " +
+			"Returns a JSON object with 'rows' (array of data objects) and 'count' (number of rows returned). " +
+			"Use the optional 'format' parameter to get the rows as a markdown table, CSV, NDJSON or compact columnar JSON instead. " +
+			"If redaction is configured, sensitive values and columns in rows are masked, hashed, bucketed or dropped and 'redactions' reports the 'total' count and counts 'byRule'. " +
+			"Fields marked 'masked' in the schema may only be selected, grouped or ordered by as plain columns; queries using them in aliases, expressions or conditions are refused."),
 		mcp.WithString("query", mcp.Required(), mcp.Description("SQL query to execute. FROM clause table must exactly match the streamName parameter. Example: 'SELECT field1, field2 FROM streamName WHERE field1 > 100 ORDER BY timestamp DESC LIMIT 100'")),
 		mcp.WithString("streamName", mcp.Required(), mcp.Description("Exact name of the data stream (table) to query. Must match the table name in the FROM clause. Example: 'monitor_logstream'")),
 		mcp.WithString("startTime", mcp.Required(), mcp.Description("Query start time in ISO 8601 format with timezone. Examples: '2026-02-12T00:00:00Z' or '2026-02-12T00:00:00+00:00'")),
 		mcp.WithString("endTime", mcp.Required(), mcp.Description("Query end time in ISO 8601 format with timezone. Must be after startTime. Examples: '2026-02-12T23:59:59Z' or '2026-02-12T23:59:59+00:00'")),
-	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	}
+	options = append(options, resultOptions()...)
+	mcpServer.AddTool(mcp.NewTool("query_data_stream", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		query := mcp.ParseString(req, "query", "")
 		streamName := mcp.ParseString(req, "streamName", "")
 		startTime := mcp.ParseString(req, "startTime", "")
@@ -34,28 +43,160 @@ func RegisterQueryDataStreamTool(mcpServer *server.MCPServer) {
 				"endTime", endTime)
 			return mcp.NewToolResultError("missing required fields: query, streamName, startTime, and endTime are required"), nil
 		}
+		return runQuery(ctx, req, "query_data_stream", query, streamName, startTime, endTime)
+	})
+}
 
-		slog.Debug("executing query_data_stream",
-			"streamName", streamName,
-			"startTime", startTime,
-			"endTime", endTime,
-			"query", query)
+// resultOptions are the tool options read by runQuery to shape query results.
+func resultOptions() []mcp.ToolOption {
+	return []mcp.ToolOption{
+		mcp.WithString("format",
+			mcp.Enum(resultFormats...),
+			mcp.Description("Optional result format (default: json). "+
+				"'json' returns {rows, count} with one object per row. "+
+				"'markdown' returns a markdown table, readable in chat. "+
+				"'csv' returns CSV with a header row. "+
+				"'ndjson' returns one JSON object per line. "+
+				"'columnar' returns {columns, types, values, count} where values is an array of row arrays; it uses far fewer tokens for wide results. "+
+				"For markdown, csv and ndjson a second text content holds a JSON object with 'count' and, if redaction is configured, 'redactions'. "+
+				"Columns are ordered as in the stream schema and integer columns keep their integer type.")),
+		mcp.WithString("summarize",
+			mcp.Enum(summarizeModes...),
+			mcp.Description("Optional (default: auto). 'auto' returns a digest instead of rows when the result exceeds the server's token budget, "+
+				"'always' always returns a digest and 'never' always returns the rows. "+
+				"The digest is a JSON object with 'summarized': true, 'count', 'estimatedTokens', 'columns' (per column type, nonNull, nullRatio, distinct, topValues, min and max), "+
+				"'columnOrder', 'timeSpan' (field, start and end of the timestamps covered) and 'sample' (rows spread evenly over the result). "+
+				"Use the digest to refine the query with filters, aggregates or a LIMIT.")),
+		mcp.WithNumber("sampleSize", mcp.Description("Optional number of sample rows in a digest (default: 10)")),
+	}
+}
+
+// runQuery runs a query and builds the tool result the way query_data_stream does: rows are
+// redacted, then returned in the requested format or replaced by a digest when they exceed
+// the token budget.
+func runQuery(ctx context.Context, req mcp.CallToolRequest, tool string, query string, streamName string, startTime string, endTime string) (*mcp.CallToolResult, error) {
+	format := mcp.ParseString(req, "format", formatJSON)
+	summarize := mcp.ParseString(req, "summarize", summarizeAuto)
+	sampleSize := mcp.ParseInt(req, "sampleSize", 10)
+	if !slices.Contains(resultFormats, format) {
+		slog.Warn("called with unsupported format", "format", format, "tool", tool)
+		return mcp.NewToolResultError("unsupported format: " + format + " (supported: " + strings.Join(resultFormats, ", ") + ")"), nil
+	}
+	if !slices.Contains(summarizeModes, summarize) {
+		slog.Warn("called with unsupported summarize mode", "summarize", summarize, "tool", tool)
+		return mcp.NewToolResultError("unsupported summarize mode: " + summarize + " (supported: " + strings.Join(summarizeModes, ", ") + ")"), nil
+	}
 
-		queryResult, err := doParseableQuery(query, streamName, startTime, endTime)
+	slog.Debug("executing query",
+		"tool", tool,
+		"streamName", streamName,
+		"startTime", startTime,
+		"endTime", endTime,
+		"query", query)
+
+	// Column rules matching on data type need the stream schema; without it the
+	// rows cannot be masked, so the query is refused rather than leaking data.
+	// Formats and digests use it for column order and types but work without it.
+	var fieldTypes map[string]string
+	var schemaOrder []string
+	schemaLoaded := false
+	loadSchema := func() error {
+		schemaLoaded = true
+		schema, err := getParseableSchema(ctx, streamName)
 		if err != nil {
-			slog.Error("failed to get response",
+			return err
+		}
+		fieldTypes = redact.SchemaFieldTypes(schema)
+		schemaOrder = schemaFieldNames(schema)
+		return nil
+	}
+	if ResultRedactor.NeedsSchema(streamName) {
+		if err := loadSchema(); err != nil {
+			slog.Error("failed to get schema for column masking",
 				"streamName", streamName,
-				"error", err, "tool", "query_data_stream", "query", query)
-			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+				"error", err, "tool", tool)
+			return mcp.NewToolResultError("failed to get schema for column masking: " + err.Error()), nil
 		}
+	}
+
+	if err := checkMaskedReferences(ctx, streamName, query, fieldTypes); err != nil {
+		slog.Warn("query refused", "streamName", streamName, "error", err, "tool", tool, "query", query)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
 
-		slog.Debug("query_data_stream completed successfully",
+	recordQueryLookback(streamName, startTime)
+	queryResult, err := doParseableQuery(ctx, query, streamName, startTime, endTime)
+	if err != nil {
+		slog.Error("failed to get response",
 			"streamName", streamName,
-			"rowCount", len(queryResult))
+			"error", err, "tool", tool, "query", query)
+		return mcp.NewToolResultError("query failed: " + err.Error()), nil
+	}
 
-		return mcp.NewToolResultJSON(map[string]interface{}{
-			"rows":  queryResult,
-			"count": len(queryResult),
-		})
-	})
+	slog.Debug("query completed successfully",
+		"tool", tool,
+		"streamName", streamName,
+		"rowCount", len(queryResult))
+
+	result := map[string]interface{}{
+		"count": len(queryResult),
+	}
+	if ResultRedactor != nil {
+		result["redactions"] = ResultRedactor.Rows(streamName, queryResult, fieldTypes)
+	}
+
+	if digestRows, tokens := shouldSummarize(summarize, queryResult, ResultTokenBudget); digestRows {
+		if !schemaLoaded {
+			if err := loadSchema(); err != nil {
+				slog.Warn("failed to get schema, summarizing without column types",
+					"streamName", streamName,
+					"error", err, "tool", tool)
+			}
+		}
+		if tokens == 0 {
+			tokens = estimateTokens(queryResult)
+		}
+		slog.Debug("returning digest instead of rows",
+			"streamName", streamName,
+			"estimatedTokens", tokens,
+			"tokenBudget", ResultTokenBudget)
+		digest := summarizeRows(queryResult, schemaOrder, fieldTypes, sampleSize)
+		for key, value := range result {
+			digest[key] = value
+		}
+		digest["summarized"] = true
+		digest["estimatedTokens"] = tokens
+		return mcp.NewToolResultJSON(digest)
+	}
+
+	if format == formatJSON {
+		result["rows"] = queryResult
+		return mcp.NewToolResultJSON(result)
+	}
+
+	if !schemaLoaded {
+		if err := loadSchema(); err != nil {
+			slog.Warn("failed to get schema, formatting without column types",
+				"streamName", streamName,
+				"error", err, "tool", tool)
+		}
+	}
+	formatted, err := formatRows(format, queryResult, schemaOrder, fieldTypes)
+	if err != nil {
+		slog.Error("failed to format result", "format", format, "error", err, "tool", tool)
+		return mcp.NewToolResultError("failed to format result: " + err.Error()), nil
+	}
+	if columnar, ok := formatted.(map[string]interface{}); ok {
+		for key, value := range result {
+			columnar[key] = value
+		}
+		return mcp.NewToolResultJSON(columnar)
+	}
+	meta, err := json.Marshal(result)
+	if err != nil {
+		return mcp.NewToolResultError("failed to format result: " + err.Error()), nil
+	}
+	textResult := mcp.NewToolResultText(formatted.(string))
+	textResult.Content = append(textResult.Content, mcp.NewTextContent(string(meta)))
+	return textResult, nil
 }
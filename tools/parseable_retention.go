@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Number of streams read at the same time by get_retention without a stream
+const retentionConcurrency = 4
+
+// RetentionTask is a retention rule of a stream, e.g. delete data older than 30 days.
+type RetentionTask struct {
+	Description string `json:"description"`
+	Action      string `json:"action"`
+	Duration    string `json:"duration"`
+}
+
+func retentionPath(streamName string) string {
+	return "/api/v1/logstream/" + streamName + "/retention"
+}
+
+func getParseableRetention(ctx context.Context, streamName string) ([]RetentionTask, error) {
+	var tasks []RetentionTask
+	if err := doParseableRequest(ctx, "GET", retentionPath(streamName), nil, &tasks); err != nil {
+		return nil, err
+	}
+	if tasks == nil {
+		tasks = []RetentionTask{}
+	}
+	return tasks, nil
+}
+
+// retentionDays returns the days of the delete rule of a retention, if there is one.
+func retentionDays(tasks []RetentionTask) (int, bool) {
+	for _, task := range tasks {
+		if task.Action != "delete" {
+			continue
+		}
+		if d, err := parseHumanDuration(task.Duration); err == nil && d > 0 {
+			return int(d / (24 * time.Hour)), true
+		}
+	}
+	return 0, false
+}
+
+// statNumber reads a number from nested stats such as stats["storage"]["size"]. Depending on
+// the Parseable version sizes are numbers or strings like "1024 Bytes".
+func statNumber(stats map[string]interface{}, keys ...string) (float64, bool) {
+	var v interface{} = stats
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		v = m[key]
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := parseByteSize(n)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// RetentionPreview estimates what a retention of Days days would delete, assuming events are
+// spread evenly between the first and latest event of the stream.
+type RetentionPreview struct {
+	Days          int     `json:"days"`
+	Cutoff        string  `json:"cutoff"`
+	FirstEventAt  string  `json:"firstEventAt,omitempty"`
+	LatestEventAt string  `json:"latestEventAt,omitempty"`
+	StoredBytes   float64 `json:"storedBytes"`
+	StoredEvents  float64 `json:"storedEvents"`
+	// DeletedFraction is the share of the stored time span older than the cutoff.
+	DeletedFraction       float64 `json:"deletedFraction"`
+	EstimatedDeletedBytes float64 `json:"estimatedDeletedBytes"`
+	// EstimatedDeletedEvents is the share of the events ingested in the current stats period,
+	// which is all stored events unless data was already deleted.
+	EstimatedDeletedEvents float64 `json:"estimatedDeletedEvents"`
+	Note                   string  `json:"note"`
+}
+
+func previewRetention(ctx context.Context, streamName string, days int) (*RetentionPreview, error) {
+	stats, err := getParseableStats(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	info, err := getParseableInfo(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info: %w", err)
+	}
+	now := time.Now().UTC()
+	cutoff := now.Add(-time.Duration(days) * 24 * time.Hour)
+	preview := &RetentionPreview{
+		Days:   days,
+		Cutoff: cutoff.Format(time.RFC3339),
+		Note:   "estimate assuming events are spread evenly over time; bursty ingestion makes it less accurate",
+	}
+	preview.StoredBytes, _ = statNumber(stats, "storage", "size")
+	preview.StoredEvents, _ = statNumber(stats, "ingestion", "count")
+
+	first, firstOK := info["firstEventAt"].(string)
+	latest, latestOK := info["latestEventAt"].(string)
+	if !firstOK || !latestOK {
+		preview.Note = "the stream has no events, nothing would be deleted"
+		return preview, nil
+	}
+	preview.FirstEventAt, preview.LatestEventAt = first, latest
+	firstAt, ok1 := parseTimestamp(first)
+	latestAt, ok2 := parseTimestamp(latest)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cannot parse event times %q and %q", first, latest)
+	}
+	switch {
+	case !cutoff.After(firstAt):
+		preview.DeletedFraction = 0
+	case !cutoff.Before(latestAt):
+		preview.DeletedFraction = 1
+	default:
+		preview.DeletedFraction = cutoff.Sub(firstAt).Seconds() / latestAt.Sub(firstAt).Seconds()
+	}
+	preview.DeletedFraction = math.Round(preview.DeletedFraction*1000) / 1000
+	preview.EstimatedDeletedBytes = math.Round(preview.StoredBytes * preview.DeletedFraction)
+	preview.EstimatedDeletedEvents = math.Round(preview.StoredEvents * preview.DeletedFraction)
+	return preview, nil
+}
+
+func RegisterGetRetentionTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_retention",
+		mcp.WithDescription(`Get the retention policy of a data stream, or of every stream for a capacity review.
+Calls /api/v1/logstream/<streamName>/retention, and /api/v1/logstream with the stats of each stream when no stream is given.
+
+For a single stream returns a JSON object with:
+- stream: data stream name
+- retention: array of retention rules with description, action (e.g. "delete") and duration (e.g. "30d")
+- days: days data is kept, if a delete rule is set
+
+Without streamName returns a 'streams' array with stream, retention, days and storedBytes per stream, and 'count'.
+Streams whose retention cannot be read have an 'error' instead.
+`),
+		mcp.WithString("streamName", mcp.Description("Optional name of the data stream. Leave empty to get the retention of every stream")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName != "" {
+			tasks, err := getParseableRetention(ctx, streamName)
+			if err != nil {
+				slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_retention")
+				return mcp.NewToolResultError("failed to get retention: " + err.Error()), nil
+			}
+			result := map[string]interface{}{
+				"stream":    streamName,
+				"retention": tasks,
+			}
+			if days, ok := retentionDays(tasks); ok {
+				result["days"] = days
+			}
+			return mcp.NewToolResultJSON(result)
+		}
+
+		streams, err := listParseableStreams(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_retention")
+			return mcp.NewToolResultError("failed to list streams: " + err.Error()), nil
+		}
+		results := make([]map[string]interface{}, len(streams))
+		sem := make(chan struct{}, retentionConcurrency)
+		var wg sync.WaitGroup
+		for i, stream := range streams {
+			name, _ := stream["name"].(string)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				entry := map[string]interface{}{"stream": name}
+				results[i] = entry
+				tasks, err := getParseableRetention(ctx, name)
+				if err != nil {
+					entry["error"] = err.Error()
+					return
+				}
+				entry["retention"] = tasks
+				if days, ok := retentionDays(tasks); ok {
+					entry["days"] = days
+				}
+				if stats, err := getParseableStats(ctx, name); err == nil {
+					if size, ok := statNumber(stats, "storage", "size"); ok {
+						entry["storedBytes"] = size
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"streams": results,
+			"count":   len(results),
+		})
+	})
+}
+
+func RegisterSetRetentionTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"set_retention",
+		mcp.WithDescription(`Set how many days a data stream keeps data. Older data is deleted by Parseable.
+Always returns a preview estimating how much data and storage the new retention deletes, from the stream stats and info.
+With preview set nothing is changed; otherwise writes must be enabled on the server. Calls PUT /api/v1/logstream/<streamName>/retention.
+
+Returns a JSON object with:
+- stream: data stream name
+- current: the current retention rules
+- retention: the new retention rules
+- preview: days, cutoff (data older than this is deleted), firstEventAt, latestEventAt, storedBytes, storedEvents,
+  deletedFraction (share of the stored time span older than the cutoff), estimatedDeletedBytes and estimatedDeletedEvents
+- applied: true if the retention was saved
+`),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the data stream")),
+		mcp.WithNumber("days", mcp.Required(), mcp.Description("Number of days to keep data, at least 1")),
+		mcp.WithString("description", mcp.Description("Optional description of the retention rule")),
+		mcp.WithBoolean("preview", mcp.Description("Optional: only return the preview without changing the retention (default: false)")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		days := mcp.ParseInt(req, "days", 0)
+		previewOnly := mcp.ParseBoolean(req, "preview", false)
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "parameter", "streamName", "tool", "set_retention")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		if err := checkStreamName(streamName); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if days < 1 {
+			return mcp.NewToolResultError("days must be a whole number of days, at least 1"), nil
+		}
+		description := mcp.ParseString(req, "description", fmt.Sprintf("delete after %d days", days))
+		tasks := []RetentionTask{{Description: description, Action: "delete", Duration: fmt.Sprintf("%dd", days)}}
+
+		current, err := getParseableRetention(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "set_retention")
+			return mcp.NewToolResultError("failed to get retention: " + err.Error()), nil
+		}
+		preview, err := previewRetention(ctx, streamName, days)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "set_retention")
+			return mcp.NewToolResultError("failed to preview retention: " + err.Error()), nil
+		}
+		result := map[string]interface{}{
+			"stream":    streamName,
+			"current":   current,
+			"retention": tasks,
+			"preview":   preview,
+			"applied":   false,
+		}
+		if previewOnly {
+			return mcp.NewToolResultJSON(result)
+		}
+		if !WritesEnabled {
+			return writesDisabled("set_retention"), nil
+		}
+
+		if err := doParseableRequest(ctx, "PUT", retentionPath(streamName), tasks, nil); err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "set_retention")
+			return mcp.NewToolResultError("failed to set retention: " + err.Error()), nil
+		}
+		result["applied"] = true
+		return mcp.NewToolResultJSON(result)
+	})
+}
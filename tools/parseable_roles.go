@@ -34,7 +34,7 @@ Use this tool to understand access controls before querying or ingesting data.
 For detailed RBAC documentation, see: https://www.parseable.com/docs/user-guide/rbac
 `),
 	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		roles, err := getParseableRoles()
+		roles, err := getParseableRoles(ctx)
 		if err != nil {
 			slog.Error("failed to get roles", "error", err)
 			return mcp.NewToolResultError(err.Error()), nil
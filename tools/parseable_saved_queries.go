@@ -0,0 +1,265 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	parseableFiltersPath = "/api/v1/filters"
+	// Filter type of saved queries stored as SQL, as opposed to filter builder definitions
+	savedQueryTypeSQL = "sql"
+)
+
+// SavedQueryText is the query of a saved query: SQL text, or a filter builder definition
+// as built in the Parseable UI.
+type SavedQueryText struct {
+	FilterType    string          `json:"filter_type"`
+	FilterQuery   string          `json:"filter_query,omitempty"`
+	FilterBuilder json.RawMessage `json:"filter_builder,omitempty"`
+}
+
+// SavedQueryTimeFilter is the time range stored with a saved query.
+type SavedQueryTimeFilter struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SavedQuery is a query or filter saved by a Parseable user. Parseable calls them filters.
+type SavedQuery struct {
+	Version    string                `json:"version,omitempty"`
+	ID         string                `json:"filter_id,omitempty"`
+	Name       string                `json:"filter_name"`
+	UserID     string                `json:"user_id,omitempty"`
+	StreamName string                `json:"stream_name"`
+	Query      SavedQueryText        `json:"query"`
+	TimeFilter *SavedQueryTimeFilter `json:"time_filter,omitempty"`
+}
+
+func listParseableSavedQueries(ctx context.Context) ([]SavedQuery, error) {
+	var queries []SavedQuery
+	err := doParseableRequest(ctx, "GET", parseableFiltersPath, nil, &queries)
+	return queries, err
+}
+
+func getParseableSavedQuery(ctx context.Context, id string) (*SavedQuery, error) {
+	var query SavedQuery
+	if err := doParseableRequest(ctx, "GET", parseableFiltersPath+"/"+url.PathEscape(id), nil, &query); err != nil {
+		return nil, err
+	}
+	return &query, nil
+}
+
+const savedQueryFieldsDescription = `- filter_id: unique identifier of the saved query
+- filter_name: name given by the user
+- user_id: user who saved it
+- stream_name: data stream it runs against
+- query: filter_type ("sql" or "filter"), filter_query (the SQL, for sql queries) and filter_builder (the UI filter definition, for filters)
+- time_filter: time range saved with the query (from and to), if any`
+
+func RegisterListSavedQueriesTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_saved_queries",
+		mcp.WithDescription(`List the saved queries and filters of Parseable users.
+Teams keep curated investigations as saved queries: check here before writing SQL from scratch, then use run_saved_query.
+Calls /api/v1/filters.
+
+Returns a JSON object with a 'savedQueries' array (sorted by name) and 'count'. Each saved query includes:
+`+savedQueryFieldsDescription+`
+`),
+		mcp.WithString("stream", mcp.Description("Optional: only return saved queries for this data stream")),
+		mcp.WithString("search", mcp.Description("Optional: only return saved queries whose name or SQL contains this text, ignoring case")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		stream := mcp.ParseString(req, "stream", "")
+		search := strings.ToLower(mcp.ParseString(req, "search", ""))
+
+		queries, err := listParseableSavedQueries(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_saved_queries")
+			return mcp.NewToolResultError("failed to list saved queries: " + err.Error()), nil
+		}
+		filtered := make([]SavedQuery, 0, len(queries))
+		for _, q := range queries {
+			if stream != "" && q.StreamName != stream {
+				continue
+			}
+			if search != "" && !strings.Contains(strings.ToLower(q.Name), search) &&
+				!strings.Contains(strings.ToLower(q.Query.FilterQuery), search) {
+				continue
+			}
+			filtered = append(filtered, q)
+		}
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return strings.ToLower(filtered[i].Name) < strings.ToLower(filtered[j].Name)
+		})
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"savedQueries": filtered,
+			"count":        len(filtered),
+		})
+	})
+}
+
+func RegisterGetSavedQueryTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_saved_query",
+		mcp.WithDescription(`Get a saved query or filter.
+Calls /api/v1/filters/<id>.
+
+Returns a JSON object with:
+`+savedQueryFieldsDescription+`
+`),
+		mcp.WithString("id", mcp.Required(), mcp.Description("filter_id of the saved query, as returned by list_saved_queries")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id := mcp.ParseString(req, "id", "")
+		if id == "" {
+			slog.Warn("called with missing parameter", "parameter", "id", "tool", "get_saved_query")
+			return mcp.NewToolResultError("missing required field: id"), nil
+		}
+
+		query, err := getParseableSavedQuery(ctx, id)
+		if err != nil {
+			slog.Error("failed to get response", "id", id, "error", err, "tool", "get_saved_query")
+			return mcp.NewToolResultError("failed to get saved query: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(query)
+	})
+}
+
+// savedQueryRange returns the range to run a saved query over: the range given in the
+// request, else the absolute range saved with the query, else the last hour.
+func savedQueryRange(req mcp.CallToolRequest, saved *SavedQuery) (string, string, error) {
+	args := req.GetArguments()
+	_, hasStart := args["startTime"]
+	_, hasEnd := args["endTime"]
+	_, hasSince := args["since"]
+	if !hasStart && !hasEnd && !hasSince && saved.TimeFilter != nil {
+		from, fromOK := parseTimestamp(saved.TimeFilter.From)
+		to, toOK := parseTimestamp(saved.TimeFilter.To)
+		if fromOK && toOK && from.Before(to) {
+			return from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), nil
+		}
+	}
+	return timeRangeArguments(req, time.Hour)
+}
+
+func RegisterRunSavedQueryTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Run a saved SQL query, optionally over a different time range than the one it was saved with.
+The query runs like query_data_stream: rows are redacted, 'format' and 'summarize' work the same way and the result is the same.
+Without startTime, endTime or since, the time range saved with the query is used, or the last hour if it has none.
+Only saved queries with filter_type "sql" can be run; filters built in the UI have no SQL.
+`),
+		mcp.WithString("id", mcp.Required(), mcp.Description("filter_id of the saved query, as returned by list_saved_queries")),
+	}
+	options = append(options, timeRangeOptions("the saved time range, or 1h")...)
+	options = append(options, resultOptions()...)
+	mcpServer.AddTool(mcp.NewTool("run_saved_query", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id := mcp.ParseString(req, "id", "")
+		if id == "" {
+			slog.Warn("called with missing parameter", "parameter", "id", "tool", "run_saved_query")
+			return mcp.NewToolResultError("missing required field: id"), nil
+		}
+
+		saved, err := getParseableSavedQuery(ctx, id)
+		if err != nil {
+			slog.Error("failed to get response", "id", id, "error", err, "tool", "run_saved_query")
+			return mcp.NewToolResultError("failed to get saved query: " + err.Error()), nil
+		}
+		if !strings.EqualFold(saved.Query.FilterType, savedQueryTypeSQL) || strings.TrimSpace(saved.Query.FilterQuery) == "" {
+			return mcp.NewToolResultError(fmt.Sprintf("saved query %q is a %q filter without SQL and cannot be run; "+
+				"read its filter_builder with get_saved_query and write the equivalent SQL for query_data_stream", saved.Name, saved.Query.FilterType)), nil
+		}
+		startTime, endTime, err := savedQueryRange(req, saved)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return runQuery(ctx, req, "run_saved_query", saved.Query.FilterQuery, saved.StreamName, startTime, endTime)
+	})
+}
+
+func RegisterSaveQueryTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"save_query",
+		mcp.WithDescription(`Save a SQL query so it can be found with list_saved_queries and reused later by people and agents.
+The query is checked against the stream schema first: the FROM table must be the stream and fields that are not in the schema are reported as warnings.
+Requires writes to be enabled on the server. Calls POST /api/v1/filters.
+
+Returns a JSON object with 'savedQuery' (the saved query with its filter_id) and 'warnings'.
+`),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Name of the saved query, e.g. 'Payment failures by provider'")),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Data stream the query runs against")),
+		mcp.WithString("query", mcp.Required(), mcp.Description("SQL query. FROM clause table must match streamName and it must not filter on time")),
+		mcp.WithString("startTime", mcp.Description("Optional start of the time range saved with the query, ISO 8601")),
+		mcp.WithString("endTime", mcp.Description("Optional end of the time range saved with the query, ISO 8601")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := mcp.ParseString(req, "name", "")
+		streamName := mcp.ParseString(req, "streamName", "")
+		query := mcp.ParseString(req, "query", "")
+		startTime := mcp.ParseString(req, "startTime", "")
+		endTime := mcp.ParseString(req, "endTime", "")
+		if name == "" || streamName == "" || query == "" {
+			slog.Warn("called with missing parameter", "name", name, "streamName", streamName, "tool", "save_query")
+			return mcp.NewToolResultError("missing required fields: name, streamName and query are required"), nil
+		}
+
+		saved := SavedQuery{
+			Name:       name,
+			StreamName: streamName,
+			Query:      SavedQueryText{FilterType: savedQueryTypeSQL, FilterQuery: query},
+		}
+		if startTime != "" || endTime != "" {
+			from, fromOK := parseTimestamp(startTime)
+			to, toOK := parseTimestamp(endTime)
+			if !fromOK || !toOK || !from.Before(to) {
+				return mcp.NewToolResultError("startTime and endTime must both be ISO 8601 timestamps with startTime before endTime"), nil
+			}
+			saved.TimeFilter = &SavedQueryTimeFilter{From: from.UTC().Format(time.RFC3339), To: to.UTC().Format(time.RFC3339)}
+		}
+
+		tables, columns := sqlReferences(query)
+		if len(tables) != 1 || tables[0] != streamName {
+			return mcp.NewToolResultError(fmt.Sprintf("query must read from %q only, it reads from %v", streamName, tables)), nil
+		}
+		schema, err := getParseableSchema(ctx, streamName)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "save_query")
+			return mcp.NewToolResultError("failed to get schema of " + streamName + ": " + err.Error()), nil
+		}
+		fields := map[string]bool{}
+		for _, field := range schemaFieldNames(schema) {
+			fields[field] = true
+		}
+		warnings := []string{}
+		for _, column := range columns {
+			if !fields[column] {
+				warnings = append(warnings, fmt.Sprintf("query references %q which is not a field in the schema", column))
+			}
+		}
+		if !WritesEnabled {
+			return writesDisabled("save_query"), nil
+		}
+
+		var created SavedQuery
+		if err := doParseableRequest(ctx, "POST", parseableFiltersPath, saved, &created); err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "save_query")
+			return mcp.NewToolResultError("failed to save query: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"savedQuery": created,
+			"warnings":   warnings,
+		})
+	})
+}
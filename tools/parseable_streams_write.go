@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var (
+	telemetryTypes = []string{"logs", "metrics", "traces"}
+	// Field types Parseable accepts in a static schema
+	staticSchemaTypes = []string{"string", "int", "double", "float", "boolean", "datetime", "date",
+		"string_list", "int_list", "double_list", "float_list", "boolean_list"}
+	streamNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// staticSchemaField is a field of a stream created with a static schema.
+type staticSchemaField struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+}
+
+// checkStreamName rejects names that are not valid stream names. Tools that change a stream
+// check it before the name is put into an API path, where a '/' would select another endpoint.
+func checkStreamName(streamName string) error {
+	if !streamNamePattern.MatchString(streamName) {
+		return fmt.Errorf("invalid streamName %s: use only letters, digits, '_' and '-'", streamName)
+	}
+	return nil
+}
+
+func streamPath(streamName string) string {
+	return "/api/v1/logstream/" + streamName
+}
+
+// streamOptionHeaders builds the X-P headers Parseable reads stream options from.
+func streamOptionHeaders(req mcp.CallToolRequest) (map[string]string, error) {
+	headers := map[string]string{}
+	if partition := req.GetStringSlice("customPartition", nil); len(partition) > 0 {
+		headers["X-P-Custom-Partition"] = strings.Join(partition, ",")
+	}
+	if limit := mcp.ParseString(req, "timePartitionLimit", ""); limit != "" {
+		d, err := parseHumanDuration(limit)
+		days := int(d.Hours() / 24)
+		if err != nil || days < 1 || float64(days) != d.Hours()/24 {
+			return nil, fmt.Errorf("timePartitionLimit %q must be a whole number of days, e.g. '30d'", limit)
+		}
+		headers["X-P-Time-Partition-Limit"] = fmt.Sprintf("%dd", days)
+	}
+	return headers, nil
+}
+
+func RegisterCreateDataStreamTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"create_data_stream",
+		mcp.WithDescription(`Create a data stream, e.g. to provision a stream for a new service.
+Requires writes to be enabled on the server. Calls PUT /api/v1/logstream/<streamName> with the stream options as X-P headers.
+
+Options:
+- telemetryType: what the stream holds, "logs" (default), "metrics" or "traces"
+- timePartition: a timestamp field of the events to partition by instead of the ingestion time; cannot be changed later
+- timePartitionLimit: how old events may be relative to now when a time partition is set, e.g. "30d"
+- customPartition: fields to partition by, for faster queries filtering on them
+- staticSchema: fixed fields and types; events with other fields are rejected
+
+Returns a JSON object with 'stream', the options applied and 'info' (the created stream as returned by get_data_stream_info).
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the new stream: letters, digits, '_' and '-'")),
+		mcp.WithString("telemetryType", mcp.Enum(telemetryTypes...), mcp.Description("Optional telemetry type (default: logs)")),
+		mcp.WithString("timePartition", mcp.Description("Optional timestamp field to partition by, e.g. 'event_time'")),
+		mcp.WithString("timePartitionLimit", mcp.Description("Optional maximum age of events with a time partition, e.g. '30d'")),
+		mcp.WithArray("customPartition", mcp.WithStringItems(), mcp.Description("Optional fields to partition by, e.g. ['service']")),
+		mcp.WithArray("staticSchema", mcp.Description("Optional fixed schema as an array of fields"), mcp.Items(map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name":      map[string]interface{}{"type": "string", "description": "Field name"},
+				"data_type": map[string]interface{}{"type": "string", "enum": staticSchemaTypes, "description": "Field type"},
+			},
+			"required": []string{"name", "data_type"},
+		})),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		telemetryType := mcp.ParseString(req, "telemetryType", "logs")
+		timePartition := mcp.ParseString(req, "timePartition", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "parameter", "streamName", "tool", "create_data_stream")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		if err := checkStreamName(streamName); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if !slices.Contains(telemetryTypes, telemetryType) {
+			return mcp.NewToolResultError("unsupported telemetryType: " + telemetryType + " (supported: " + strings.Join(telemetryTypes, ", ") + ")"), nil
+		}
+		headers, err := streamOptionHeaders(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		headers["X-P-Telemetry-Type"] = telemetryType
+		if timePartition != "" {
+			headers["X-P-Time-Partition"] = timePartition
+		} else if _, ok := headers["X-P-Time-Partition-Limit"]; ok {
+			return mcp.NewToolResultError("timePartitionLimit needs a timePartition"), nil
+		}
+
+		var body interface{}
+		if raw, ok := req.GetArguments()["staticSchema"]; ok {
+			var fields []staticSchemaField
+			b, err := json.Marshal(raw)
+			if err == nil {
+				err = json.Unmarshal(b, &fields)
+			}
+			if err != nil {
+				return mcp.NewToolResultError("invalid staticSchema: " + err.Error()), nil
+			}
+			names := map[string]bool{}
+			var problems []string
+			for _, field := range fields {
+				if field.Name == "" || names[field.Name] {
+					problems = append(problems, fmt.Sprintf("field names must be unique and not empty (%q)", field.Name))
+				}
+				names[field.Name] = true
+				if !slices.Contains(staticSchemaTypes, field.DataType) {
+					problems = append(problems, fmt.Sprintf("field %q has unsupported type %q (supported: %s)", field.Name, field.DataType, strings.Join(staticSchemaTypes, ", ")))
+				}
+			}
+			if timePartition != "" && !names[timePartition] {
+				problems = append(problems, fmt.Sprintf("timePartition %q is not a field of the static schema", timePartition))
+			}
+			for _, partition := range req.GetStringSlice("customPartition", nil) {
+				if !names[partition] {
+					problems = append(problems, fmt.Sprintf("customPartition %q is not a field of the static schema", partition))
+				}
+			}
+			if len(fields) == 0 {
+				problems = append(problems, "staticSchema has no fields")
+			}
+			if len(problems) > 0 {
+				return mcp.NewToolResultError("invalid staticSchema: " + strings.Join(problems, "; ")), nil
+			}
+			headers["X-P-Static-Schema-Flag"] = "true"
+			body = map[string]interface{}{"fields": fields}
+		}
+		if !WritesEnabled {
+			return writesDisabled("create_data_stream"), nil
+		}
+
+		if err := doParseableRequestWithHeaders(ctx, "PUT", streamPath(streamName), headers, body, nil); err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "create_data_stream")
+			return mcp.NewToolResultError("failed to create stream: " + err.Error()), nil
+		}
+		result := map[string]interface{}{
+			"stream":  streamName,
+			"options": headers,
+		}
+		if info, err := getParseableInfo(ctx, streamName); err == nil {
+			result["info"] = info
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func RegisterUpdateDataStreamTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"update_data_stream",
+		mcp.WithDescription(`Update the partitioning of an existing data stream. Data already stored keeps its old layout.
+Only the custom partition and the time partition limit can be changed; the time partition, telemetry type and static schema are fixed at creation.
+Requires writes to be enabled on the server. Calls PUT /api/v1/logstream/<streamName> with X-P-Update-Stream.
+
+Returns a JSON object with 'stream', the options applied and 'info' (the stream as returned by get_data_stream_info).
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the stream")),
+		mcp.WithString("timePartitionLimit", mcp.Description("Optional new maximum age of events, e.g. '30d'. Only for streams with a time partition")),
+		mcp.WithArray("customPartition", mcp.WithStringItems(), mcp.Description("Optional new fields to partition by")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "parameter", "streamName", "tool", "update_data_stream")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		if err := checkStreamName(streamName); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		headers, err := streamOptionHeaders(req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if len(headers) == 0 {
+			return mcp.NewToolResultError("nothing to update: give timePartitionLimit or customPartition"), nil
+		}
+		headers["X-P-Update-Stream"] = "true"
+		if !WritesEnabled {
+			return writesDisabled("update_data_stream"), nil
+		}
+
+		if err := doParseableRequestWithHeaders(ctx, "PUT", streamPath(streamName), headers, nil, nil); err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "update_data_stream")
+			return mcp.NewToolResultError("failed to update stream: " + err.Error()), nil
+		}
+		result := map[string]interface{}{
+			"stream":  streamName,
+			"options": headers,
+		}
+		if info, err := getParseableInfo(ctx, streamName); err == nil {
+			result["info"] = info
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+// streamDeletionPlan describes what deleting a stream removes: its stored data and the alerts
+// that query it. The signed before state holds the stream and those alerts. The stored event
+// count and size are listed in the changes only, since they grow while the stream ingests and
+// would otherwise invalidate every token of an active stream.
+func streamDeletionPlan(ctx context.Context, streamName string) (*changePlan, error) {
+	if streamName == "" {
+		return nil, fmt.Errorf("missing required field: streamName")
+	}
+	if err := checkStreamName(streamName); err != nil {
+		return nil, err
+	}
+	stats, err := getParseableStats(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream stats: %w", err)
+	}
+	deletion := "delete stream " + streamName
+	if events, ok := statNumber(stats, "ingestion", "count"); ok {
+		deletion += fmt.Sprintf(" with %.0f stored events", events)
+	}
+	if size, ok := statNumber(stats, "storage", "size"); ok {
+		deletion += " (" + formatByteSize(size) + ")"
+	}
+	plan := &changePlan{
+		Action:   "delete_data_stream",
+		Target:   streamName,
+		Changes:  []string{deletion},
+		Warnings: []string{"the stream and its data cannot be restored"},
+	}
+
+	alerts, err := listParseableAlerts(ctx)
+	if err != nil {
+		plan.Before = map[string]interface{}{"stream": streamName}
+		plan.Warnings = append(plan.Warnings, "could not list the alerts that query the stream: "+err.Error())
+		return plan, nil
+	}
+	affected := []map[string]string{}
+	for _, alert := range alerts {
+		if slices.Contains(alert.Datasets, streamName) {
+			affected = append(affected, map[string]string{"id": alert.ID, "title": alert.Title})
+			plan.Changes = append(plan.Changes, fmt.Sprintf("alert %q (%s) loses its dataset", alert.Title, alert.ID))
+		}
+	}
+	plan.Before = map[string]interface{}{"stream": streamName, "alerts": affected}
+	return plan, nil
+}
+
+func RegisterDeleteDataStreamTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_data_stream",
+		withPlanOptions(
+			mcp.WithDescription(`Delete a data stream and all of its data. This cannot be undone.
+The plan lists what would be lost: the stored events and size, and the alerts that query the stream.
+Calls DELETE /api/v1/logstream/<streamName>.
+
+`+planFlowDescription+`
+`),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the stream to delete")),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		return planOrApply(ctx, req, "delete_data_stream", func() (*changePlan, error) {
+			return streamDeletionPlan(ctx, streamName)
+		}, func() (map[string]interface{}, error) {
+			return nil, doParseableRequest(ctx, "DELETE", streamPath(streamName), nil, nil)
+		})
+	})
+}
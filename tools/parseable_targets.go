@@ -0,0 +1,409 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/audit"
+)
+
+const (
+	parseableTargetsPath = "/api/v1/targets"
+	redactedSecret       = "[REDACTED]"
+	// Timeout of the test notification sent by test_target
+	targetTestTimeout = 10 * time.Second
+)
+
+var targetTypes = []string{"slack", "webhook", "alertManager"}
+
+// TargetAuth holds the basic auth credentials of an Alertmanager target.
+type TargetAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Target is a notification target alerts are routed to.
+type Target struct {
+	ID           string            `json:"id,omitempty"`
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	Endpoint     string            `json:"endpoint"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	SkipTLSCheck bool              `json:"skipTlsCheck,omitempty"`
+	Auth         *TargetAuth       `json:"auth,omitempty"`
+	// NotificationConfig is passed through as is, its format depends on the Parseable version.
+	NotificationConfig json.RawMessage `json:"notificationConfig,omitempty"`
+}
+
+// redacted returns a copy of the target with secrets replaced: header values, passwords,
+// credentials and query values in the endpoint, and the token path of Slack webhook URLs.
+func (t Target) redacted() Target {
+	r := t
+	r.Endpoint = redactEndpoint(t.Type, t.Endpoint)
+	if t.Headers != nil {
+		r.Headers = make(map[string]string, len(t.Headers))
+		for name := range t.Headers {
+			r.Headers[name] = redactedSecret
+		}
+	}
+	if t.Auth != nil {
+		r.Auth = &TargetAuth{Username: t.Auth.Username, Password: redactedSecret}
+	}
+	return r
+}
+
+func redactEndpoint(targetType string, endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return redactedSecret
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	query := u.Query()
+	for key := range query {
+		query.Set(key, "REDACTED")
+	}
+	u.RawQuery = query.Encode()
+	// Webhook URLs often carry their token in the path, e.g. Slack (/services/<ids>/<token>),
+	// Discord (/api/webhooks/<id>/<token>) and Teams connectors, so only Alertmanager keeps it
+	if !strings.EqualFold(targetType, "alertManager") || strings.HasSuffix(u.Host, "hooks.slack.com") {
+		if u.Path != "" && u.Path != "/" {
+			u.Path = "/REDACTED"
+		}
+		u.RawPath = ""
+		u.RawQuery = ""
+		u.Fragment = ""
+	}
+	return u.String()
+}
+
+// redactEndpointError redacts the URL that errors of the HTTP client carry, since it can hold
+// the secrets of the endpoint.
+func redactEndpointError(targetType string, err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return &url.Error{Op: ue.Op, URL: redactEndpoint(targetType, ue.URL), Err: ue.Err}
+	}
+	return err
+}
+
+func listParseableTargets(ctx context.Context) ([]Target, error) {
+	var targets []Target
+	err := doParseableRequest(ctx, "GET", parseableTargetsPath, nil, &targets)
+	return targets, err
+}
+
+func getParseableTarget(ctx context.Context, targetID string) (*Target, error) {
+	var target Target
+	if err := doParseableRequest(ctx, "GET", parseableTargetsPath+"/"+url.PathEscape(targetID), nil, &target); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+const targetFieldsDescription = `- id: unique target identifier, referenced by the 'targets' of an alert
+- name: target name
+- type: "slack", "webhook" or "alertManager"
+- endpoint: URL notifications are sent to, with credentials and query values redacted; Slack and webhook URLs keep only scheme and host
+- headers: extra HTTP headers sent with notifications, values redacted
+- skipTlsCheck: whether TLS verification is skipped
+- auth: Alertmanager basic auth, password redacted
+- notificationConfig: how often notifications are repeated`
+
+func RegisterListTargetsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_targets",
+		mcp.WithDescription(`List the notification targets (Slack, webhooks, Alertmanager) alerts can be routed to.
+Calls /api/v1/targets. Secrets are redacted.
+
+Returns a JSON object with a 'targets' array and 'count' (number of targets). Each target includes:
+`+targetFieldsDescription+`
+`),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targets, err := listParseableTargets(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_targets")
+			return mcp.NewToolResultError("failed to list targets: " + err.Error()), nil
+		}
+		redacted := make([]Target, 0, len(targets))
+		for _, target := range targets {
+			redacted = append(redacted, target.redacted())
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"targets": redacted,
+			"count":   len(redacted),
+		})
+	})
+}
+
+func RegisterGetTargetTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_target",
+		mcp.WithDescription(`Get a notification target.
+Calls /api/v1/targets/<targetId>. Secrets are redacted.
+
+Returns a JSON object with:
+`+targetFieldsDescription+`
+`),
+		mcp.WithString("targetId", mcp.Required(), mcp.Description("Id of the target, as returned by list_targets")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetID := mcp.ParseString(req, "targetId", "")
+		if targetID == "" {
+			slog.Warn("called with missing parameter", "parameter", "targetId", "tool", "get_target")
+			return mcp.NewToolResultError("missing required field: targetId"), nil
+		}
+
+		target, err := getParseableTarget(ctx, targetID)
+		if err != nil {
+			slog.Error("failed to get response", "targetId", targetID, "error", err, "tool", "get_target")
+			return mcp.NewToolResultError("failed to get target: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(target.redacted())
+	})
+}
+
+// scrubTargetArguments redacts the secrets of create_target arguments in the audit log.
+func scrubTargetArguments(args map[string]interface{}) {
+	if endpoint, ok := args["endpoint"].(string); ok {
+		targetType, _ := args["type"].(string)
+		args["endpoint"] = redactEndpoint(targetType, endpoint)
+	}
+	if headers, ok := args["headers"].(map[string]interface{}); ok {
+		for name := range headers {
+			headers[name] = redactedSecret
+		}
+	}
+	if _, ok := args["password"]; ok {
+		args["password"] = redactedSecret
+	}
+}
+
+func RegisterCreateTargetTool(mcpServer *server.MCPServer) {
+	audit.RegisterScrubber("create_target", scrubTargetArguments)
+	mcpServer.AddTool(mcp.NewTool(
+		"create_target",
+		mcp.WithDescription(`Create a notification target for alerts.
+Requires writes to be enabled on the server. Calls POST /api/v1/targets.
+
+Returns the created target with secrets redacted.
+`),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Target name")),
+		mcp.WithString("type", mcp.Required(), mcp.Enum(targetTypes...), mcp.Description("Target type")),
+		mcp.WithString("endpoint", mcp.Required(), mcp.Description("URL notifications are sent to, e.g. a Slack incoming webhook URL or the Alertmanager base URL")),
+		mcp.WithObject("headers", mcp.Description("Optional extra HTTP headers for webhook targets, e.g. {\"Authorization\": \"Bearer ...\"}")),
+		mcp.WithBoolean("skipTlsCheck", mcp.Description("Optional: skip TLS verification of the endpoint (default: false)")),
+		mcp.WithString("username", mcp.Description("Optional basic auth username for Alertmanager targets")),
+		mcp.WithString("password", mcp.Description("Optional basic auth password for Alertmanager targets")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		target := Target{
+			Name:         mcp.ParseString(req, "name", ""),
+			Type:         mcp.ParseString(req, "type", ""),
+			Endpoint:     mcp.ParseString(req, "endpoint", ""),
+			SkipTLSCheck: mcp.ParseBoolean(req, "skipTlsCheck", false),
+		}
+		if target.Name == "" || target.Type == "" || target.Endpoint == "" {
+			slog.Warn("called with missing parameter", "name", target.Name, "type", target.Type, "tool", "create_target")
+			return mcp.NewToolResultError("missing required fields: name, type and endpoint are required"), nil
+		}
+		validType := false
+		for _, t := range targetTypes {
+			validType = validType || target.Type == t
+		}
+		if !validType {
+			return mcp.NewToolResultError("unsupported target type: " + target.Type + " (supported: " + strings.Join(targetTypes, ", ") + ")"), nil
+		}
+		if u, err := url.Parse(target.Endpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return mcp.NewToolResultError("endpoint must be an http or https URL"), nil
+		}
+		if headers := mcp.ParseStringMap(req, "headers", nil); headers != nil {
+			target.Headers = make(map[string]string, len(headers))
+			for name, value := range headers {
+				target.Headers[name] = fmt.Sprint(value)
+			}
+		}
+		if username := mcp.ParseString(req, "username", ""); username != "" {
+			target.Auth = &TargetAuth{Username: username, Password: mcp.ParseString(req, "password", "")}
+		}
+		if !WritesEnabled {
+			return writesDisabled("create_target"), nil
+		}
+
+		var created Target
+		if err := doParseableRequest(ctx, "POST", parseableTargetsPath, target, &created); err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "create_target")
+			return mcp.NewToolResultError("failed to create target: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(created.redacted())
+	})
+}
+
+func RegisterDeleteTargetTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_target",
+		mcp.WithDescription(`Delete a notification target.
+Alerts routed to the target stop notifying through it. Requires writes to be enabled on the server.
+Calls DELETE /api/v1/targets/<targetId>.
+
+Returns a JSON object with 'deleted' (the target id) and 'name' of the deleted target.
+`),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithString("targetId", mcp.Required(), mcp.Description("Id of the target to delete, as returned by list_targets")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetID := mcp.ParseString(req, "targetId", "")
+		if targetID == "" {
+			slog.Warn("called with missing parameter", "parameter", "targetId", "tool", "delete_target")
+			return mcp.NewToolResultError("missing required field: targetId"), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("delete_target"), nil
+		}
+
+		target, err := getParseableTarget(ctx, targetID)
+		if err != nil {
+			slog.Error("failed to get response", "targetId", targetID, "error", err, "tool", "delete_target")
+			return mcp.NewToolResultError("failed to get target: " + err.Error()), nil
+		}
+		if err := doParseableRequest(ctx, "DELETE", parseableTargetsPath+"/"+url.PathEscape(targetID), nil, nil); err != nil {
+			slog.Error("failed to get response", "targetId", targetID, "error", err, "tool", "delete_target")
+			return mcp.NewToolResultError("failed to delete target: " + err.Error()), nil
+		}
+
+		return mcp.NewToolResultJSON(map[string]interface{}{
+			"deleted": targetID,
+			"name":    target.Name,
+		})
+	})
+}
+
+// testNotification returns the body of a test notification in the format the target type expects.
+func testNotification(target Target) interface{} {
+	message := "Test notification from mcp-parseable-server for target " + target.Name
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch target.Type {
+	case "slack":
+		return map[string]string{"text": message}
+	case "alertManager":
+		return []map[string]interface{}{{
+			"labels": map[string]string{
+				"alertname": "ParseableTargetTest",
+				"severity":  "info",
+				"source":    "mcp-parseable-server",
+			},
+			"annotations": map[string]string{"summary": message},
+			"startsAt":    now,
+			"endsAt":      time.Now().UTC().Add(5 * time.Minute).Format(time.RFC3339),
+		}}
+	}
+	return map[string]string{
+		"message": message,
+		"target":  target.Name,
+		"time":    now,
+	}
+}
+
+// sendTestNotification posts a test notification to the target and returns the HTTP status
+// and the start of the response body.
+func sendTestNotification(ctx context.Context, target Target) (int, string, error) {
+	endpoint := target.Endpoint
+	if target.Type == "alertManager" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/api/v2/alerts"
+	}
+	body, err := json.Marshal(testNotification(target))
+	if err != nil {
+		return 0, "", err
+	}
+	audit.NoteEndpoint(ctx, "POST", redactEndpoint(target.Type, endpoint))
+	ctx, cancel := context.WithTimeout(ctx, targetTestTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", redactEndpointError(target.Type, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range target.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	if target.Auth != nil && target.Auth.Username != "" {
+		httpReq.SetBasicAuth(target.Auth.Username, target.Auth.Password)
+	}
+	client := &http.Client{}
+	if target.SkipTLSCheck {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, "", redactEndpointError(target.Type, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("failed to close response body", "error", err)
+		}
+	}()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, strings.TrimSpace(string(respBody)), nil
+}
+
+func RegisterTestTargetTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"test_target",
+		mcp.WithDescription(`Send a test notification to a notification target to check that it is reachable and accepts notifications.
+The server sends the notification itself, using the target definition from /api/v1/targets/<targetId>.
+Since it notifies people, it requires writes to be enabled on the server.
+
+Returns a JSON object with:
+- target: the target with secrets redacted
+- success: true if the endpoint answered with a 2xx status
+- status: HTTP status code returned by the endpoint
+- response: start of the response body
+- error: connection error, if the endpoint could not be reached
+`),
+		mcp.WithString("targetId", mcp.Required(), mcp.Description("Id of the target to test, as returned by list_targets")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetID := mcp.ParseString(req, "targetId", "")
+		if targetID == "" {
+			slog.Warn("called with missing parameter", "parameter", "targetId", "tool", "test_target")
+			return mcp.NewToolResultError("missing required field: targetId"), nil
+		}
+		if !WritesEnabled {
+			return writesDisabled("test_target"), nil
+		}
+
+		target, err := getParseableTarget(ctx, targetID)
+		if err != nil {
+			slog.Error("failed to get response", "targetId", targetID, "error", err, "tool", "test_target")
+			return mcp.NewToolResultError("failed to get target: " + err.Error()), nil
+		}
+
+		result := map[string]interface{}{
+			"target": target.redacted(),
+		}
+		status, response, err := sendTestNotification(ctx, *target)
+		if err != nil {
+			slog.Warn("test notification failed", "targetId", targetID, "error", err, "tool", "test_target")
+			result["success"] = false
+			result["error"] = err.Error()
+			return mcp.NewToolResultJSON(result)
+		}
+		result["success"] = status >= 200 && status <= 299
+		result["status"] = status
+		result["response"] = response
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -0,0 +1,663 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+const (
+	// Maximum number of spans get_trace reads for one trace
+	maxTraceSpans = 5000
+	// Number of spans listed in slowestSpans of get_trace
+	slowestSpansCount = 5
+	// Maximum number of edges service_dependency_map returns
+	maxDependencyEdges = 500
+	defaultSlowTraces  = 20
+)
+
+// traceFields are the fields of a traces stream that hold the span properties. Name, Service,
+// Kind and Status are empty if the stream does not have them.
+type traceFields struct {
+	TraceID, SpanID, ParentID, Start, End string
+	Name, Service, Kind, Status           string
+	types                                 map[string]string
+}
+
+// resolveTraceFields finds the span fields in the schema of a traces stream, as flattened by
+// Parseable from OpenTelemetry (span_trace_id, span_start_time_unix_nano, ...) or by other
+// pipelines.
+func resolveTraceFields(types map[string]string) (traceFields, error) {
+	f := traceFields{
+		TraceID:  findField(types, "span_trace_id", "trace_id", "traceId", "traceID"),
+		SpanID:   findField(types, "span_span_id", "span_id", "spanId", "spanID"),
+		ParentID: findField(types, "span_parent_span_id", "parent_span_id", "parentSpanId", "parentSpanID"),
+		Start:    findField(types, "span_start_time_unix_nano", "start_time_unix_nano", "start_time", "startTime"),
+		End:      findField(types, "span_end_time_unix_nano", "end_time_unix_nano", "end_time", "endTime"),
+		Name:     findField(types, "span_name", "name"),
+		Service:  findField(types, "service.name", "resource_service.name", "service_name", "serviceName"),
+		Kind:     findField(types, "span_kind_description", "span_kind", "kind"),
+		Status:   findField(types, "span_status_code", "status_code", "span_status_description", "status"),
+		types:    types,
+	}
+	var missing []string
+	for name, field := range map[string]string{"trace id": f.TraceID, "span id": f.SpanID, "parent span id": f.ParentID, "start time": f.Start, "end time": f.End} {
+		if field == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return f, fmt.Errorf("the stream schema has no %s field, it does not look like an OpenTelemetry traces stream", strings.Join(missing, ", "))
+	}
+	return f, nil
+}
+
+// column is the quoted column of a field, qualified by a table alias if given.
+func (f traceFields) column(alias string, field string) string {
+	if alias == "" {
+		return quoteIdent(field)
+	}
+	return alias + "." + quoteIdent(field)
+}
+
+func (f traceFields) durationNanosExpr(alias string) string {
+	return fmt.Sprintf("(%s - %s)", nanosExpr(f.column(alias, f.End), f.types[f.End]), nanosExpr(f.column(alias, f.Start), f.types[f.Start]))
+}
+
+func (f traceFields) errorCountExpr(alias string) string {
+	if f.Status == "" {
+		return "0"
+	}
+	return fmt.Sprintf("SUM(CASE WHEN %s THEN 1 ELSE 0 END)", statusErrorExpr(f.column(alias, f.Status), f.types[f.Status]))
+}
+
+// traceSpan is a span of a trace tree returned by get_trace.
+type traceSpan struct {
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentSpanId,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	Service    string                 `json:"service,omitempty"`
+	Kind       string                 `json:"kind,omitempty"`
+	Status     interface{}            `json:"status,omitempty"`
+	Error      bool                   `json:"error"`
+	StartTime  string                 `json:"startTime"`
+	OffsetMs   float64                `json:"offsetMs"`
+	DurationMs float64                `json:"durationMs"`
+	SelfTimeMs float64                `json:"selfTimeMs"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Children   []*traceSpan           `json:"children,omitempty"`
+	start, end int64
+}
+
+func stringValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func nanosToMillis(ns int64) float64 {
+	return roundTo(float64(ns)/1e6, 3)
+}
+
+// spanFromRow reads a span from a row of a traces stream. Fields other than the span
+// properties are kept as attributes if includeAttributes is set.
+func spanFromRow(row map[string]interface{}, f traceFields, includeAttributes bool) *traceSpan {
+	span := &traceSpan{
+		SpanID:   stringValue(row[f.SpanID]),
+		ParentID: stringValue(row[f.ParentID]),
+	}
+	if f.Name != "" {
+		span.Name = stringValue(row[f.Name])
+	}
+	if f.Service != "" {
+		span.Service = stringValue(row[f.Service])
+	}
+	if f.Kind != "" {
+		span.Kind = stringValue(row[f.Kind])
+	}
+	if f.Status != "" {
+		span.Status = row[f.Status]
+		span.Error = isErrorStatus(row[f.Status])
+	}
+	span.start, _ = unixNanos(row[f.Start])
+	span.end, _ = unixNanos(row[f.End])
+	span.end = max(span.end, span.start)
+	if includeAttributes {
+		span.Attributes = map[string]interface{}{}
+		for key, value := range row {
+			switch key {
+			case f.TraceID, f.SpanID, f.ParentID, f.Start, f.End, f.Name, f.Service, f.Kind, f.Status:
+				continue
+			}
+			if value != nil {
+				span.Attributes[key] = value
+			}
+		}
+	}
+	return span
+}
+
+// buildTraceTree links spans to their parents and returns the root spans, sorted by start
+// time, and the ids of parents missing from the trace. Spans whose parent is missing are
+// returned as roots. Offsets are relative to the earliest span.
+func buildTraceTree(spans []*traceSpan) (roots []*traceSpan, missingParents []string) {
+	missingParents = []string{}
+	byID := make(map[string]*traceSpan, len(spans))
+	var traceStart int64
+	for i, span := range spans {
+		byID[span.SpanID] = span
+		if i == 0 || span.start < traceStart {
+			traceStart = span.start
+		}
+	}
+	missing := map[string]bool{}
+	for _, span := range spans {
+		parent, ok := byID[span.ParentID]
+		switch {
+		case span.ParentID == "":
+			roots = append(roots, span)
+		case !ok || parent == span:
+			if !missing[span.ParentID] {
+				missing[span.ParentID] = true
+				missingParents = append(missingParents, span.ParentID)
+			}
+			roots = append(roots, span)
+		default:
+			parent.Children = append(parent.Children, span)
+		}
+	}
+
+	// Spans in a parent cycle are not reachable from a root; the cycle is cut by making one
+	// of them a root.
+	reached := map[*traceSpan]bool{}
+	var visit func(span *traceSpan)
+	visit = func(span *traceSpan) {
+		reached[span] = true
+		for _, child := range span.Children {
+			if !reached[child] {
+				visit(child)
+			}
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	for _, span := range spans {
+		if !reached[span] {
+			parent := byID[span.ParentID]
+			parent.Children = slices.DeleteFunc(parent.Children, func(child *traceSpan) bool { return child == span })
+			roots = append(roots, span)
+			visit(span)
+		}
+	}
+
+	for _, span := range spans {
+		sort.Slice(span.Children, func(i, j int) bool { return span.Children[i].start < span.Children[j].start })
+		span.OffsetMs = nanosToMillis(span.start - traceStart)
+		span.DurationMs = nanosToMillis(span.end - span.start)
+		span.SelfTimeMs = nanosToMillis(selfTime(span))
+		span.StartTime = time.Unix(0, span.start).UTC().Format(time.RFC3339Nano)
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].start < roots[j].start })
+	sort.Strings(missingParents)
+	return roots, missingParents
+}
+
+// selfTime is the time of a span not covered by any of its children, in nanoseconds.
+// Children are sorted by start time.
+func selfTime(span *traceSpan) int64 {
+	var covered int64
+	cursor := span.start
+	for _, child := range span.Children {
+		start := max(child.start, cursor)
+		end := min(child.end, span.end)
+		if end > start {
+			covered += end - start
+			cursor = end
+		}
+	}
+	return max(span.end-span.start-covered, 0)
+}
+
+func RegisterGetTraceTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(fmt.Sprintf(`Get all spans of a trace from an OpenTelemetry traces stream (telemetryType "traces" in get_data_stream_info),
+assembled into a tree of parent and child spans with durations. Use this instead of querying span rows with SQL.
+
+Returns a JSON object with:
+- traceId, spanCount, startTime, durationMs: the trace and its duration from the first span start to the last span end
+- services: span count per service
+- errorCount: spans with an error status
+- tree: root spans, each with spanId, parentSpanId, name, service, kind, status, error, startTime,
+  offsetMs (from the trace start), durationMs, selfTimeMs (time not spent in children) and children
+- slowestSpans: the %d spans with the highest self time, where the trace spends its time
+- missingParents: parent span ids not in the trace, e.g. spans outside the time range; their children are roots
+- truncated: true if the trace has more than %d spans and only the first were read
+`, slowestSpansCount, maxTraceSpans)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the traces stream")),
+		mcp.WithString("traceId", mcp.Required(), mcp.Description("Trace id, e.g. '4bf92f3577b34da6a3ce929d0e0e4736'")),
+		mcp.WithBoolean("includeAttributes", mcp.Description("Optional: include the other fields of each span as 'attributes' (default: false)")),
+	}
+	options = append(options, timeRangeOptions("24h")...)
+	mcpServer.AddTool(mcp.NewTool("get_trace", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		traceID := mcp.ParseString(req, "traceId", "")
+		includeAttributes := mcp.ParseBoolean(req, "includeAttributes", false)
+		if streamName == "" || traceID == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "traceId", traceID, "tool", "get_trace")
+			return mcp.NewToolResultError("missing required fields: streamName and traceId are required"), nil
+		}
+		startTime, endTime, err := timeRangeArguments(req, 24*time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		types, err := telemetryStreamFields(ctx, streamName, "traces")
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_trace")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		f, err := resolveTraceFields(types)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		query := fmt.Sprintf("SELECT * FROM %s WHERE CAST(%s AS VARCHAR) = %s LIMIT %d",
+			quoteIdent(streamName), f.column("", f.TraceID), quoteLiteral(traceID), maxTraceSpans+1)
+		recordQueryLookback(streamName, startTime)
+		rows, err := doParseableQuery(ctx, query, streamName, startTime, endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "get_trace", "query", query)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+		if len(rows) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("no spans of trace %q in %s between %s and %s; try a wider time range", traceID, streamName, startTime, endTime)), nil
+		}
+		truncated := len(rows) > maxTraceSpans
+		if truncated {
+			rows = rows[:maxTraceSpans]
+		}
+		report, err := redactQueryRows(ctx, streamName, rows)
+		if err != nil {
+			slog.Error("failed to redact rows", "streamName", streamName, "error", err, "tool", "get_trace")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		spans := make([]*traceSpan, 0, len(rows))
+		services := map[string]int{}
+		errorCount := 0
+		var first, last int64
+		for i, row := range rows {
+			span := spanFromRow(row, f, includeAttributes)
+			spans = append(spans, span)
+			if span.Service != "" {
+				services[span.Service]++
+			}
+			if span.Error {
+				errorCount++
+			}
+			if i == 0 || span.start < first {
+				first = span.start
+			}
+			last = max(last, span.end)
+		}
+		tree, missingParents := buildTraceTree(spans)
+
+		slowest := make([]*traceSpan, len(spans))
+		copy(slowest, spans)
+		sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].SelfTimeMs > slowest[j].SelfTimeMs })
+		slowestSpans := []map[string]interface{}{}
+		for _, span := range slowest[:min(slowestSpansCount, len(slowest))] {
+			slowestSpans = append(slowestSpans, map[string]interface{}{
+				"spanId":     span.SpanID,
+				"name":       span.Name,
+				"service":    span.Service,
+				"selfTimeMs": span.SelfTimeMs,
+				"durationMs": span.DurationMs,
+			})
+		}
+
+		result := map[string]interface{}{
+			"traceId":        traceID,
+			"spanCount":      len(spans),
+			"startTime":      time.Unix(0, first).UTC().Format(time.RFC3339Nano),
+			"durationMs":     nanosToMillis(last - first),
+			"services":       services,
+			"errorCount":     errorCount,
+			"tree":           tree,
+			"slowestSpans":   slowestSpans,
+			"missingParents": missingParents,
+			"truncated":      truncated,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func RegisterFindSlowTracesTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(`Find the slowest traces of an OpenTelemetry traces stream in a time range. The duration of a trace runs
+from its first span start to its last span end, counting only spans in the time range. Optionally only traces with a span
+of a service or span name. Use get_trace to look into one of them.
+
+Returns a JSON object with a 'traces' array, slowest first, each with:
+- traceId, startTime, durationMs
+- spans: number of spans, errors: spans with an error status, services: number of services
+- rootSpan, rootService: name and service of the root span, if it is in the time range
+Plus 'count' and the 'query' that ran.
+`),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the traces stream")),
+		mcp.WithString("service", mcp.Description("Optional: only traces with a span of this service")),
+		mcp.WithString("spanName", mcp.Description("Optional: only traces with a span of this name, e.g. 'GET /checkout'")),
+		mcp.WithNumber("minDurationMs", mcp.Description("Optional minimum trace duration in milliseconds")),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Optional number of traces (default: %d)", defaultSlowTraces))),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("find_slow_traces", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		service := mcp.ParseString(req, "service", "")
+		spanName := mcp.ParseString(req, "spanName", "")
+		minDuration := mcp.ParseFloat64(req, "minDurationMs", 0)
+		limit := mcp.ParseInt(req, "limit", defaultSlowTraces)
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "tool", "find_slow_traces")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		if limit <= 0 {
+			limit = defaultSlowTraces
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		types, err := telemetryStreamFields(ctx, streamName, "traces")
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "find_slow_traces")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		f, err := resolveTraceFields(types)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		var conditions []string
+		for _, filter := range []struct{ field, value, name string }{{f.Service, service, "service"}, {f.Name, spanName, "span name"}} {
+			if filter.value == "" {
+				continue
+			}
+			if filter.field == "" {
+				return mcp.NewToolResultError("the stream schema has no " + filter.name + " field to filter on"), nil
+			}
+			conditions = append(conditions, fmt.Sprintf("CAST(%s AS VARCHAR) = %s", quoteIdent(filter.field), quoteLiteral(filter.value)))
+		}
+
+		stream := quoteIdent(streamName)
+		traceID := quoteIdent(f.TraceID)
+		start := nanosExpr(quoteIdent(f.Start), types[f.Start])
+		end := nanosExpr(quoteIdent(f.End), types[f.End])
+		duration := fmt.Sprintf("(MAX(%s) - MIN(%s)) / 1000000.0", end, start)
+		services := "0"
+		if f.Service != "" {
+			services = fmt.Sprintf("COUNT(DISTINCT %s)", quoteIdent(f.Service))
+		}
+		query := fmt.Sprintf("SELECT %s AS trace_id, MIN(%s) AS start_ns, %s AS duration_ms, COUNT(*) AS spans, %s AS errors, %s AS services FROM %s",
+			traceID, start, duration, f.errorCountExpr(""), services, stream)
+		if len(conditions) > 0 {
+			query += fmt.Sprintf(" WHERE %s IN (SELECT %s FROM %s WHERE %s)", traceID, traceID, stream, strings.Join(conditions, " AND "))
+		}
+		query += " GROUP BY " + traceID
+		if minDuration > 0 {
+			query += fmt.Sprintf(" HAVING %s >= %g", duration, minDuration)
+		}
+		query += fmt.Sprintf(" ORDER BY duration_ms DESC LIMIT %d", limit)
+
+		recordQueryLookback(streamName, startTime)
+		rows, err := doParseableQuery(ctx, query, streamName, startTime, endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "find_slow_traces", "query", query)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+
+		// Root spans of the slowest traces, for a readable name of each trace. They are matched
+		// by the trace ids as queried, before redaction can change them.
+		rootIndex := map[string]int{}
+		var roots []map[string]interface{}
+		if len(rows) > 0 && (f.Name != "" || f.Service != "") {
+			ids := make([]string, 0, len(rows))
+			for _, row := range rows {
+				ids = append(ids, quoteLiteral(stringValue(row["trace_id"])))
+			}
+			columns := []string{traceID + " AS trace_id"}
+			if f.Name != "" {
+				columns = append(columns, quoteIdent(f.Name)+" AS root_span")
+			}
+			if f.Service != "" {
+				columns = append(columns, quoteIdent(f.Service)+" AS root_service")
+			}
+			parent := quoteIdent(f.ParentID)
+			rootQuery := fmt.Sprintf("SELECT %s FROM %s WHERE CAST(%s AS VARCHAR) IN (%s) AND (%s IS NULL OR CAST(%s AS VARCHAR) = '')",
+				strings.Join(columns, ", "), stream, traceID, strings.Join(ids, ", "), parent, parent)
+			roots, err = doParseableQuery(ctx, rootQuery, streamName, startTime, endTime)
+			if err != nil {
+				slog.Warn("failed to get root spans", "streamName", streamName, "error", err, "tool", "find_slow_traces")
+			}
+			for i, root := range roots {
+				rootIndex[stringValue(root["trace_id"])] = i
+			}
+		}
+		rowRoots := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			if j, ok := rootIndex[stringValue(row["trace_id"])]; ok {
+				rowRoots[i] = roots[j]
+			}
+		}
+
+		report, err := redactAliasedRows(ctx, streamName, rows, map[string]string{"trace_id": f.TraceID})
+		if err == nil {
+			var rootReport *redact.Report
+			rootReport, err = redactAliasedRows(ctx, streamName, roots, map[string]string{"trace_id": f.TraceID, "root_span": f.Name, "root_service": f.Service})
+			report.Merge(rootReport)
+		}
+		if err != nil {
+			slog.Error("failed to redact rows", "streamName", streamName, "error", err, "tool", "find_slow_traces")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		traces := make([]map[string]interface{}, 0, len(rows))
+		for i, row := range rows {
+			trace := map[string]interface{}{
+				"traceId":    row["trace_id"],
+				"durationMs": row["duration_ms"],
+				"spans":      row["spans"],
+				"errors":     row["errors"],
+				"services":   row["services"],
+			}
+			if ns, ok := unixNanos(row["start_ns"]); ok {
+				trace["startTime"] = time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+			}
+			for column, key := range map[string]string{"root_span": "rootSpan", "root_service": "rootService"} {
+				if value, ok := rowRoots[i][column]; ok {
+					trace[key] = value
+				}
+			}
+			traces = append(traces, trace)
+		}
+		result := map[string]interface{}{
+			"traces": traces,
+			"count":  len(traces),
+			"query":  query,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func RegisterServiceDependencyMapTool(mcpServer *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(fmt.Sprintf(`Build the map of which services call which from an OpenTelemetry traces stream: a call is a span
+whose parent span belongs to a different service. Use it to understand the architecture, or to find the calls with errors or
+high latency during an incident.
+
+Returns a JSON object with:
+- edges: caller, callee, calls, errors (calls with an error status), errorRate, avgDurationMs and maxDurationMs of the
+  callee spans, most calls first (at most %d)
+- services: per service its span count, errors, and the services it calls and is called by
+- entrypoints: services that no other service calls, e.g. gateways
+- query: the SQL of the edges
+`, maxDependencyEdges)),
+		mcp.WithString("streamName", mcp.Required(), mcp.Description("Name of the traces stream")),
+	}
+	options = append(options, timeRangeOptions("1h")...)
+	mcpServer.AddTool(mcp.NewTool("service_dependency_map", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		streamName := mcp.ParseString(req, "streamName", "")
+		if streamName == "" {
+			slog.Warn("called with missing parameter", "streamName", streamName, "tool", "service_dependency_map")
+			return mcp.NewToolResultError("missing required field: streamName"), nil
+		}
+		startTime, endTime, err := timeRangeArguments(req, time.Hour)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		types, err := telemetryStreamFields(ctx, streamName, "traces")
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "service_dependency_map")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		f, err := resolveTraceFields(types)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if f.Service == "" {
+			return mcp.NewToolResultError("the stream schema has no service name field such as service.name"), nil
+		}
+
+		stream := quoteIdent(streamName)
+		service := func(alias string) string { return f.column(alias, f.Service) }
+		edgesQuery := fmt.Sprintf(`SELECT %s AS caller, %s AS callee, COUNT(*) AS calls, %s AS errors, `+
+			`AVG(%s) / 1000000.0 AS avg_duration_ms, MAX(%s) / 1000000.0 AS max_duration_ms `+
+			`FROM %s AS c JOIN %s AS p ON %s = %s AND %s = %s `+
+			`WHERE CAST(%s AS VARCHAR) <> CAST(%s AS VARCHAR) GROUP BY %s, %s ORDER BY calls DESC LIMIT %d`,
+			service("p"), service("c"), f.errorCountExpr("c"),
+			f.durationNanosExpr("c"), f.durationNanosExpr("c"),
+			stream, stream, f.column("c", f.ParentID), f.column("p", f.SpanID), f.column("c", f.TraceID), f.column("p", f.TraceID),
+			service("p"), service("c"), service("p"), service("c"), maxDependencyEdges)
+		servicesQuery := fmt.Sprintf("SELECT %s AS service, COUNT(*) AS spans, %s AS errors FROM %s GROUP BY %s",
+			service(""), f.errorCountExpr(""), stream, service(""))
+
+		recordQueryLookback(streamName, startTime)
+		edgeRows, err := doParseableQuery(ctx, edgesQuery, streamName, startTime, endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "service_dependency_map", "query", edgesQuery)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+		serviceRows, err := doParseableQuery(ctx, servicesQuery, streamName, startTime, endTime)
+		if err != nil {
+			slog.Error("failed to get response", "streamName", streamName, "error", err, "tool", "service_dependency_map", "query", servicesQuery)
+			return mcp.NewToolResultError("query failed: " + err.Error()), nil
+		}
+
+		// Redact each service name once, under the service field so that its rules apply
+		var raw []string
+		for _, row := range serviceRows {
+			raw = append(raw, stringValue(row["service"]))
+		}
+		for _, row := range edgeRows {
+			raw = append(raw, stringValue(row["caller"]), stringValue(row["callee"]))
+		}
+		slices.Sort(raw)
+		raw = slices.Compact(raw)
+		nameRows := make([]map[string]interface{}, len(raw))
+		for i, name := range raw {
+			nameRows[i] = map[string]interface{}{"service": name}
+		}
+		report, err := redactAliasedRows(ctx, streamName, nameRows, map[string]string{"service": f.Service})
+		if err != nil {
+			slog.Error("failed to redact rows", "streamName", streamName, "error", err, "tool", "service_dependency_map")
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		names := make(map[string]string, len(raw))
+		for i, name := range raw {
+			names[name] = redactedSecret
+			if _, ok := nameRows[i]["service"]; ok {
+				names[name] = stringValue(nameRows[i]["service"])
+			}
+		}
+
+		type serviceNode struct {
+			Spans    interface{} `json:"spans"`
+			Errors   interface{} `json:"errors"`
+			Calls    []string    `json:"calls"`
+			CalledBy []string    `json:"calledBy"`
+		}
+		nodes := map[string]*serviceNode{}
+		node := func(name string) *serviceNode {
+			if nodes[name] == nil {
+				nodes[name] = &serviceNode{Spans: 0, Errors: 0, Calls: []string{}, CalledBy: []string{}}
+			}
+			return nodes[name]
+		}
+		for _, row := range serviceRows {
+			n := node(names[stringValue(row["service"])])
+			n.Spans, n.Errors = row["spans"], row["errors"]
+		}
+		edges := make([]map[string]interface{}, 0, len(edgeRows))
+		for _, row := range edgeRows {
+			caller, callee := names[stringValue(row["caller"])], names[stringValue(row["callee"])]
+			node(caller).Calls = append(node(caller).Calls, callee)
+			node(callee).CalledBy = append(node(callee).CalledBy, caller)
+			calls, _ := row["calls"].(float64)
+			errors, _ := row["errors"].(float64)
+			edge := map[string]interface{}{
+				"caller":        caller,
+				"callee":        callee,
+				"calls":         row["calls"],
+				"errors":        row["errors"],
+				"errorRate":     0.0,
+				"avgDurationMs": row["avg_duration_ms"],
+				"maxDurationMs": row["max_duration_ms"],
+			}
+			if calls > 0 {
+				edge["errorRate"] = roundTo(errors/calls, 4)
+			}
+			edges = append(edges, edge)
+		}
+		entrypoints := []string{}
+		for name, n := range nodes {
+			sort.Strings(n.Calls)
+			sort.Strings(n.CalledBy)
+			if len(n.CalledBy) == 0 {
+				entrypoints = append(entrypoints, name)
+			}
+		}
+		sort.Strings(entrypoints)
+
+		result := map[string]interface{}{
+			"edges":       edges,
+			"services":    nodes,
+			"entrypoints": entrypoints,
+			"count":       len(edges),
+			"truncated":   len(edges) == maxDependencyEdges,
+			"query":       edgesQuery,
+		}
+		if report != nil {
+			result["redactions"] = report
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
+)
+
+// UserGroup is a Parseable user group: users who share the roles of the group. Groups are often
+// synced from the groups of an OIDC provider.
+type UserGroup struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+	Users []string `json:"users"`
+}
+
+// parseUserGroup reads a group from /api/v1/usergroup. Roles are names or a map of name to
+// privileges, users are names or objects with a userId or username.
+func parseUserGroup(m map[string]interface{}) UserGroup {
+	group := UserGroup{Name: firstString(m, "name", "id"), Roles: userRoleNames(m), Users: []string{}}
+	users, _ := m["users"].([]interface{})
+	for _, user := range users {
+		switch u := user.(type) {
+		case string:
+			group.Users = append(group.Users, u)
+		case map[string]interface{}:
+			if name := firstString(u, "username", "userId", "id"); name != "" {
+				group.Users = append(group.Users, name)
+			}
+		}
+	}
+	group.Users = sortedUnique(group.Users)
+	return group
+}
+
+func getUserGroups(ctx context.Context) ([]UserGroup, error) {
+	list, err := getParseableUserGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]UserGroup, 0, len(list))
+	for _, m := range list {
+		groups = append(groups, parseUserGroup(m))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func findUserGroup(groups []UserGroup, name string) *UserGroup {
+	for i := range groups {
+		if groups[i].Name == name {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+func userGroupPath(name string) string {
+	return "/api/v1/usergroup/" + url.PathEscape(name)
+}
+
+func RegisterListUserGroupsTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"list_user_groups",
+		mcp.WithDescription(`List the user groups of the Parseable instance. Members of a group get the roles of the group,
+in addition to their own roles. Calls /api/v1/usergroup.
+
+Returns a JSON object with a 'groups' array, each with 'name', 'roles' (role names) and 'users' (member usernames), plus 'count'.
+If redaction is configured, usernames are redacted like those of get_users and 'redactions' reports the counts.
+`),
+		mcp.WithString("username", mcp.Description("Optional user to only list the groups of")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		username := mcp.ParseString(req, "username", "")
+		groups, err := getUserGroups(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "list_user_groups")
+			return mcp.NewToolResultError("failed to list user groups: " + err.Error()), nil
+		}
+		if username != "" {
+			groups = slices.DeleteFunc(groups, func(g UserGroup) bool { return !slices.Contains(g.Users, username) })
+		}
+		result := map[string]interface{}{
+			"groups": groups,
+			"count":  len(groups),
+		}
+		if ResultRedactor != nil {
+			var redactions redact.Report
+			for _, group := range groups {
+				for i, member := range group.Users {
+					group.Users[i] = redactUsername(member, &redactions)
+				}
+			}
+			result["redactions"] = redactions
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+func RegisterGetUserGroupTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"get_user_group",
+		mcp.WithDescription(`Get a user group with the privileges its roles grant to its members.
+
+Returns a JSON object with:
+- name, roles, users: the group, its role names and member usernames
+- privileges: per role, its privileges, e.g. "reader on payments"
+- globalPrivileges and streams: the access the group grants, on all streams and per stream
+- unknownRoles: roles of the group that do not exist
+- unknownUsers: members that are not Parseable users
+If redaction is configured, usernames are redacted like those of get_users and 'redactions' reports the counts.
+`),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Name of the group")),
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := mcp.ParseString(req, "name", "")
+		if name == "" {
+			slog.Warn("called with missing parameter", "name", name, "tool", "get_user_group")
+			return mcp.NewToolResultError("missing required field: name"), nil
+		}
+		groups, err := getUserGroups(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_user_group")
+			return mcp.NewToolResultError("failed to list user groups: " + err.Error()), nil
+		}
+		group := findUserGroup(groups, name)
+		if group == nil {
+			return mcp.NewToolResultError(fmt.Sprintf("user group %q not found", name)), nil
+		}
+		roles, err := getParseableRoles(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_user_group")
+			return mcp.NewToolResultError("failed to get roles: " + err.Error()), nil
+		}
+		users, err := getParseableUsers(ctx)
+		if err != nil {
+			slog.Error("failed to get response", "error", err, "tool", "get_user_group")
+			return mcp.NewToolResultError("failed to get users: " + err.Error()), nil
+		}
+
+		access := computeGroupAccess(*group, roles)
+		privileges := map[string][]string{}
+		for _, role := range group.Roles {
+			privileges[role] = rolePrivilegeNames(roles[role])
+		}
+		unknownUsers := []string{}
+		for _, member := range group.Users {
+			if findUser(users, member) == nil {
+				unknownUsers = append(unknownUsers, member)
+			}
+		}
+		result := map[string]interface{}{
+			"name":             group.Name,
+			"roles":            group.Roles,
+			"users":            group.Users,
+			"privileges":       privileges,
+			"globalPrivileges": access.Global,
+			"streams":          access.Streams,
+			"unknownRoles":     access.UnknownRoles,
+			"unknownUsers":     unknownUsers,
+		}
+		if ResultRedactor != nil {
+			var redactions redact.Report
+			for _, members := range [][]string{group.Users, unknownUsers} {
+				for i, member := range members {
+					members[i] = redactUsername(member, &redactions)
+				}
+			}
+			result["redactions"] = redactions
+		}
+		return mcp.NewToolResultJSON(result)
+	})
+}
+
+// groupMembersTool registers add_group_members or remove_group_members, which PATCH the users
+// of a group through /api/v1/usergroup/<name>/add or /remove.
+func groupMembersTool(mcpServer *server.MCPServer, tool string, add bool) {
+	verb, endpoint := "Remove users from", "remove"
+	if add {
+		verb, endpoint = "Add users to", "add"
+	}
+	mcpServer.AddTool(mcp.NewTool(
+		tool,
+		withPlanOptions(
+			mcp.WithDescription(verb+` a user group. Members get the roles of the group, so this changes their access;
+the plan lists the privileges of the group. Calls PATCH /api/v1/usergroup/<name>/`+endpoint+`.
+
+`+planFlowDescription+`
+`),
+			mcp.WithString("name", mcp.Required(), mcp.Description("Name of the group")),
+			mcp.WithArray("users", mcp.Required(), mcp.WithStringItems(), mcp.Description("Usernames, e.g. ['alice', 'bob']")),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := mcp.ParseString(req, "name", "")
+		members := sortedUnique(req.GetStringSlice("users", nil))
+		return planOrApply(ctx, req, tool, func() (*changePlan, error) {
+			if name == "" || len(members) == 0 {
+				return nil, fmt.Errorf("missing required fields: name and users are required")
+			}
+			groups, err := getUserGroups(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list user groups: %w", err)
+			}
+			group := findUserGroup(groups, name)
+			if group == nil {
+				return nil, fmt.Errorf("user group %q not found", name)
+			}
+			roles, err := getParseableRoles(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get roles: %w", err)
+			}
+			users, err := getParseableUsers(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get users: %w", err)
+			}
+
+			after := slices.Clone(group.Users)
+			plan := &changePlan{
+				Action: tool,
+				Target: name,
+				Before: map[string]interface{}{"group": name, "roles": group.Roles, "users": group.Users},
+			}
+			for _, member := range members {
+				isMember := slices.Contains(group.Users, member)
+				switch {
+				case add && isMember, !add && !isMember:
+					continue
+				case add:
+					if findUser(users, member) == nil {
+						return nil, fmt.Errorf("user %q not found", member)
+					}
+					after = append(after, member)
+					plan.Changes = append(plan.Changes, "add "+member)
+				default:
+					after = slices.DeleteFunc(after, func(u string) bool { return u == member })
+					plan.Changes = append(plan.Changes, "remove "+member)
+				}
+			}
+			if len(plan.Changes) == 0 {
+				return nil, fmt.Errorf("nothing to change: the users are already members of %q, or not members", name)
+			}
+			sort.Strings(after)
+			plan.After = map[string]interface{}{"group": name, "roles": group.Roles, "users": after}
+
+			access := computeGroupAccess(*group, roles)
+			summary := groupAccessSummary(access)
+			if add {
+				plan.Warnings = append(plan.Warnings, "added users gain "+summary)
+			} else {
+				plan.Warnings = append(plan.Warnings, "removed users lose "+summary+", unless they hold it through other roles")
+				if slices.Contains(members, ParseableUser) && slices.Contains(group.Users, ParseableUser) {
+					plan.Warnings = append(plan.Warnings, "includes the user this server connects as")
+				}
+			}
+			if access.Admin && add {
+				plan.Warnings = append(plan.Warnings, "the group grants admin")
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			body := map[string]interface{}{"users": members}
+			return nil, doParseableRequest(ctx, "PATCH", userGroupPath(name)+"/"+endpoint, body, nil)
+		})
+	})
+}
+
+// groupAccessSummary describes the access a group grants, e.g. "reader on payments; editor on all streams".
+func groupAccessSummary(access groupAccess) string {
+	var parts []string
+	if len(access.Global) > 0 {
+		parts = append(parts, strings.Join(access.Global, ", ")+" on all streams")
+	}
+	streams := make([]string, 0, len(access.Streams))
+	for stream := range access.Streams {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	for _, stream := range streams {
+		parts = append(parts, strings.Join(access.Streams[stream], ", ")+" on "+stream)
+	}
+	if len(parts) == 0 {
+		return "no privileges"
+	}
+	return strings.Join(parts, "; ")
+}
+
+func RegisterAddGroupMembersTool(mcpServer *server.MCPServer) {
+	groupMembersTool(mcpServer, "add_group_members", true)
+}
+
+func RegisterRemoveGroupMembersTool(mcpServer *server.MCPServer) {
+	groupMembersTool(mcpServer, "remove_group_members", false)
+}
@@ -6,8 +6,26 @@ import (
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+
+	"mcp-pb/redact"
 )
 
+// redactUsername redacts a username with the rules get_users applies to the username field of
+// a user, since OIDC usernames are usually email addresses. The counts are added to report.
+func redactUsername(username string, report *redact.Report) string {
+	if ResultRedactor == nil || username == "" {
+		return username
+	}
+	user := map[string]interface{}{"username": username}
+	_, redactions := ResultRedactor.Value(user)
+	report.Merge(&redactions)
+	redacted, ok := user["username"].(string)
+	if !ok {
+		return redactedSecret
+	}
+	return redacted
+}
+
 func RegisterGetUsersTool(mcpServer *server.MCPServer) {
 	mcpServer.AddTool(mcp.NewTool(
 		"get_users",
@@ -38,17 +56,23 @@ Use this tool to:
 - Understand which users have access to specific data streams
 - Check authentication methods configured for users
 - Audit user-role-stream relationships
+
+If redaction is configured, sensitive values such as emails are masked or hashed and 'redactions' reports the 'total' count and counts 'byRule'.
 `),
 	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		users, err := getParseableUsers()
+		users, err := getParseableUsers(ctx)
 		if err != nil {
 			slog.Error("failed to get users", "error", err)
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		return mcp.NewToolResultJSON(map[string]interface{}{
+		result := map[string]interface{}{
 			"users": users,
 			"count": len(users),
-		})
+		}
+		if ResultRedactor != nil {
+			_, result["redactions"] = ResultRedactor.Value(users)
+		}
+		return mcp.NewToolResultJSON(result)
 	})
 }
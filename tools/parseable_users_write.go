@@ -0,0 +1,570 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var (
+	// Privileges of a Parseable role. Stream privileges apply to one stream each.
+	rolePrivilegeTypes   = []string{"admin", "editor", "reader", "writer", "ingestor"}
+	streamPrivilegeTypes = []string{"reader", "writer", "ingestor"}
+	principalNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// RolePrivilege is a privilege of a role as sent to PUT /api/v1/role/<name>.
+type RolePrivilege struct {
+	Privilege string        `json:"privilege"`
+	Resource  *RoleResource `json:"resource,omitempty"`
+}
+
+type RoleResource struct {
+	Stream string `json:"stream"`
+}
+
+func (p RolePrivilege) String() string {
+	if p.Resource != nil && p.Resource.Stream != "" {
+		return p.Privilege + " on " + p.Resource.Stream
+	}
+	return p.Privilege
+}
+
+func userPath(username string) string {
+	return "/api/v1/user/" + url.PathEscape(username)
+}
+
+func rolePath(name string) string {
+	return "/api/v1/role/" + url.PathEscape(name)
+}
+
+// findUser returns the user with the given id or username from the /api/v1/users list.
+func findUser(users []map[string]interface{}, username string) map[string]interface{} {
+	for _, user := range users {
+		if user["id"] == username || user["username"] == username {
+			return user
+		}
+	}
+	return nil
+}
+
+// userRoleNames returns the sorted names of the roles assigned directly to a user. Roles are a
+// map of role name to privileges, or a list of names in older Parseable versions.
+func userRoleNames(user map[string]interface{}) []string {
+	names := []string{}
+	switch roles := user["roles"].(type) {
+	case map[string]interface{}:
+		for name := range roles {
+			names = append(names, name)
+		}
+	case []interface{}:
+		for _, name := range roles {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userState is the state of a user shown in plans.
+func userState(user map[string]interface{}) map[string]interface{} {
+	if user == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"username": firstString(user, "username", "id"),
+		"method":   user["method"],
+		"roles":    userRoleNames(user),
+	}
+}
+
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := m[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// usersWithRole returns the sorted names of users the role is assigned to directly.
+func usersWithRole(users []map[string]interface{}, role string) []string {
+	var names []string
+	for _, user := range users {
+		if slices.Contains(userRoleNames(user), role) {
+			names = append(names, firstString(user, "username", "id"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkRolesExist returns an error naming the roles Parseable does not have.
+func checkRolesExist(ctx context.Context, roleNames []string) error {
+	roles, err := getParseableRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get roles: %w", err)
+	}
+	var unknown []string
+	for _, name := range roleNames {
+		if _, ok := roles[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown roles: %s; create them with create_role first", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// lookupUser returns the current state of the user to change, or nil if there is no such user.
+func lookupUser(ctx context.Context, username string) (map[string]interface{}, error) {
+	if username == "" {
+		return nil, fmt.Errorf("missing required field: username")
+	}
+	users, err := getParseableUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return findUser(users, username), nil
+}
+
+// sortedUnique returns the non-empty names sorted and without duplicates.
+func sortedUnique(names []string) []string {
+	out := []string{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return slices.Compact(out)
+}
+
+func RegisterCreateUserTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"create_user",
+		withPlanOptions(
+			mcp.WithDescription(`Create a native Parseable user with the given roles. Calls POST /api/v1/user/<username>.
+The roles must exist already (see get_roles and create_role).
+
+`+planFlowDescription+`
+
+When applied, returns 'password': the generated password of the new user. It is only shown this once; pass it to the user
+over a safe channel.
+`),
+			mcp.WithString("username", mcp.Required(), mcp.Description("Name of the new user")),
+			mcp.WithArray("roles", mcp.WithStringItems(), mcp.Description("Optional names of the roles to assign, e.g. ['payments-reader']")),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		username := mcp.ParseString(req, "username", "")
+		roles := sortedUnique(req.GetStringSlice("roles", nil))
+		return planOrApply(ctx, req, "create_user", func() (*changePlan, error) {
+			if username != "" && !principalNamePattern.MatchString(username) {
+				return nil, fmt.Errorf("invalid username %q: use letters, digits, '_', '-', '.' and '@'", username)
+			}
+			user, err := lookupUser(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			if user != nil {
+				return nil, fmt.Errorf("user %q already exists; use assign_roles to change its roles", username)
+			}
+			if err := checkRolesExist(ctx, roles); err != nil {
+				return nil, err
+			}
+			plan := &changePlan{
+				Action:   "create_user",
+				Target:   username,
+				After:    map[string]interface{}{"username": username, "method": "native", "roles": roles},
+				Changes:  []string{"create native user " + username},
+				Warnings: []string{"a password is generated and returned only once when the change is applied"},
+			}
+			for _, role := range roles {
+				plan.Changes = append(plan.Changes, "assign role "+role)
+			}
+			if len(roles) == 0 {
+				plan.Warnings = append(plan.Warnings, "the user has no roles and cannot access anything until roles are assigned")
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			password, err := doParseableRequestText(ctx, "POST", userPath(username), roles)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"password": password,
+				"note":     "the password is only shown this once",
+			}, nil
+		})
+	})
+}
+
+func RegisterDeleteUserTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_user",
+		withPlanOptions(
+			mcp.WithDescription(`Delete a Parseable user. Calls DELETE /api/v1/user/<username>.
+The user this server connects as cannot be deleted.
+
+`+planFlowDescription+`
+`),
+			mcp.WithString("username", mcp.Required(), mcp.Description("Name of the user to delete")),
+			mcp.WithDestructiveHintAnnotation(true),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		username := mcp.ParseString(req, "username", "")
+		return planOrApply(ctx, req, "delete_user", func() (*changePlan, error) {
+			user, err := lookupUser(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			if user == nil {
+				return nil, fmt.Errorf("user %q not found", username)
+			}
+			if username == ParseableUser {
+				return nil, fmt.Errorf("user %q is the user this server connects as and cannot be deleted", username)
+			}
+			plan := &changePlan{
+				Action:  "delete_user",
+				Target:  username,
+				Before:  userState(user),
+				Changes: []string{"delete user " + username},
+			}
+			if roles := userRoleNames(user); len(roles) > 0 {
+				plan.Changes = append(plan.Changes, "remove roles "+strings.Join(roles, ", "))
+			}
+			if user["method"] == "oidc" {
+				plan.Warnings = append(plan.Warnings, "this is an OIDC user, who is created again on their next login")
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			return nil, doParseableRequest(ctx, "DELETE", userPath(username), nil, nil)
+		})
+	})
+}
+
+func RegisterResetPasswordTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"reset_password",
+		withPlanOptions(
+			mcp.WithDescription(`Generate a new password for a native Parseable user. The old password stops working.
+Calls POST /api/v1/user/<username>/generate-new-password.
+
+`+planFlowDescription+`
+
+When applied, returns 'password': the new password. It is only shown this once; pass it to the user over a safe channel.
+`),
+			mcp.WithString("username", mcp.Required(), mcp.Description("Name of the user")),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		username := mcp.ParseString(req, "username", "")
+		return planOrApply(ctx, req, "reset_password", func() (*changePlan, error) {
+			user, err := lookupUser(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			if user == nil {
+				return nil, fmt.Errorf("user %q not found", username)
+			}
+			if method, _ := user["method"].(string); method != "" && method != "native" {
+				return nil, fmt.Errorf("user %q signs in with %s and has no Parseable password", username, method)
+			}
+			plan := &changePlan{
+				Action:   "reset_password",
+				Target:   username,
+				Before:   userState(user),
+				After:    userState(user),
+				Changes:  []string{"replace the password of " + username},
+				Warnings: []string{"the current password stops working immediately"},
+			}
+			if username == ParseableUser {
+				plan.Warnings = append(plan.Warnings, "this is the user this server connects as; the server stops working until it is restarted with the new password")
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			password, err := doParseableRequestText(ctx, "POST", userPath(username)+"/generate-new-password", nil)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"password": password,
+				"note":     "the password is only shown this once",
+			}, nil
+		})
+	})
+}
+
+func RegisterAssignRolesTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"assign_roles",
+		withPlanOptions(
+			mcp.WithDescription(`Set the roles of a Parseable user. The given roles replace the current ones, so include the roles
+to keep. Calls PUT /api/v1/user/<username>/role.
+
+`+planFlowDescription+`
+`),
+			mcp.WithString("username", mcp.Required(), mcp.Description("Name of the user")),
+			mcp.WithArray("roles", mcp.Required(), mcp.WithStringItems(), mcp.Description("All roles the user should have, e.g. ['payments-reader', 'editor']")),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		username := mcp.ParseString(req, "username", "")
+		roles := sortedUnique(req.GetStringSlice("roles", nil))
+		return planOrApply(ctx, req, "assign_roles", func() (*changePlan, error) {
+			user, err := lookupUser(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			if user == nil {
+				return nil, fmt.Errorf("user %q not found", username)
+			}
+			if err := checkRolesExist(ctx, roles); err != nil {
+				return nil, err
+			}
+			current := userRoleNames(user)
+			after := userState(user)
+			after["roles"] = roles
+			plan := &changePlan{
+				Action: "assign_roles",
+				Target: username,
+				Before: userState(user),
+				After:  after,
+			}
+			for _, role := range roles {
+				if !slices.Contains(current, role) {
+					plan.Changes = append(plan.Changes, "add role "+role)
+				}
+			}
+			for _, role := range current {
+				if !slices.Contains(roles, role) {
+					plan.Changes = append(plan.Changes, "remove role "+role)
+				}
+			}
+			if len(plan.Changes) == 0 {
+				return nil, fmt.Errorf("user %q already has exactly these roles, nothing to change", username)
+			}
+			if len(roles) == 0 {
+				plan.Warnings = append(plan.Warnings, "the user is left without roles and cannot access anything")
+			}
+			if username == ParseableUser {
+				plan.Warnings = append(plan.Warnings, "this is the user this server connects as; removing its access breaks this server")
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			return nil, doParseableRequest(ctx, "PUT", userPath(username)+"/role", roles, nil)
+		})
+	})
+}
+
+// rolePrivilegesArgument reads the privileges argument of create_role.
+func rolePrivilegesArgument(req mcp.CallToolRequest) ([]RolePrivilege, error) {
+	raw, _ := req.GetArguments()["privileges"].([]interface{})
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("missing required field: privileges")
+	}
+	privileges := make([]RolePrivilege, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("privilege %d is not an object", i)
+		}
+		privilege, _ := m["privilege"].(string)
+		stream, _ := m["stream"].(string)
+		if !slices.Contains(rolePrivilegeTypes, privilege) {
+			return nil, fmt.Errorf("privilege %d: %q is not one of %s", i, privilege, strings.Join(rolePrivilegeTypes, ", "))
+		}
+		p := RolePrivilege{Privilege: privilege}
+		switch {
+		case slices.Contains(streamPrivilegeTypes, privilege):
+			if stream == "" {
+				return nil, fmt.Errorf("privilege %d: %s needs a stream", i, privilege)
+			}
+			p.Resource = &RoleResource{Stream: stream}
+		case stream != "":
+			return nil, fmt.Errorf("privilege %d: %s applies to all streams and takes no stream", i, privilege)
+		}
+		privileges = append(privileges, p)
+	}
+	return privileges, nil
+}
+
+// rolePrivilegeNames describes the privileges of a role from /api/v1/roles, e.g. "reader on payments".
+func rolePrivilegeNames(privileges interface{}) []string {
+	names := []string{}
+	list, _ := privileges.([]interface{})
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p := RolePrivilege{Privilege: firstString(m, "privilege")}
+		if resource, ok := m["resource"].(map[string]interface{}); ok {
+			p.Resource = &RoleResource{Stream: firstString(resource, "stream")}
+		}
+		names = append(names, p.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func RegisterCreateRoleTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"create_role",
+		withPlanOptions(
+			mcp.WithDescription(`Create a role, or replace the privileges of an existing one. Calls PUT /api/v1/role/<name>.
+
+Privileges:
+- admin: full access
+- editor: manage streams, alerts and dashboards on all streams
+- reader, writer, ingestor: query, query and ingest, or only ingest one stream; these need a stream
+
+`+planFlowDescription+`
+`),
+			mcp.WithString("name", mcp.Required(), mcp.Description("Name of the role")),
+			mcp.WithArray("privileges", mcp.Required(), mcp.Description("Privileges of the role, e.g. [{\"privilege\": \"reader\", \"stream\": \"payments\"}]"),
+				mcp.Items(map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"privilege": map[string]interface{}{"type": "string", "enum": rolePrivilegeTypes},
+						"stream":    map[string]interface{}{"type": "string", "description": "Stream, for reader, writer and ingestor"},
+					},
+					"required": []string{"privilege"},
+				})),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := mcp.ParseString(req, "name", "")
+		privileges, privilegesErr := rolePrivilegesArgument(req)
+		return planOrApply(ctx, req, "create_role", func() (*changePlan, error) {
+			if name == "" {
+				return nil, fmt.Errorf("missing required field: name")
+			}
+			if !principalNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid role name %q: use letters, digits, '_', '-', '.' and '@'", name)
+			}
+			if privilegesErr != nil {
+				return nil, privilegesErr
+			}
+			roles, err := getParseableRoles(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get roles: %w", err)
+			}
+			after := make([]string, 0, len(privileges))
+			for _, p := range privileges {
+				after = append(after, p.String())
+			}
+			after = sortedUnique(after)
+			plan := &changePlan{
+				Action: "create_role",
+				Target: name,
+				After:  map[string]interface{}{"role": name, "privileges": after},
+			}
+			if current, ok := roles[name]; ok {
+				before := rolePrivilegeNames(current)
+				plan.Action = "update_role"
+				plan.Before = map[string]interface{}{"role": name, "privileges": before}
+				for _, p := range after {
+					if !slices.Contains(before, p) {
+						plan.Changes = append(plan.Changes, "grant "+p)
+					}
+				}
+				for _, p := range before {
+					if !slices.Contains(after, p) {
+						plan.Changes = append(plan.Changes, "revoke "+p)
+					}
+				}
+				if len(plan.Changes) == 0 {
+					return nil, fmt.Errorf("role %q already has exactly these privileges, nothing to change", name)
+				}
+				if users, err := getParseableUsers(ctx); err == nil {
+					if holders := usersWithRole(users, name); len(holders) > 0 {
+						plan.Warnings = append(plan.Warnings, "changes the access of users "+strings.Join(holders, ", "))
+					}
+				}
+			} else {
+				plan.Changes = append(plan.Changes, "create role "+name)
+				for _, p := range after {
+					plan.Changes = append(plan.Changes, "grant "+p)
+				}
+			}
+
+			streams, err := listParseableStreams(ctx)
+			if err == nil {
+				known := map[string]bool{}
+				for _, s := range streams {
+					known[firstString(s, "name")] = true
+				}
+				for _, p := range privileges {
+					if p.Resource != nil && !known[p.Resource.Stream] {
+						plan.Warnings = append(plan.Warnings, fmt.Sprintf("stream %q does not exist (yet)", p.Resource.Stream))
+					}
+				}
+			}
+			for _, p := range privileges {
+				if p.Privilege == "admin" {
+					plan.Warnings = append(plan.Warnings, "admin grants full access, including managing users and roles")
+					break
+				}
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			return nil, doParseableRequest(ctx, "PUT", rolePath(name), privileges, nil)
+		})
+	})
+}
+
+func RegisterDeleteRoleTool(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"delete_role",
+		withPlanOptions(
+			mcp.WithDescription(`Delete a role. Calls DELETE /api/v1/role/<name>. Users holding the role lose its privileges.
+
+`+planFlowDescription+`
+`),
+			mcp.WithString("name", mcp.Required(), mcp.Description("Name of the role")),
+			mcp.WithDestructiveHintAnnotation(true),
+		)...,
+	), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name := mcp.ParseString(req, "name", "")
+		return planOrApply(ctx, req, "delete_role", func() (*changePlan, error) {
+			if name == "" {
+				return nil, fmt.Errorf("missing required field: name")
+			}
+			roles, err := getParseableRoles(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get roles: %w", err)
+			}
+			current, ok := roles[name]
+			if !ok {
+				return nil, fmt.Errorf("role %q not found", name)
+			}
+			users, err := getParseableUsers(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get users: %w", err)
+			}
+			plan := &changePlan{
+				Action:  "delete_role",
+				Target:  name,
+				Before:  map[string]interface{}{"role": name, "privileges": rolePrivilegeNames(current)},
+				Changes: []string{"delete role " + name},
+			}
+			if holders := usersWithRole(users, name); len(holders) > 0 {
+				plan.Warnings = append(plan.Warnings, "assigned to users "+strings.Join(holders, ", ")+"; they lose its privileges")
+				if slices.Contains(holders, ParseableUser) {
+					plan.Warnings = append(plan.Warnings, "includes the user this server connects as")
+				}
+			}
+			return plan, nil
+		}, func() (map[string]interface{}, error) {
+			return nil, doParseableRequest(ctx, "DELETE", rolePath(name), nil, nil)
+		})
+	})
+}
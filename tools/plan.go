@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// How long a confirmation token of a planned change stays valid
+const planTokenTTL = 10 * time.Minute
+
+// planKey signs confirmation tokens. It is generated per process, so tokens do not survive a
+// restart of the server.
+var planKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// changePlan is the first step of a plan then apply write: what the change does to the
+// current state. Applying it needs the confirmation token returned with the plan.
+type changePlan struct {
+	Action   string      `json:"action"`
+	Target   string      `json:"target"`
+	Before   interface{} `json:"before"`
+	After    interface{} `json:"after"`
+	Changes  []string    `json:"changes"`
+	Warnings []string    `json:"warnings,omitempty"`
+	// Set when the plan is returned
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+	ExpiresAt         string `json:"expiresAt,omitempty"`
+	WritesEnabled     bool   `json:"writesEnabled"`
+}
+
+// planSignature signs the tool, its arguments without the token and the planned before and
+// after state, so a token only applies the exact change that was shown, and only while the
+// state is unchanged.
+func planSignature(tool string, req mcp.CallToolRequest, plan *changePlan, expires int64) (string, error) {
+	args := map[string]interface{}{}
+	for key, value := range req.GetArguments() {
+		if key != "confirmationToken" {
+			args[key] = value
+		}
+	}
+	payload, err := json.Marshal([]interface{}{tool, args, plan.Before, plan.After, expires})
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, planKey)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func checkPlanToken(token string, tool string, req mcp.CallToolRequest, plan *changePlan) error {
+	expiresText, signature, ok := strings.Cut(token, ".")
+	expires, err := strconv.ParseInt(expiresText, 10, 64)
+	if !ok || err != nil {
+		return fmt.Errorf("malformed confirmation token")
+	}
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("confirmation token expired")
+	}
+	want, err := planSignature(tool, req, plan, expires)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return fmt.Errorf("confirmation token does not match this change: the arguments or the current state differ from the plan")
+	}
+	return nil
+}
+
+// withPlanOptions adds the options of the plan then apply flow to the options of a tool.
+func withPlanOptions(opts ...mcp.ToolOption) []mcp.ToolOption {
+	return append(opts,
+		mcp.WithString("confirmationToken", mcp.Description("Token returned by the plan. Leave empty to get the plan; "+
+			"pass it with the same arguments to apply the change once the user approved it")),
+	)
+}
+
+const planFlowDescription = `This is a two step tool. Called without confirmationToken it changes nothing and returns the plan:
+action, target, before and after state, the list of changes, warnings and a confirmationToken valid for 10 minutes.
+Show the plan to the user. Once they approve, call the tool again with the same arguments and the confirmationToken to apply it.
+The token is rejected if the arguments or the current state changed since the plan. Applying requires writes to be enabled on the server.`
+
+// planOrApply runs the plan then apply flow. plan computes the change from the current state;
+// without a token the plan is returned, with a valid token apply makes the change.
+func planOrApply(ctx context.Context, req mcp.CallToolRequest, tool string,
+	plan func() (*changePlan, error), apply func() (map[string]interface{}, error)) (*mcp.CallToolResult, error) {
+	p, err := plan()
+	if err != nil {
+		slog.Warn("failed to plan change", "error", err, "tool", tool)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	token := mcp.ParseString(req, "confirmationToken", "")
+	if token == "" {
+		expires := time.Now().Add(planTokenTTL).Unix()
+		signature, err := planSignature(tool, req, p, expires)
+		if err != nil {
+			return mcp.NewToolResultError("failed to sign plan: " + err.Error()), nil
+		}
+		p.ConfirmationToken = strconv.FormatInt(expires, 10) + "." + signature
+		p.ExpiresAt = time.Unix(expires, 0).UTC().Format(time.RFC3339)
+		p.WritesEnabled = WritesEnabled
+		return mcp.NewToolResultJSON(p)
+	}
+	if !WritesEnabled {
+		return writesDisabled(tool), nil
+	}
+	if err := checkPlanToken(token, tool, req, p); err != nil {
+		slog.Warn("rejected confirmation token", "error", err, "tool", tool)
+		return mcp.NewToolResultError(err.Error() + "; call the tool without confirmationToken to plan again"), nil
+	}
+
+	result, err := apply()
+	if err != nil {
+		slog.Error("failed to get response", "error", err, "tool", tool)
+		return mcp.NewToolResultError("failed to apply change: " + err.Error()), nil
+	}
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	result["applied"] = true
+	result["action"] = p.Action
+	result["target"] = p.Target
+	result["changes"] = p.Changes
+	return mcp.NewToolResultJSON(result)
+}
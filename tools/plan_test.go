@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCheckPlanToken(t *testing.T) {
+	request := func(args map[string]interface{}) mcp.CallToolRequest {
+		var req mcp.CallToolRequest
+		req.Params.Arguments = args
+		return req
+	}
+	newPlan := func(roles ...string) *changePlan {
+		return &changePlan{
+			Action: "assign_roles",
+			Target: "alice",
+			Before: map[string]interface{}{"username": "alice", "roles": roles},
+			After:  map[string]interface{}{"username": "alice", "roles": []string{"reader"}},
+		}
+	}
+	sign := func(tool string, req mcp.CallToolRequest, plan *changePlan, expires time.Time) string {
+		signature, err := planSignature(tool, req, plan, expires.Unix())
+		if err != nil {
+			t.Fatalf("planSignature: %v", err)
+		}
+		return strconv.FormatInt(expires.Unix(), 10) + "." + signature
+	}
+
+	args := map[string]interface{}{"username": "alice", "roles": []interface{}{"reader"}}
+	req := request(args)
+	valid := sign("assign_roles", req, newPlan("editor"), time.Now().Add(planTokenTTL))
+
+	tests := []struct {
+		name    string
+		token   string
+		tool    string
+		req     mcp.CallToolRequest
+		plan    *changePlan
+		wantErr string
+	}{
+		{"valid", valid, "assign_roles", req, newPlan("editor"), ""},
+		{"token argument is not signed", valid, "assign_roles",
+			request(map[string]interface{}{"username": "alice", "roles": []interface{}{"reader"}, "confirmationToken": valid}), newPlan("editor"), ""},
+		{"expired", sign("assign_roles", req, newPlan("editor"), time.Now().Add(-time.Second)), "assign_roles", req, newPlan("editor"), "expired"},
+		{"changed arguments", valid, "assign_roles",
+			request(map[string]interface{}{"username": "alice", "roles": []interface{}{"admin"}}), newPlan("editor"), "does not match"},
+		{"added argument", valid, "assign_roles",
+			request(map[string]interface{}{"username": "alice", "roles": []interface{}{"reader"}, "extra": true}), newPlan("editor"), "does not match"},
+		{"changed before state", valid, "assign_roles", req, newPlan("editor", "writer"), "does not match"},
+		{"changed after state", valid, "assign_roles", req,
+			&changePlan{Before: newPlan("editor").Before, After: map[string]interface{}{"username": "alice", "roles": []string{"admin"}}}, "does not match"},
+		{"other tool", valid, "delete_user", req, newPlan("editor"), "does not match"},
+		{"extended expiry", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + valid[strings.Index(valid, "."):],
+			"assign_roles", req, newPlan("editor"), "does not match"},
+		{"malformed", "not-a-token", "assign_roles", req, newPlan("editor"), "malformed"},
+		{"empty signature", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10) + ".", "assign_roles", req, newPlan("editor"), "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPlanToken(tt.token, tt.tool, tt.req, tt.plan)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkPlanToken: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("checkPlanToken accepted the token, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("checkPlanToken = %q, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// errPromQLUnsupported marks PromQL that is valid but outside the subset promql_query supports.
+var errPromQLUnsupported = errors.New("unsupported PromQL")
+
+// promQLSupported summarizes the supported subset for error messages and the tool description.
+const promQLSupported = `selectors such as http_requests_total{job="api", code=~"5.."} (quoted metric and label names such as {"http.server.duration", "service.name"="api"} for OpenTelemetry names), ` +
+	`rate, irate and increase of a range selector such as [5m], sum, avg, min, max and count with by (...), ` +
+	`and histogram_quantile(0.95, sum by (le) (rate(x_bucket[5m])))`
+
+var (
+	promQLFunctions    = []string{"rate", "irate", "increase", "histogram_quantile"}
+	promQLAggregations = []string{"sum", "avg", "min", "max", "count"}
+	// Aggregations of PromQL that are not supported, to tell them apart from metric names
+	promQLOtherAggregations = []string{"topk", "bottomk", "quantile", "stddev", "stdvar", "count_values", "group", "limitk", "limit_ratio"}
+	promQLKeywords          = []string{"by", "without", "offset", "bool", "on", "ignoring", "group_left", "group_right", "and", "or", "unless"}
+)
+
+// promExpr is a node of a parsed PromQL expression.
+type promExpr interface {
+	promExpr()
+}
+
+// promSelector selects the series of a metric, over Range before each step if it is set.
+type promSelector struct {
+	Metric   string
+	Matchers []labelMatcher
+	Range    time.Duration
+}
+
+type promNumber struct {
+	Value float64
+}
+
+type promCall struct {
+	Func string
+	Args []promExpr
+}
+
+type promAggregation struct {
+	Op   string
+	By   []string
+	Expr promExpr
+}
+
+func (promSelector) promExpr()    {}
+func (promNumber) promExpr()      {}
+func (promCall) promExpr()        {}
+func (promAggregation) promExpr() {}
+
+type promTokenKind int
+
+const (
+	promEOF promTokenKind = iota
+	promIdent
+	promString
+	promNumberToken
+	promPunct
+	promOperator
+)
+
+type promToken struct {
+	Kind promTokenKind
+	Text string
+	Pos  int
+}
+
+func (t promToken) String() string {
+	if t.Kind == promEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at position %d", t.Text, t.Pos+1)
+}
+
+// lexPromQL splits a query into tokens. Identifiers may contain dots, so that OpenTelemetry
+// names can be written without quotes.
+func lexPromQL(query string) ([]promToken, error) {
+	var tokens []promToken
+	runes := []rune(query)
+	isIdent := func(r rune, first bool) bool {
+		return r == '_' || r == ':' || unicode.IsLetter(r) || (!first && (unicode.IsDigit(r) || r == '.'))
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case isIdent(r, true):
+			j := i
+			for j < len(runes) && isIdent(runes[j], false) {
+				j++
+			}
+			tokens = append(tokens, promToken{promIdent, string(runes[i:j]), i})
+			i = j
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			// Numbers and durations such as 0.95, 1e3 or 5m
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '.' ||
+				((runes[j] == '+' || runes[j] == '-') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, promToken{promNumberToken, string(runes[i:j]), i})
+			i = j
+		case r == '"' || r == '\'' || r == '`':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && r != '`' && j+1 < len(runes) {
+					j++
+					switch runes[j] {
+					case 'n':
+						b.WriteRune('\n')
+					case 't':
+						b.WriteRune('\t')
+					default:
+						b.WriteRune(runes[j])
+					}
+					continue
+				}
+				b.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, promToken{promString, b.String(), i})
+			i = j + 1
+		case strings.ContainsRune("(){}[],:@", r):
+			tokens = append(tokens, promToken{promPunct, string(r), i})
+			i++
+		case strings.ContainsRune("=!~+-*/%^<>", r):
+			op := string(r)
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); slices.Contains([]string{"=~", "!~", "!=", "==", ">=", "<="}, two) {
+					op = two
+				}
+			}
+			if op == "!" || op == "~" {
+				return nil, fmt.Errorf("unexpected %q at position %d", op, i+1)
+			}
+			tokens = append(tokens, promToken{promOperator, op, i})
+			i += len([]rune(op))
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", string(r), i+1)
+		}
+	}
+	return append(tokens, promToken{Kind: promEOF, Pos: len(runes)}), nil
+}
+
+type promParser struct {
+	tokens []promToken
+	pos    int
+}
+
+// parsePromQL parses a query of the supported PromQL subset. Errors for valid PromQL outside
+// the subset wrap errPromQLUnsupported.
+func parsePromQL(query string) (promExpr, error) {
+	tokens, err := lexPromQL(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &promParser{tokens: tokens}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.Kind != promEOF {
+		return nil, fmt.Errorf("unexpected %s", t)
+	}
+	return expr, nil
+}
+
+func (p *promParser) peek() promToken {
+	return p.tokens[p.pos]
+}
+
+func (p *promParser) next() promToken {
+	t := p.tokens[p.pos]
+	if t.Kind != promEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *promParser) is(kind promTokenKind, text string) bool {
+	t := p.peek()
+	return t.Kind == kind && t.Text == text
+}
+
+func (p *promParser) expect(kind promTokenKind, text string) error {
+	if t := p.next(); t.Kind != kind || t.Text != text {
+		return fmt.Errorf("expected %q, found %s", text, t)
+	}
+	return nil
+}
+
+func unsupportedPromQL(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errPromQLUnsupported, fmt.Sprintf(format, args...))
+}
+
+// expr parses an expression, rejecting binary operators after it.
+func (p *promParser) expr() (promExpr, error) {
+	expr, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.Kind == promOperator {
+		return nil, unsupportedPromQL("binary operator %s", t)
+	}
+	if t.Kind == promIdent && slices.Contains([]string{"and", "or", "unless"}, t.Text) {
+		return nil, unsupportedPromQL("set operator %s", t)
+	}
+	return expr, nil
+}
+
+func (p *promParser) unary() (promExpr, error) {
+	t := p.peek()
+	switch {
+	case t.Kind == promNumberToken:
+		p.next()
+		value, err := strconv.ParseFloat(t.Text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return promNumber{Value: value}, nil
+	case t.Kind == promOperator && (t.Text == "-" || t.Text == "+"):
+		return nil, unsupportedPromQL("unary operator %s", t)
+	case t.Kind == promString:
+		return nil, unsupportedPromQL("string literal %s", t)
+	case t.Kind == promPunct && t.Text == "(":
+		p.next()
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(promPunct, ")"); err != nil {
+			return nil, err
+		}
+		return expr, p.checkSuffix()
+	case t.Kind == promPunct && t.Text == "{":
+		return p.selector("")
+	case t.Kind == promIdent:
+		p.next()
+		switch {
+		case slices.Contains(promQLAggregations, t.Text) || slices.Contains(promQLOtherAggregations, t.Text):
+			if !p.is(promPunct, "(") && !p.is(promIdent, "by") && !p.is(promIdent, "without") {
+				return p.selector(t.Text)
+			}
+			if !slices.Contains(promQLAggregations, t.Text) {
+				return nil, unsupportedPromQL("aggregation %s (supported: %s)", t, strings.Join(promQLAggregations, ", "))
+			}
+			return p.aggregation(t.Text)
+		case p.is(promPunct, "("):
+			if !slices.Contains(promQLFunctions, t.Text) {
+				return nil, unsupportedPromQL("function %s (supported: %s)", t, strings.Join(promQLFunctions, ", "))
+			}
+			return p.call(t.Text)
+		case slices.Contains(promQLKeywords, t.Text):
+			return nil, fmt.Errorf("unexpected %s", t)
+		}
+		return p.selector(t.Text)
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// checkSuffix rejects subqueries, offset and @ modifiers after an expression.
+func (p *promParser) checkSuffix() error {
+	t := p.peek()
+	switch {
+	case t.Kind == promPunct && t.Text == "[":
+		return unsupportedPromQL("subquery or range of an expression %s", t)
+	case t.Kind == promIdent && t.Text == "offset":
+		return unsupportedPromQL("offset modifier %s", t)
+	case t.Kind == promPunct && t.Text == "@":
+		return unsupportedPromQL("@ modifier %s", t)
+	}
+	return nil
+}
+
+func (p *promParser) aggregation(op string) (promExpr, error) {
+	agg := promAggregation{Op: op}
+	grouping := func() error {
+		t := p.peek()
+		if t.Kind != promIdent || (t.Text != "by" && t.Text != "without") {
+			return nil
+		}
+		if t.Text == "without" {
+			return unsupportedPromQL("without %s, use by (...) instead", t)
+		}
+		p.next()
+		labels, err := p.labelList()
+		agg.By = append(agg.By, labels...)
+		return err
+	}
+	if err := grouping(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(promPunct, "("); err != nil {
+		return nil, err
+	}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.is(promPunct, ",") {
+		return nil, unsupportedPromQL("parameter of aggregation %q", op)
+	}
+	if err := p.expect(promPunct, ")"); err != nil {
+		return nil, err
+	}
+	agg.Expr = expr
+	if err := grouping(); err != nil {
+		return nil, err
+	}
+	return agg, p.checkSuffix()
+}
+
+func (p *promParser) labelList() ([]string, error) {
+	if err := p.expect(promPunct, "("); err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for !p.is(promPunct, ")") {
+		t := p.next()
+		if t.Kind != promIdent && t.Kind != promString {
+			return nil, fmt.Errorf("expected a label name, found %s", t)
+		}
+		labels = append(labels, t.Text)
+		if !p.is(promPunct, ",") {
+			break
+		}
+		p.next()
+	}
+	return labels, p.expect(promPunct, ")")
+}
+
+func (p *promParser) call(name string) (promExpr, error) {
+	if err := p.expect(promPunct, "("); err != nil {
+		return nil, err
+	}
+	call := promCall{Func: name}
+	for !p.is(promPunct, ")") {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if !p.is(promPunct, ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(promPunct, ")"); err != nil {
+		return nil, err
+	}
+	return call, p.checkSuffix()
+}
+
+// selector parses the matchers and range of a selector after its metric name, if any.
+func (p *promParser) selector(metric string) (promExpr, error) {
+	sel := promSelector{Metric: metric}
+	if p.is(promPunct, "{") {
+		p.next()
+		for !p.is(promPunct, "}") {
+			name := p.next()
+			if name.Kind != promIdent && name.Kind != promString {
+				return nil, fmt.Errorf("expected a label name, found %s", name)
+			}
+			op := p.peek()
+			if name.Kind == promString && (op.Kind != promOperator || op.Text == "==") {
+				// A quoted metric name, as in {"http.server.duration"}
+				if sel.Metric != "" {
+					return nil, fmt.Errorf("metric name given twice at %s", name)
+				}
+				sel.Metric = name.Text
+			} else {
+				p.next()
+				if op.Kind != promOperator || !slices.Contains([]string{"=", "!=", "=~", "!~"}, op.Text) {
+					return nil, fmt.Errorf("expected a label matcher operator, found %s", op)
+				}
+				value := p.next()
+				if value.Kind != promString {
+					return nil, fmt.Errorf("expected a quoted label value, found %s", value)
+				}
+				m := labelMatcher{Label: name.Text, Op: op.Text, Value: value.Text}
+				switch {
+				case m.Label == "__name__" && m.Op != "=":
+					return nil, unsupportedPromQL("matcher %s on the metric name, only __name__=\"...\" is", m)
+				case m.Label == "__name__" && sel.Metric != "" && sel.Metric != m.Value:
+					return nil, fmt.Errorf("metric name given twice at %s", name)
+				case m.Label == "__name__":
+					sel.Metric = m.Value
+				default:
+					if err := m.check(); err != nil {
+						return nil, err
+					}
+					sel.Matchers = append(sel.Matchers, m)
+				}
+			}
+			if !p.is(promPunct, ",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(promPunct, "}"); err != nil {
+			return nil, err
+		}
+	}
+	if sel.Metric == "" {
+		return nil, unsupportedPromQL("selector without a metric name at position %d", p.peek().Pos+1)
+	}
+	if p.is(promPunct, "[") {
+		p.next()
+		t := p.next()
+		if t.Kind != promNumberToken {
+			return nil, fmt.Errorf("expected a range duration such as 5m, found %s", t)
+		}
+		d, err := parseHumanDuration(t.Text)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid range duration %s", t)
+		}
+		sel.Range = d
+		if t := p.peek(); t.Text == ":" || (t.Kind == promIdent && strings.HasPrefix(t.Text, ":")) {
+			return nil, unsupportedPromQL("subquery %s", p.peek())
+		}
+		if err := p.expect(promPunct, "]"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, p.checkSuffix()
+}
@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePromQL(t *testing.T) {
+	rate := func(metric string, d time.Duration) promCall {
+		return promCall{Func: "rate", Args: []promExpr{promSelector{Metric: metric, Range: d}}}
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  promExpr
+	}{
+		{"metric", "http_requests_total", promSelector{Metric: "http_requests_total"}},
+		{
+			"matchers",
+			`http_requests_total{job="api", code=~"5..", path!="/health", method!~'GET|HEAD',}`,
+			promSelector{Metric: "http_requests_total", Matchers: []labelMatcher{
+				{Label: "job", Op: "=", Value: "api"},
+				{Label: "code", Op: "=~", Value: "5.."},
+				{Label: "path", Op: "!=", Value: "/health"},
+				{Label: "method", Op: "!~", Value: "GET|HEAD"},
+			}},
+		},
+		{"dotted name", "http.server.duration", promSelector{Metric: "http.server.duration"}},
+		{
+			"quoted names",
+			`{"http.server.duration", "service.name"="api"}`,
+			promSelector{Metric: "http.server.duration", Matchers: []labelMatcher{{Label: "service.name", Op: "=", Value: "api"}}},
+		},
+		{"__name__ matcher", `{__name__="up"}`, promSelector{Metric: "up"}},
+		{"escapes", `x{msg="a\"b\n"}`, promSelector{Metric: "x", Matchers: []labelMatcher{{Label: "msg", Op: "=", Value: "a\"b\n"}}}},
+		{"aggregation name as metric", `sum{job="a"}`, promSelector{Metric: "sum", Matchers: []labelMatcher{{Label: "job", Op: "=", Value: "a"}}}},
+		{"number", "0.95", promNumber{Value: 0.95}},
+		{"rate", "rate(x[5m])", rate("x", 5*time.Minute)},
+		{"parentheses and comment", "((rate(x[1h]))) # per second", rate("x", time.Hour)},
+		{"by before", "sum by (job, instance) (rate(x[1m]))", promAggregation{Op: "sum", By: []string{"job", "instance"}, Expr: rate("x", time.Minute)}},
+		{"by after", "sum(rate(x[1m])) by (job, instance)", promAggregation{Op: "sum", By: []string{"job", "instance"}, Expr: rate("x", time.Minute)}},
+		{"quoted by label", `max by ("service.name") (x)`, promAggregation{Op: "max", By: []string{"service.name"}, Expr: promSelector{Metric: "x"}}},
+		{"aggregation without by", "count(x)", promAggregation{Op: "count", Expr: promSelector{Metric: "x"}}},
+		{
+			"histogram quantile",
+			"histogram_quantile(0.95, sum by (le) (rate(x_bucket[5m])))",
+			promCall{Func: "histogram_quantile", Args: []promExpr{
+				promNumber{Value: 0.95},
+				promAggregation{Op: "sum", By: []string{"le"}, Expr: rate("x_bucket", 5*time.Minute)},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePromQL(tt.query)
+			if err != nil {
+				t.Fatalf("parsePromQL(%q): %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePromQL(%q) = %#v, want %#v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePromQLErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		unsupported bool
+		wantErr     string
+	}{
+		{"binary operator", "a / b", true, "binary operator"},
+		{"comparison", "rate(x[5m]) > 1", true, "binary operator"},
+		{"set operator", "a and b", true, "set operator"},
+		{"unary minus", "-x", true, "unary operator"},
+		{"string literal", `"x"`, true, "string literal"},
+		{"selector subquery", "rate(x[5m:1m])", true, "subquery"},
+		{"expression subquery", "rate(x[5m])[1h:]", true, "subquery"},
+		{"offset", "x offset 5m", true, "offset"},
+		{"at modifier", "x @ 1700000000", true, "@ modifier"},
+		{"without", "sum without (job) (x)", true, "without"},
+		{"other aggregation", "topk(5, x)", true, "aggregation"},
+		{"aggregation parameter", "sum(x, y)", true, "parameter"},
+		{"other function", "abs(x)", true, "function"},
+		{"no metric name", `{job="api"}`, true, "without a metric name"},
+		{"regex on __name__", `{__name__=~"http_.*"}`, true, "metric name"},
+		{"unclosed braces", `x{job="a"`, false, "expected"},
+		{"unclosed call", "rate(x[5m]", false, `expected ")"`},
+		{"unquoted value", "x{job=a}", false, "quoted label value"},
+		{"bad range", "x[abc]", false, "range duration"},
+		{"invalid regex", `x{job=~"("}`, false, "invalid regular expression"},
+		{"unterminated string", `x{job="a}`, false, "unterminated string"},
+		{"unexpected character", "x $", false, `unexpected "$"`},
+		{"metric name twice", `x{"y"}`, false, "metric name given twice"},
+		{"trailing token", "x )", false, "unexpected"},
+		{"empty", "", false, "end of query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePromQL(tt.query)
+			if err == nil {
+				t.Fatalf("parsePromQL(%q) succeeded, want an error", tt.query)
+			}
+			if errors.Is(err, errPromQLUnsupported) != tt.unsupported {
+				t.Errorf("parsePromQL(%q) error %q: unsupported = %v, want %v", tt.query, err, !tt.unsupported, tt.unsupported)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePromQL(%q) error = %q, want it to contain %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLexPromQL(t *testing.T) {
+	tokens, err := lexPromQL(`rate({"a.b"}[5m]) >= 1e-3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, tok.Text)
+	}
+	want := []string{"rate", "(", "{", "a.b", "}", "[", "5m", "]", ")", ">=", "1e-3", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %q, want %q", got, want)
+	}
+	if last := tokens[len(tokens)-1]; last.Kind != promEOF {
+		t.Errorf("last token = %v, want end of query", last)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	// Buckets (-Inf, 1], (1, 2], (2, 5] and (5, +Inf) with 10 observations each
+	even := histogramBuckets{bounds: []float64{1, 2, 5}, counts: []float64{10, 10, 10, 10}}
+	tests := []struct {
+		name string
+		h    histogramBuckets
+		q    float64
+		want float64
+	}{
+		{"zero", even, 0, 0},
+		{"within the first bucket", even, 0.1, 0.4},
+		{"first bucket boundary", even, 0.25, 1},
+		{"median", even, 0.5, 2},
+		{"third bucket", even, 0.6, 3.2},
+		{"in the +Inf bucket", even, 0.9, 5},
+		{"one", even, 1, 5},
+		{"empty leading bucket", histogramBuckets{bounds: []float64{1, 2}, counts: []float64{0, 10, 0}}, 0.5, 1.5},
+		{"negative first bound", histogramBuckets{bounds: []float64{-1, 1}, counts: []float64{4, 4, 0}}, 0.25, -1},
+		{"empty histogram", histogramBuckets{bounds: []float64{1}, counts: []float64{0, 0}}, 0.5, math.NaN()},
+		{"no bounds", histogramBuckets{counts: []float64{5}}, 0.5, math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.h.quantile(tt.q)
+			if math.IsNaN(tt.want) {
+				if !math.IsNaN(got) {
+					t.Errorf("quantile(%g) = %g, want NaN", tt.q, got)
+				}
+				return
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("quantile(%g) = %g, want %g", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramBucketsAdd(t *testing.T) {
+	var h histogramBuckets
+	first := histogramBuckets{bounds: []float64{1, 2}, counts: []float64{1, 2, 3}}
+	if !h.add(first) || !h.add(histogramBuckets{bounds: []float64{1, 2}, counts: []float64{1, 1, 1}}) {
+		t.Fatal("add of matching buckets failed")
+	}
+	if want := []float64{2, 3, 4}; !reflect.DeepEqual(h.counts, want) {
+		t.Errorf("counts = %v, want %v", h.counts, want)
+	}
+	if want := []float64{1, 2, 3}; !reflect.DeepEqual(first.counts, want) {
+		t.Errorf("add changed the counts of its argument to %v", first.counts)
+	}
+	if h.add(histogramBuckets{bounds: []float64{1, 5}, counts: []float64{1, 1, 1}}) {
+		t.Error("add of different buckets succeeded")
+	}
+}
@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of query lookbacks kept per stream
+const maxQueryLookbacks = 1000
+
+// queryLookbacks records how far back queries run by users reach, per stream, since the server
+// started. Hot tier sizing uses it to cover the time ranges that are actually queried.
+var queryLookbacks = struct {
+	mu      sync.Mutex
+	streams map[string][]time.Duration
+}{streams: map[string][]time.Duration{}}
+
+// recordQueryLookback notes a query of stream starting at startTime (ISO 8601).
+func recordQueryLookback(stream string, startTime string) {
+	start, ok := parseTimestamp(startTime)
+	if !ok {
+		return
+	}
+	lookback := time.Since(start)
+	if lookback < 0 {
+		return
+	}
+	queryLookbacks.mu.Lock()
+	defer queryLookbacks.mu.Unlock()
+	lookbacks := append(queryLookbacks.streams[stream], lookback)
+	if len(lookbacks) > maxQueryLookbacks {
+		lookbacks = lookbacks[len(lookbacks)-maxQueryLookbacks:]
+	}
+	queryLookbacks.streams[stream] = lookbacks
+}
+
+// queryLookbackPercentile returns the p-th percentile (0-1) of the recorded lookbacks of
+// stream and the number of queries recorded.
+func queryLookbackPercentile(stream string, p float64) (time.Duration, int) {
+	queryLookbacks.mu.Lock()
+	lookbacks := append([]time.Duration(nil), queryLookbacks.streams[stream]...)
+	queryLookbacks.mu.Unlock()
+	if len(lookbacks) == 0 {
+		return 0, 0
+	}
+	sort.Slice(lookbacks, func(i, j int) bool { return lookbacks[i] < lookbacks[j] })
+	i := int(p * float64(len(lookbacks)-1))
+	return lookbacks[i], len(lookbacks)
+}
@@ -11,4 +11,57 @@ func RegisterParseableTools(mcpServer *server.MCPServer) {
 	RegisterGetAboutTool(mcpServer)
 	RegisterGetRolesTool(mcpServer)
 	RegisterGetUsersTool(mcpServer)
+	RegisterListAlertsTool(mcpServer)
+	RegisterGetAlertTool(mcpServer)
+	RegisterGetAlertStateHistoryTool(mcpServer)
+	RegisterCreateAlertTool(mcpServer)
+	RegisterUpdateAlertTool(mcpServer)
+	RegisterDeleteAlertTool(mcpServer)
+	RegisterListTargetsTool(mcpServer)
+	RegisterGetTargetTool(mcpServer)
+	RegisterCreateTargetTool(mcpServer)
+	RegisterDeleteTargetTool(mcpServer)
+	RegisterTestTargetTool(mcpServer)
+	RegisterListDashboardsTool(mcpServer)
+	RegisterGetDashboardTool(mcpServer)
+	RegisterRunDashboardTool(mcpServer)
+	RegisterGenerateDashboardTool(mcpServer)
+	RegisterListSavedQueriesTool(mcpServer)
+	RegisterGetSavedQueryTool(mcpServer)
+	RegisterRunSavedQueryTool(mcpServer)
+	RegisterSaveQueryTool(mcpServer)
+	RegisterGetRetentionTool(mcpServer)
+	RegisterSetRetentionTool(mcpServer)
+	RegisterGetHotTierTool(mcpServer)
+	RegisterSetHotTierTool(mcpServer)
+	RegisterDeleteHotTierTool(mcpServer)
+	RegisterListClusterNodesTool(mcpServer)
+	RegisterGetClusterMetricsTool(mcpServer)
+	RegisterClusterHealthReportTool(mcpServer)
+	RegisterCreateDataStreamTool(mcpServer)
+	RegisterUpdateDataStreamTool(mcpServer)
+	RegisterDeleteDataStreamTool(mcpServer)
+	RegisterIngestEventsTool(mcpServer)
+	RegisterCreateUserTool(mcpServer)
+	RegisterDeleteUserTool(mcpServer)
+	RegisterResetPasswordTool(mcpServer)
+	RegisterAssignRolesTool(mcpServer)
+	RegisterCreateRoleTool(mcpServer)
+	RegisterDeleteRoleTool(mcpServer)
+	RegisterAnalyzeAccessTool(mcpServer)
+	RegisterListUserGroupsTool(mcpServer)
+	RegisterGetUserGroupTool(mcpServer)
+	RegisterAddGroupMembersTool(mcpServer)
+	RegisterRemoveGroupMembersTool(mcpServer)
+	RegisterListCorrelationsTool(mcpServer)
+	RegisterRunCorrelationTool(mcpServer)
+	RegisterCorrelateStreamsTool(mcpServer)
+	RegisterGetTraceTool(mcpServer)
+	RegisterFindSlowTracesTool(mcpServer)
+	RegisterServiceDependencyMapTool(mcpServer)
+	RegisterQueryMetricTool(mcpServer)
+	RegisterPromQLQueryTool(mcpServer)
+	RegisterClusterLogPatternsTool(mcpServer)
+	RegisterDetectAnomaliesTool(mcpServer)
+	RegisterCompareWindowsTool(mcpServer)
 }
@@ -0,0 +1,273 @@
+package tools
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sqlKeywords are words that are never field names in Parseable SQL, including type names
+// and function-like keywords that can appear without parentheses.
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`select from where and or not in is null like ilike between group by order
+		having limit offset as on join inner left right full outer cross natural using union intersect except all
+		distinct case when then else end asc desc true false cast try_cast interval exists with over partition
+		rows range preceding following unbounded current row nulls first last filter within similar escape any some
+		values lateral qualify semi anti array struct date time timestamp int integer bigint smallint tinyint double
+		float real varchar char text string boolean bool decimal numeric unsigned year quarter month week day hour
+		minute second millisecond microsecond nanosecond epoch at zone current_date current_time current_timestamp`) {
+		sqlKeywords[k] = true
+	}
+}
+
+type sqlTokenKind int
+
+const (
+	sqlIdent sqlTokenKind = iota
+	sqlQuotedIdent
+	sqlString
+	sqlNumber
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// tokenizeSQL splits a query into identifiers, quoted identifiers ("x" or `x`), string
+// literals, numbers and symbols. Comments are skipped. It is only meant for inspecting
+// which tables and fields a query references, not for full parsing.
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	r := []rune(query)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			i += 2
+			for i+1 < len(r) && !(r[i] == '*' && r[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			var b strings.Builder
+			for j < len(r) {
+				if r[j] == c {
+					// Doubled quotes escape the quote character
+					if j+1 < len(r) && r[j+1] == c {
+						b.WriteRune(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteRune(r[j])
+				j++
+			}
+			kind := sqlQuotedIdent
+			if c == '\'' {
+				kind = sqlString
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: b.String()})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlIdent, text: string(r[i:j])})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.' || r[j] == 'e' || r[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlNumber, text: string(r[i:j])})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{kind: sqlSymbol, text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func (t sqlToken) isIdent() bool {
+	return t.kind == sqlQuotedIdent || (t.kind == sqlIdent && !sqlKeywords[strings.ToLower(t.text)])
+}
+
+func (t sqlToken) isKeyword(keyword string) bool {
+	return t.kind == sqlIdent && strings.EqualFold(t.text, keyword)
+}
+
+// sqlReferences returns the tables a query reads from (after FROM and JOIN) and the field
+// names it references. Function names, column and table aliases and qualifiers
+// are left out, so the columns can be checked against the stream schemas.
+func sqlReferences(query string) (tables []string, columns []string) {
+	tokens := tokenizeSQL(query)
+	excluded := map[string]bool{}
+	var candidates []string
+	seenTable := map[string]bool{}
+	seenColumn := map[string]bool{}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.isKeyword("from") || t.isKeyword("join") {
+			if i+1 < len(tokens) && tokens[i+1].isIdent() {
+				table := tokens[i+1].text
+				if !seenTable[table] {
+					seenTable[table] = true
+					tables = append(tables, table)
+				}
+				excluded[table] = true
+				i++
+				// Optional table alias: FROM stream s or FROM stream AS s
+				if i+1 < len(tokens) && tokens[i+1].isKeyword("as") {
+					i++
+				}
+				if i+1 < len(tokens) && tokens[i+1].isIdent() {
+					excluded[tokens[i+1].text] = true
+					i++
+				}
+			}
+			continue
+		}
+		if !t.isIdent() {
+			continue
+		}
+		next := func(k int) (sqlToken, bool) {
+			if i+k < len(tokens) {
+				return tokens[i+k], true
+			}
+			return sqlToken{}, false
+		}
+		if n, ok := next(1); ok && n.kind == sqlSymbol && n.text == "(" {
+			// Function call
+			continue
+		}
+		if i > 0 && (tokens[i-1].isKeyword("as") || tokens[i-1].kind == sqlSymbol && tokens[i-1].text == ")") {
+			// Alias, also without AS after a call or subquery: count(*) n, (SELECT ...) t
+			excluded[t.text] = true
+			continue
+		}
+		if n, ok := next(1); ok && n.kind == sqlSymbol && n.text == "." {
+			// Qualifier of a qualified name such as s.field
+			excluded[t.text] = true
+			continue
+		}
+		candidates = append(candidates, t.text)
+	}
+	for _, c := range candidates {
+		if !excluded[c] && !seenColumn[c] {
+			seenColumn[c] = true
+			columns = append(columns, c)
+		}
+	}
+	return tables, columns
+}
+
+// sqlClauses are the keywords that start a clause of a query, as tracked by maskedReferences.
+var sqlClauses = map[string]bool{"select": true, "from": true, "join": true, "on": true, "using": true, "where": true,
+	"group": true, "having": true, "order": true, "limit": true, "offset": true, "qualify": true, "union": true,
+	"intersect": true, "except": true}
+
+// maskedReferences returns the fields of a query for which masked reports true and that the query
+// uses other than as a plain column: a bare column of the select list without alias, or a bare
+// column of GROUP BY or ORDER BY. Redaction rules match result columns by name, so an alias
+// (email AS contact), an expression (lower(email)) or a condition (WHERE email = 'x') would
+// get around them. So would a set operation, which returns the columns of every branch under
+// the names of the first, so no branch after UNION, INTERSECT or EXCEPT may use masked fields.
+func maskedReferences(query string, masked func(field string) bool) []string {
+	tokens := tokenizeSQL(query)
+	// The clause at each parenthesis depth, the arguments of a call have none, and whether
+	// the depth or one around it follows a set operation
+	type scope struct {
+		clause string
+		setOp  bool
+	}
+	scopes := []scope{{}}
+	var fields []string
+	seen := map[string]bool{}
+	for i, t := range tokens {
+		current := &scopes[len(scopes)-1]
+		if t.kind == sqlSymbol && t.text == "(" {
+			scopes = append(scopes, scope{setOp: current.setOp})
+			continue
+		}
+		if t.kind == sqlSymbol && t.text == ")" {
+			if len(scopes) > 1 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			continue
+		}
+		if t.kind == sqlIdent && sqlClauses[strings.ToLower(t.text)] {
+			current.clause = strings.ToLower(t.text)
+			switch current.clause {
+			case "union", "intersect", "except":
+				current.setOp = true
+			}
+			continue
+		}
+		if !t.isIdent() || !masked(t.text) {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].kind == sqlSymbol && (tokens[i+1].text == "(" || tokens[i+1].text == ".") {
+			// Function name or qualifier
+			continue
+		}
+		if i > 0 && (tokens[i-1].isKeyword("as") || tokens[i-1].isKeyword("from") || tokens[i-1].isKeyword("join")) {
+			// Alias or table name
+			continue
+		}
+		if (!current.setOp && plainColumn(tokens, i, current.clause)) || seen[t.text] {
+			continue
+		}
+		seen[t.text] = true
+		fields = append(fields, t.text)
+	}
+	return fields
+}
+
+// plainColumn reports whether the field at tokens[i], optionally qualified, stands on its own in
+// the select list, GROUP BY or ORDER BY.
+func plainColumn(tokens []sqlToken, i int, clause string) bool {
+	start := i
+	if i >= 2 && tokens[i-1].kind == sqlSymbol && tokens[i-1].text == "." && tokens[i-2].isIdent() {
+		start = i - 2
+	}
+	var prev, next sqlToken
+	if start > 0 {
+		prev = tokens[start-1]
+	}
+	if i+1 < len(tokens) {
+		next = tokens[i+1]
+	}
+	separator := prev.kind == sqlSymbol && prev.text == ","
+	end := i+1 == len(tokens) || (next.kind == sqlSymbol && (next.text == "," || next.text == ")" || next.text == ";"))
+	switch clause {
+	case "select":
+		return (separator || prev.isKeyword("select") || prev.isKeyword("distinct")) && (end || next.isKeyword("from"))
+	case "group", "order":
+		return (separator || prev.isKeyword("by")) && (end || (next.kind == sqlIdent && sqlKeywords[strings.ToLower(next.text)]))
+	}
+	return false
+}
+
+// quoteIdent quotes a table or field name for use in generated SQL.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a string literal for use in generated SQL.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
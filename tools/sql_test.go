@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLReferences(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantTables  []string
+		wantColumns []string
+	}{
+		{"plain", "SELECT level, message FROM app_logs", []string{"app_logs"}, []string{"level", "message"}},
+		{"aliases", "SELECT count(*) AS n, host AS h FROM app_logs GROUP BY h ORDER BY n DESC", []string{"app_logs"}, []string{"host"}},
+		{"functions", "SELECT lower(email), date_trunc('hour', p_timestamp) FROM users", []string{"users"}, []string{"email", "p_timestamp"}},
+		{"where and having", "SELECT host FROM logs WHERE status >= 500 AND path LIKE '/api%' GROUP BY host HAVING count(*) > 10", []string{"logs"}, []string{"host", "status", "path"}},
+		{"quoted names", `SELECT "service.name" FROM "otel-traces" WHERE "status code" = 2`, []string{"otel-traces"}, []string{"service.name", "status code"}},
+		{"table aliases and qualifiers", "SELECT a.id, b.name FROM orders a JOIN users AS b ON a.user_id = b.id", []string{"orders", "users"}, []string{"id", "name", "user_id"}},
+		{"subquery", "SELECT n FROM (SELECT count(*) AS n FROM logs WHERE level = 'error') t", []string{"logs"}, []string{"level"}},
+		{"set operation", "SELECT name FROM a UNION ALL SELECT name FROM b", []string{"a", "b"}, []string{"name"}},
+		{"comments and strings", "SELECT host -- the host\nFROM logs /* where x */ WHERE msg = 'from y'", []string{"logs"}, []string{"host", "msg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables, columns := sqlReferences(tt.query)
+			if !reflect.DeepEqual(tables, tt.wantTables) || !reflect.DeepEqual(columns, tt.wantColumns) {
+				t.Errorf("sqlReferences(%q) = %q, %q; want %q, %q", tt.query, tables, columns, tt.wantTables, tt.wantColumns)
+			}
+		})
+	}
+}
+
+func TestMaskedReferences(t *testing.T) {
+	masked := func(field string) bool { return field == "email" || field == "password" }
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"plain columns", "SELECT email, host FROM users", nil},
+		{"distinct", "SELECT DISTINCT email FROM users", nil},
+		{"qualified column", "SELECT u.email FROM users u", nil},
+		{"group and order by", "SELECT email, count(*) FROM users GROUP BY email ORDER BY email DESC", nil},
+		{"alias", "SELECT email AS contact FROM users", []string{"email"}},
+		{"implicit alias", "SELECT email contact FROM users", []string{"email"}},
+		{"function", "SELECT lower(email) FROM users", []string{"email"}},
+		{"expression", "SELECT email || '' FROM users", []string{"email"}},
+		{"aggregate", "SELECT count(DISTINCT email) FROM users", []string{"email"}},
+		{"where", "SELECT host FROM users WHERE password LIKE 'a%'", []string{"password"}},
+		{"having", "SELECT host FROM users GROUP BY host HAVING max(password) > 'm'", []string{"password"}},
+		{"join condition", "SELECT a.host FROM users a JOIN logins b ON a.email = b.email", []string{"email"}},
+		{"subquery condition", "SELECT host FROM logs WHERE user_id IN (SELECT id FROM users WHERE email = 'x')", []string{"email"}},
+		{"plain column of a subquery", "SELECT email FROM (SELECT email FROM users) t", nil},
+		{"alias in a subquery", "SELECT contact FROM (SELECT email AS contact FROM users) t", []string{"email"}},
+		{"first branch of a set operation", "SELECT email FROM users UNION ALL SELECT host FROM users", nil},
+		{"later branch of a set operation", "SELECT name FROM users UNION ALL SELECT password FROM users", []string{"password"}},
+		{"parenthesized branch", "(SELECT name FROM users) EXCEPT (SELECT email FROM users)", []string{"email"}},
+		{"set operation in a subquery", "SELECT x FROM (SELECT name AS x FROM users INTERSECT SELECT email FROM users) t", []string{"email"}},
+		{"quoted names", `SELECT "email" FROM users WHERE "password" = 'x'`, []string{"password"}},
+		{"string literal", "SELECT host FROM users WHERE note = 'email'", nil},
+		{"unmasked fields", "SELECT lower(host) AS h FROM users WHERE status = 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskedReferences(tt.query, masked); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("maskedReferences(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
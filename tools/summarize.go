@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Rough number of bytes of JSON per LLM token, used to estimate result size
+	bytesPerToken = 4
+	// Number of most frequent values reported per column in a digest
+	digestTopValues = 5
+	// Longer values are truncated in digest top values and min/max
+	digestMaxValueLen = 200
+)
+
+// Summarize modes supported by query_data_stream
+const (
+	summarizeAuto   = "auto"
+	summarizeAlways = "always"
+	summarizeNever  = "never"
+)
+
+var summarizeModes = []string{summarizeAuto, summarizeAlways, summarizeNever}
+
+// timestampLayouts are the layouts Parseable uses for timestamps in query results.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"}
+
+type valueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type columnDigest struct {
+	Type      string       `json:"type,omitempty"`
+	NonNull   int          `json:"nonNull"`
+	NullRatio float64      `json:"nullRatio"`
+	Distinct  int          `json:"distinct"`
+	TopValues []valueCount `json:"topValues,omitempty"`
+	Min       interface{}  `json:"min,omitempty"`
+	Max       interface{}  `json:"max,omitempty"`
+}
+
+type timeSpan struct {
+	Field string `json:"field"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// estimateTokens estimates how many LLM tokens the JSON encoding of v uses.
+func estimateTokens(v interface{}) int {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(b) / bytesPerToken
+}
+
+// shouldSummarize reports whether rows are returned as a digest: always in mode always, never
+// in mode never, and in mode auto when their estimated size exceeds a budget above zero. The
+// estimated tokens are returned when they were computed, zero otherwise.
+func shouldSummarize(mode string, rows []map[string]interface{}, budget int) (bool, int) {
+	switch mode {
+	case summarizeAlways:
+		return true, 0
+	case summarizeAuto:
+		if budget <= 0 {
+			return false, 0
+		}
+		tokens := estimateTokens(rows)
+		return tokens > budget, tokens
+	}
+	return false, 0
+}
+
+// summarizeRows builds a digest of query rows: per column statistics, the time span covered
+// and a sample of rows spread evenly over the result.
+func summarizeRows(rows []map[string]interface{}, schemaOrder []string, fieldTypes map[string]string, sampleSize int) map[string]interface{} {
+	columns := resultColumns(rows, schemaOrder)
+	digests := make(map[string]*columnDigest, len(columns))
+	for _, c := range columns {
+		digests[c] = summarizeColumn(rows, c, fieldTypes[c])
+	}
+
+	digest := map[string]interface{}{
+		"columns":     digests,
+		"columnOrder": columns,
+		"sample":      sampleRows(rows, sampleSize),
+	}
+	if span := resultTimeSpan(rows, columns, fieldTypes); span != nil {
+		digest["timeSpan"] = span
+	}
+	return digest
+}
+
+func summarizeColumn(rows []map[string]interface{}, column string, dataType string) *columnDigest {
+	d := &columnDigest{Type: dataType}
+	counts := map[string]int{}
+	var minNum, maxNum float64
+	var minStr, maxStr string
+	numeric, textual := false, false
+	for _, row := range rows {
+		v, ok := row[column]
+		if !ok || v == nil {
+			continue
+		}
+		d.NonNull++
+		counts[cellText(v)]++
+		switch value := v.(type) {
+		case float64:
+			if !numeric || value < minNum {
+				minNum = value
+			}
+			if !numeric || value > maxNum {
+				maxNum = value
+			}
+			numeric = true
+		case string:
+			if !textual || value < minStr {
+				minStr = value
+			}
+			if !textual || value > maxStr {
+				maxStr = value
+			}
+			textual = true
+		}
+	}
+	if len(rows) > 0 {
+		d.NullRatio = float64(len(rows)-d.NonNull) / float64(len(rows))
+	}
+	d.Distinct = len(counts)
+
+	// Values seen once are left out, top values of unique columns would only be noise
+	top := make([]valueCount, 0, len(counts))
+	for value, count := range counts {
+		if count > 1 {
+			top = append(top, valueCount{Value: truncateValue(value), Count: count})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > digestTopValues {
+		top = top[:digestTopValues]
+	}
+	d.TopValues = top
+
+	// Columns holding both numbers and strings report the numeric range
+	switch {
+	case numeric:
+		d.Min, d.Max = typedValue(minNum, dataType), typedValue(maxNum, dataType)
+	case textual:
+		d.Min, d.Max = truncateValue(minStr), truncateValue(maxStr)
+	}
+	return d
+}
+
+// resultTimeSpan returns the range of p_timestamp, or of the first timestamp typed column.
+func resultTimeSpan(rows []map[string]interface{}, columns []string, fieldTypes map[string]string) *timeSpan {
+	field := ""
+	for _, c := range columns {
+		if c == "p_timestamp" {
+			field = c
+			break
+		}
+		if field == "" && strings.HasPrefix(strings.ToLower(fieldTypes[c]), "timestamp") {
+			field = c
+		}
+	}
+	if field == "" {
+		return nil
+	}
+	var span *timeSpan
+	var start, end time.Time
+	for _, row := range rows {
+		s, ok := row[field].(string)
+		if !ok {
+			continue
+		}
+		t, ok := parseTimestamp(s)
+		if !ok {
+			continue
+		}
+		if span == nil {
+			span = &timeSpan{Field: field, Start: s, End: s}
+			start, end = t, t
+			continue
+		}
+		if t.Before(start) {
+			start, span.Start = t, s
+		}
+		if t.After(end) {
+			end, span.End = t, s
+		}
+	}
+	return span
+}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// sampleRows picks up to n rows evenly spaced over the result, always including the first
+// and last row, so the sample covers the whole result rather than just its head.
+func sampleRows(rows []map[string]interface{}, n int) []map[string]interface{} {
+	if n <= 0 {
+		return []map[string]interface{}{}
+	}
+	if len(rows) <= n {
+		return rows
+	}
+	if n == 1 {
+		return rows[:1]
+	}
+	sample := make([]map[string]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		sample = append(sample, rows[i*(len(rows)-1)/(n-1)])
+	}
+	return sample
+}
+
+func truncateValue(s string) string {
+	if len(s) <= digestMaxValueLen {
+		return s
+	}
+	// Cut on a rune boundary
+	cut := digestMaxValueLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
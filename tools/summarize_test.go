@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShouldSummarize(t *testing.T) {
+	// Each row encodes to {"msg":"xxxxxxxx"}, 18 bytes, so 10 rows are 191 bytes or 47 tokens
+	rows := make([]map[string]interface{}, 10)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"msg": "xxxxxxxx"}
+	}
+	tests := []struct {
+		name       string
+		mode       string
+		budget     int
+		want       bool
+		wantTokens int
+	}{
+		{"auto over budget", summarizeAuto, 46, true, 47},
+		{"auto at budget", summarizeAuto, 47, false, 47},
+		{"auto under budget", summarizeAuto, 1000, false, 47},
+		{"auto without budget", summarizeAuto, 0, false, 0},
+		{"always under budget", summarizeAlways, 1000, true, 0},
+		{"never over budget", summarizeNever, 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, tokens := shouldSummarize(tt.mode, rows, tt.budget)
+			if got != tt.want || tokens != tt.wantTokens {
+				t.Errorf("shouldSummarize = %v, %d, want %v, %d", got, tokens, tt.want, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestSummarizeColumn(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"status": 200.0, "level": "info", "mixed": "a"},
+		{"status": 500.0, "level": "error", "mixed": 3.0},
+		{"status": 200.0, "level": "info"},
+		{"status": nil, "level": "info", "mixed": 1.0},
+		{"level": "warn"},
+	}
+	tests := []struct {
+		column   string
+		dataType string
+		want     *columnDigest
+	}{
+		{"status", "Int64", &columnDigest{
+			Type: "Int64", NonNull: 3, NullRatio: 0.4, Distinct: 2,
+			TopValues: []valueCount{{Value: "200", Count: 2}},
+			Min:       int64(200), Max: int64(500),
+		}},
+		{"level", "Utf8", &columnDigest{
+			Type: "Utf8", NonNull: 5, NullRatio: 0, Distinct: 3,
+			TopValues: []valueCount{{Value: "info", Count: 3}},
+			Min:       "error", Max: "warn",
+		}},
+		{"mixed", "", &columnDigest{
+			NonNull: 3, NullRatio: 0.4, Distinct: 3,
+			TopValues: []valueCount{},
+			Min:       1.0, Max: 3.0,
+		}},
+		{"missing", "", &columnDigest{NullRatio: 1, TopValues: []valueCount{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.column, func(t *testing.T) {
+			if got := summarizeColumn(rows, tt.column, tt.dataType); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summarizeColumn = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeColumnTopValues(t *testing.T) {
+	var rows []map[string]interface{}
+	for i, value := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		for n := 0; n < 8-i; n++ {
+			rows = append(rows, map[string]interface{}{"v": value})
+		}
+	}
+	long := strings.Repeat("é", digestMaxValueLen)
+	rows = append(rows, map[string]interface{}{"v": long}, map[string]interface{}{"v": long})
+
+	d := summarizeColumn(rows, "v", "Utf8")
+	want := []valueCount{{"a", 8}, {"b", 7}, {"c", 6}, {"d", 5}, {"e", 4}}
+	if !reflect.DeepEqual(d.TopValues, want) {
+		t.Errorf("TopValues = %v, want %v", d.TopValues, want)
+	}
+	if max := d.Max.(string); len(max) > digestMaxValueLen+3 || !strings.HasSuffix(max, "...") {
+		t.Errorf("Max was not truncated: %d bytes", len(max))
+	}
+}
+
+func TestSummarizeRows(t *testing.T) {
+	var rows []map[string]interface{}
+	for _, ts := range []string{"2024-05-01T10:00:02.000", "2024-05-01T10:00:00.000", "2024-05-01T10:00:09.500", "2024-05-01T10:00:05.000"} {
+		rows = append(rows, map[string]interface{}{"p_timestamp": ts, "level": "info"})
+	}
+	digest := summarizeRows(rows, []string{"p_timestamp", "level"}, map[string]string{"level": "Utf8"}, 2)
+
+	if got, want := digest["columnOrder"], []string{"p_timestamp", "level"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("columnOrder = %v, want %v", got, want)
+	}
+	want := &timeSpan{Field: "p_timestamp", Start: "2024-05-01T10:00:00.000", End: "2024-05-01T10:00:09.500"}
+	if got := digest["timeSpan"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("timeSpan = %+v, want %+v", got, want)
+	}
+	if got := digest["sample"]; !reflect.DeepEqual(got, []map[string]interface{}{rows[0], rows[3]}) {
+		t.Errorf("sample = %v, want the first and last row", got)
+	}
+	if _, ok := summarizeRows(rows, nil, nil, 2)["timeSpan"]; !ok {
+		t.Error("p_timestamp is used without a schema")
+	}
+	if _, ok := summarizeRows([]map[string]interface{}{{"n": 1.0}}, nil, nil, 2)["timeSpan"]; ok {
+		t.Error("timeSpan reported without a timestamp column")
+	}
+}
+
+func TestSampleRows(t *testing.T) {
+	rows := make([]map[string]interface{}, 10)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"i": i}
+	}
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{0, []int{}},
+		{1, []int{0}},
+		{2, []int{0, 9}},
+		{4, []int{0, 3, 6, 9}},
+		{10, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{20, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	for _, tt := range tests {
+		got := []int{}
+		for _, row := range sampleRows(rows, tt.n) {
+			got = append(got, row["i"].(int))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sampleRows(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
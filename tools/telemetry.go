@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mcp-pb/redact"
+)
+
+// telemetryStreamFields checks that a stream holds the given telemetry type, as reported by
+// the stream info, and returns the field types of its schema. Streams that do not report a
+// telemetry type are accepted, since older Parseable versions do not set it.
+func telemetryStreamFields(ctx context.Context, stream string, telemetryType string) (map[string]string, error) {
+	info, err := getParseableInfo(ctx, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info of stream %q: %w", stream, err)
+	}
+	if t, _ := info["telemetryType"].(string); t != "" && t != telemetryType {
+		return nil, fmt.Errorf("stream %q holds %s, not %s", stream, t, telemetryType)
+	}
+	schema, err := getParseableSchema(ctx, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema of stream %q: %w", stream, err)
+	}
+	return redact.SchemaFieldTypes(schema), nil
+}
+
+// findField returns the first candidate field name that is in the schema, or "" if none is.
+// OpenTelemetry fields are named differently depending on how they were flattened.
+func findField(types map[string]string, candidates ...string) string {
+	for _, name := range candidates {
+		if _, ok := types[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func isIntegerType(dataType string) bool {
+	t := strings.ToLower(dataType)
+	return strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint")
+}
+
+// nanosExpr is SQL for a time column as nanoseconds since the epoch. Integer columns are
+// taken to hold nanoseconds already, as the OpenTelemetry *_unix_nano fields do; timestamps
+// and strings are converted.
+func nanosExpr(column string, dataType string) string {
+	if isIntegerType(dataType) {
+		return column
+	}
+	return fmt.Sprintf("CAST(to_timestamp_nanos(%s) AS BIGINT)", column)
+}
+
+// statusErrorExpr is SQL that is true for an OpenTelemetry error status: code 2, or a status
+// name containing "error" such as STATUS_CODE_ERROR.
+func statusErrorExpr(column string, dataType string) string {
+	if isIntegerType(dataType) {
+		return column + " = 2"
+	}
+	return fmt.Sprintf("CAST(%s AS VARCHAR) ILIKE '%%error%%'", column)
+}
+
+// isErrorStatus is statusErrorExpr for a status value of a result row.
+func isErrorStatus(v interface{}) bool {
+	switch s := v.(type) {
+	case float64:
+		return s == 2
+	case string:
+		return strings.Contains(strings.ToLower(s), "error")
+	}
+	return false
+}
+
+// unixNanos reads a time value of a result row: nanoseconds since the epoch, or a timestamp.
+func unixNanos(v interface{}) (int64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return int64(t), true
+	case string:
+		if ts, ok := parseTimestamp(t); ok {
+			return ts.UnixNano(), true
+		}
+	}
+	return 0, false
+}